@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"sched-latency/config"
+)
+
+var errAffinityUnsupported error
+
+// setThreadAffinity sets the CPU affinity of the calling OS thread.
+func setThreadAffinity(s config.CPUSet) error {
+	mask := make([]uint64, s[len(s)-1]/64+1)
+	for _, cpu := range s {
+		mask[cpu/64] |= 1 << (cpu % 64)
+	}
+
+	// A pid of 0 refers to the calling thread.
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// onlineCPUs returns the set of online CPUs.
+func onlineCPUs() map[int]bool {
+	online := make(map[int]bool)
+	contents, err := os.ReadFile("/sys/devices/system/cpu/online")
+	set, parseErr := config.ParseCPUList(strings.TrimSpace(string(contents)))
+	if err != nil || parseErr != nil {
+		for cpu := 0; cpu < runtime.NumCPU(); cpu++ {
+			online[cpu] = true
+		}
+		return online
+	}
+
+	for _, cpu := range set {
+		online[cpu] = true
+	}
+	return online
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+
+	"sched-latency/config"
+)
+
+var errAffinityUnsupported = errors.New("CPU affinity is only supported on Linux")
+
+func setThreadAffinity(config.CPUSet) error {
+	return errAffinityUnsupported
+}
+
+func onlineCPUs() map[int]bool {
+	return nil
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sched-latency/config"
+	"sched-latency/stats"
+)
+
+// recordedProbe is a probe's samples read from a recording.
+type recordedProbe struct {
+	name    string
+	samples []recordedSample
+}
+
+type recordedSample struct {
+	at    time.Duration // since the start of the recording
+	delay time.Duration
+}
+
+// readRecording reads a recording written by -record, with its probes in the
+// order they were registered.
+func readRecording(path string) (recordingHeader, []*recordedProbe, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return recordingHeader{}, nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != recordMagic {
+		return recordingHeader{}, nil, fmt.Errorf("%v isn't a recording from -record", path)
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return recordingHeader{}, nil, fmt.Errorf("failed to read %v header: %v", path, err)
+	}
+	headerBytes := make([]byte, n)
+	if _, err := io.ReadFull(r, headerBytes); err != nil {
+		return recordingHeader{}, nil, fmt.Errorf("failed to read %v header: %v", path, err)
+	}
+	var header recordingHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return recordingHeader{}, nil, fmt.Errorf("failed to parse %v header: %v", path, err)
+	}
+	if header.Version != recordVersion {
+		return recordingHeader{}, nil, fmt.Errorf("recording %v has version %d, but only version %d is supported", path, header.Version, recordVersion)
+	}
+
+	var probes []*recordedProbe
+	byID := make(map[uint64]*recordedProbe)
+	for {
+		typ, err := r.ReadByte()
+		if err == io.EOF {
+			return header, probes, nil
+		}
+		if err != nil {
+			return header, probes, err
+		}
+
+		id, err := binary.ReadUvarint(r)
+		if err != nil {
+			return header, probes, truncatedRecording(path, err)
+		}
+		switch typ {
+		case recordProbe:
+			n, err := binary.ReadUvarint(r)
+			if err != nil {
+				return header, probes, truncatedRecording(path, err)
+			}
+			name := make([]byte, n)
+			if _, err := io.ReadFull(r, name); err != nil {
+				return header, probes, truncatedRecording(path, err)
+			}
+			p := &recordedProbe{name: string(name)}
+			probes = append(probes, p)
+			byID[id] = p
+		case recordSample:
+			at, err := binary.ReadVarint(r)
+			if err != nil {
+				return header, probes, truncatedRecording(path, err)
+			}
+			delay, err := binary.ReadVarint(r)
+			if err != nil {
+				return header, probes, truncatedRecording(path, err)
+			}
+			p, ok := byID[id]
+			if !ok {
+				return header, probes, fmt.Errorf("recording %v has a sample for unknown probe %d", path, id)
+			}
+			p.samples = append(p.samples, recordedSample{time.Duration(at), time.Duration(delay)})
+		default:
+			return header, probes, fmt.Errorf("recording %v has unknown record type %d", path, typ)
+		}
+	}
+}
+
+// truncatedRecording is the error for a recording that ends mid-record,
+// such as when the run was killed.
+func truncatedRecording(path string, err error) error {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("recording %v is truncated: %v", path, err)
+}
+
+// analysis is the result of analyzing a recording.
+type analysis struct {
+	Header recordingHeader `json:"header"`
+	Probes []probeAnalysis `json:"probes"`
+}
+
+type probeAnalysis struct {
+	Name          string           `json:"name"`
+	Samples       int              `json:"samples"`
+	PercentilesNs map[string]int64 `json:"percentiles_ns"`
+	Windows       []windowAnalysis `json:"windows,omitempty"`
+	Worst         []worstSample    `json:"worst,omitempty"`
+	Histogram     []histBucket     `json:"histogram,omitempty"`
+}
+
+type windowAnalysis struct {
+	StartNs       int64            `json:"start_ns"`
+	Samples       int              `json:"samples"`
+	PercentilesNs map[string]int64 `json:"percentiles_ns"`
+}
+
+type worstSample struct {
+	AtNs    int64 `json:"at_ns"`
+	DelayNs int64 `json:"delay_ns"`
+}
+
+// histBucket counts samples with delays up to UpperNs, and above the
+// previous bucket's bound.
+type histBucket struct {
+	UpperNs int64 `json:"upper_ns"`
+	Count   int   `json:"count"`
+}
+
+// analyzeOptions select what to compute from a recording.
+type analyzeOptions struct {
+	percentiles []float64
+	probe       string
+	from, to    time.Duration
+	window      time.Duration
+	worst       int
+	histogram   bool
+}
+
+func analyzeRecording(header recordingHeader, probes []*recordedProbe, opts analyzeOptions) analysis {
+	cfg := Config{Config: config.Config{Percentiles: opts.percentiles}}
+	a := analysis{Header: header}
+	for _, p := range probes {
+		if opts.probe != "" && p.name != opts.probe {
+			continue
+		}
+
+		var samples []recordedSample
+		for _, s := range p.samples {
+			if s.at >= opts.from && (opts.to == 0 || s.at < opts.to) {
+				samples = append(samples, s)
+			}
+		}
+
+		pa := probeAnalysis{
+			Name:          p.name,
+			Samples:       len(samples),
+			PercentilesNs: percentilesByName(cfg, samples),
+		}
+		if opts.window > 0 {
+			for start := 0; start < len(samples); {
+				windowStart := samples[start].at.Truncate(opts.window)
+				end := start
+				for end < len(samples) && samples[end].at < windowStart+opts.window {
+					end++
+				}
+				pa.Windows = append(pa.Windows, windowAnalysis{
+					StartNs:       int64(windowStart),
+					Samples:       end - start,
+					PercentilesNs: percentilesByName(cfg, samples[start:end]),
+				})
+				start = end
+			}
+		}
+		if opts.worst > 0 {
+			sorted := append([]recordedSample(nil), samples...)
+			sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].delay > sorted[j].delay })
+			if len(sorted) > opts.worst {
+				sorted = sorted[:opts.worst]
+			}
+			for _, s := range sorted {
+				pa.Worst = append(pa.Worst, worstSample{AtNs: int64(s.at), DelayNs: int64(s.delay)})
+			}
+		}
+		if opts.histogram {
+			pa.Histogram = log2Histogram(samples)
+		}
+		a.Probes = append(a.Probes, pa)
+	}
+	return a
+}
+
+func percentilesByName(cfg Config, samples []recordedSample) map[string]int64 {
+	delays := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		delays[i] = s.delay
+	}
+	byName := make(map[string]int64, len(cfg.Percentiles))
+	for i, v := range cfg.SamplePercentiles(delays) {
+		byName[stats.PercentileName(cfg.Percentiles[i])] = int64(v)
+	}
+	return byName
+}
+
+// log2Histogram buckets the delays by powers of two nanoseconds, from the
+// smallest to the largest non-empty bucket.
+func log2Histogram(samples []recordedSample) []histBucket {
+	var counts [65]int
+	lo, hi := len(counts), -1
+	for _, s := range samples {
+		var b int
+		if s.delay > 0 {
+			b = bits.Len64(uint64(s.delay - 1))
+		}
+		counts[b]++
+		if b < lo {
+			lo = b
+		}
+		if b > hi {
+			hi = b
+		}
+	}
+
+	var buckets []histBucket
+	for b := lo; b <= hi; b++ {
+		buckets = append(buckets, histBucket{UpperNs: 1 << b, Count: counts[b]})
+	}
+	return buckets
+}
+
+// parsePercentiles parses a comma-separated list of percentiles in [0, 1].
+func parsePercentiles(s string) ([]float64, error) {
+	var ps []float64
+	for _, part := range strings.Split(s, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("invalid percentile %q, must be between 0 and 1", part)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// analyzeMain analyzes a recording written by -record.
+func analyzeMain(args []string) {
+	fs := newSubcommandFlags("analyze", "[flags] samples.bin")
+	percentilesFlag := fs.String("percentiles", "0,0.5,0.99,1", "Comma-separated percentiles to compute, between 0 and 1")
+	probe := fs.String("probe", "", "Only analyze this probe, by name or -fail-if alias such as sleep")
+	from := fs.Duration("from", 0, "Only analyze samples taken at least this long after the start")
+	to := fs.Duration("to", 0, "Only analyze samples taken before this long after the start (0 for the end)")
+	window := fs.Duration("window", 0, "Also compute percentiles over windows of this length (0 disables)")
+	worst := fs.Int("worst", 0, "Also list this many of the largest samples, with when they were taken")
+	histogram := fs.Bool("histogram", false, "Also print a histogram of each probe's samples")
+	format := fs.String("format", "text", "Output format: text, json or csv (csv only has percentiles)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitSetupError)
+	}
+
+	opts := analyzeOptions{
+		probe:     *probe,
+		from:      *from,
+		to:        *to,
+		window:    *window,
+		worst:     *worst,
+		histogram: *histogram,
+	}
+	var err error
+	if opts.percentiles, err = parsePercentiles(*percentilesFlag); err != nil {
+		fatalf("invalid -percentiles: %v", err)
+	}
+	if full, ok := config.ProbeAliases[opts.probe]; ok {
+		opts.probe = full
+	}
+	if *to != 0 && *to <= *from {
+		fatalf("-to (%v) must be after -from (%v)", *to, *from)
+	}
+
+	header, probes, err := readRecording(fs.Arg(0))
+	if err != nil {
+		if header.Version == 0 {
+			fatalf("%v", err)
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: %v, analyzing the samples before that\n", err)
+	}
+	a := analyzeRecording(header, probes, opts)
+	if opts.probe != "" && len(a.Probes) == 0 {
+		fatalf("recording has no probe %q", opts.probe)
+	}
+
+	switch *format {
+	case "text":
+		printAnalysis(a, opts)
+	case "json":
+		b, err := json.MarshalIndent(a, "", "  ")
+		if err != nil {
+			fatalf("%v", err)
+		}
+		fmt.Println(string(b))
+	case "csv":
+		writeAnalysisCSV(os.Stdout, a, opts)
+	default:
+		fatalf(`-format must be "text", "json" or "csv", got %q`, *format)
+	}
+}
+
+func printAnalysis(a analysis, opts analyzeOptions) {
+	if a.Header.Label != "" {
+		fmt.Printf("Label: %v\n", a.Header.Label)
+	}
+	fmt.Printf("Recording: started %v, %v %v/%v, %d CPUs, GOMAXPROCS %d\n",
+		a.Header.Start.Format(time.RFC3339), a.Header.GoVersion, a.Header.GOOS, a.Header.GOARCH, a.Header.NumCPU, a.Header.GOMAXPROCS)
+	if a.Header.Build.Version != "" {
+		fmt.Printf("Build: %v\n", a.Header.Build)
+	}
+	if a.Header.Tags != nil {
+		fmt.Printf("Tags: %v\n", a.Header.Tags)
+	}
+	fmt.Printf("Flags: %v\n", a.Header.Flags)
+
+	fmtPercentiles := func(byName map[string]int64) string {
+		parts := make([]string, len(opts.percentiles))
+		for i, p := range opts.percentiles {
+			name := stats.PercentileName(p)
+			parts[i] = fmt.Sprintf("%v %-10v", name, stats.Truncate(time.Duration(byName[name])))
+		}
+		return strings.Join(parts, " ")
+	}
+	for _, p := range a.Probes {
+		fmt.Printf("%20s: %s samples %d\n", p.Name, fmtPercentiles(p.PercentilesNs), p.Samples)
+		for _, w := range p.Windows {
+			fmt.Printf("%20s  %s samples %d\n", "+"+time.Duration(w.StartNs).String(), fmtPercentiles(w.PercentilesNs), w.Samples)
+		}
+		if len(p.Worst) > 0 {
+			fmt.Printf("%20s  worst samples:\n", "")
+		}
+		for _, s := range p.Worst {
+			fmt.Printf("%20s  %-10v at +%v\n", "", stats.Truncate(time.Duration(s.DelayNs)), time.Duration(s.AtNs).Truncate(time.Millisecond))
+		}
+		if len(p.Histogram) > 0 {
+			fmt.Printf("%20s  histogram:\n", "")
+		}
+		for _, b := range p.Histogram {
+			fmt.Printf("%20s  <= %-10v %d\n", "", time.Duration(b.UpperNs), b.Count)
+		}
+	}
+}
+
+// writeAnalysisCSV writes a row of percentiles per probe for the whole
+// selection, with "all" as the window, followed by a row per window.
+func writeAnalysisCSV(w io.Writer, a analysis, opts analyzeOptions) {
+	cw := csv.NewWriter(w)
+	label := a.Header.Label
+	header := []string{"probe", "window_start_ns", "samples"}
+	if label != "" {
+		header = append([]string{"label"}, header...)
+	}
+	for _, p := range opts.percentiles {
+		header = append(header, stats.PercentileName(p)+"_ns")
+	}
+	cw.Write(header)
+
+	row := func(probe, window string, samples int, byName map[string]int64) {
+		r := []string{probe, window, strconv.Itoa(samples)}
+		if label != "" {
+			r = append([]string{label}, r...)
+		}
+		for _, p := range opts.percentiles {
+			r = append(r, strconv.FormatInt(byName[stats.PercentileName(p)], 10))
+		}
+		cw.Write(r)
+	}
+	for _, p := range a.Probes {
+		row(p.Name, "all", p.Samples, p.PercentilesNs)
+		for _, win := range p.Windows {
+			row(p.Name, strconv.FormatInt(win.StartNs, 10), win.Samples, win.PercentilesNs)
+		}
+	}
+	cw.Flush()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"sched-latency/report"
+	"sched-latency/stats"
+)
+
+// anomalies is set when -anomaly-k flags intervals that are unusual for
+// their probe.
+var anomalies *anomalyDetector
+
+// anomalyMinIntervals is the number of intervals a probe's baseline needs
+// before its intervals can be flagged.
+const anomalyMinIntervals = 10
+
+// anomalyDetector flags a probe's intervals whose percentile is more than k
+// standard deviations above the mean of the recent intervals. The mean and
+// variance are exponentially weighted, so the baseline follows the last
+// -anomaly-window or so of intervals.
+type anomalyDetector struct {
+	k     float64
+	alpha float64
+	index int
+
+	mu     sync.Mutex
+	probes map[string]*ewmStats
+	order  []string
+}
+
+// ewmStats are the exponentially weighted mean and variance of a probe's
+// interval percentiles, and the number of intervals flagged.
+type ewmStats struct {
+	n        int
+	mean     float64
+	variance float64
+	flagged  int
+}
+
+func newAnomalyDetector(cfg Config) *anomalyDetector {
+	d := &anomalyDetector{
+		k:      cfg.AnomalyK,
+		alpha:  float64(cfg.ReportInterval) / float64(cfg.AnomalyWindow),
+		index:  cfg.anomalyIndex(),
+		probes: make(map[string]*ewmStats),
+	}
+	if d.alpha > 1 {
+		d.alpha = 1
+	}
+	return d
+}
+
+// anomalyIndex returns the index of the percentile anomalies are flagged
+// on: p99, or the top percentile if p99 isn't one of them.
+func (c Config) anomalyIndex() int {
+	if i := c.percentileIndex(0.99); i >= 0 {
+		return i
+	}
+	return len(c.Percentiles) - 1
+}
+
+// Check returns whether the interval is an anomaly for its probe, and adds
+// it to the probe's baseline.
+func (d *anomalyDetector) Check(r report.Result) bool {
+	if d.index >= len(r.Values) {
+		return false
+	}
+	x := float64(r.Values[d.index])
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.probes[r.Probe]
+	if !ok {
+		s = &ewmStats{}
+		d.probes[r.Probe] = s
+		d.order = append(d.order, r.Probe)
+	}
+	anomaly := s.n >= anomalyMinIntervals && x > s.mean+d.k*math.Sqrt(s.variance)
+	if anomaly {
+		s.flagged++
+	}
+
+	// Until there are enough intervals for the window, weight them equally.
+	s.n++
+	alpha := d.alpha
+	if early := 1 / float64(s.n); early > alpha {
+		alpha = early
+	}
+	diff := x - s.mean
+	s.mean += alpha * diff
+	s.variance = (1 - alpha) * (s.variance + alpha*diff*diff)
+	return anomaly
+}
+
+// String describes the intervals flagged for each probe.
+func (d *anomalyDetector) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var total int
+	var counts []string
+	for _, probe := range d.order {
+		s := d.probes[probe]
+		total += s.flagged
+		counts = append(counts, fmt.Sprintf("%v %d of %d", probe, s.flagged, s.n))
+	}
+	return fmt.Sprintf("%d intervals flagged (%v)", total, strings.Join(counts, ", "))
+}
+
+// describeAnomalies describes how intervals are flagged, for the banner.
+func describeAnomalies(cfg Config) string {
+	return fmt.Sprintf("intervals whose %v is over %v standard deviations above the mean of the last %v or so",
+		stats.PercentileName(cfg.Percentiles[cfg.anomalyIndex()]), cfg.AnomalyK, cfg.AnomalyWindow.Round(time.Second))
+}
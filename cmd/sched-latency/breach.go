@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"sched-latency/report"
+	"sched-latency/stats"
+)
+
+// breaches is set when -on-breach-cmd runs a command for intervals above
+// the -breach-threshold.
+var breaches *breachHook
+
+// breachHook runs a command when a probe's percentile for an interval is
+// above the threshold, at most once per cooldown and never more than one at
+// a time.
+type breachHook struct {
+	args       []string
+	threshold  time.Duration
+	percentile float64
+	index      int
+	cooldown   time.Duration
+
+	mu      sync.Mutex
+	next    time.Time
+	running bool
+	runs    int
+	skipped int
+}
+
+func newBreachHook(cfg Config) *breachHook {
+	h := &breachHook{
+		args:       strings.Fields(cfg.OnBreachCmd),
+		threshold:  cfg.BreachThreshold,
+		percentile: cfg.BreachPercentile,
+		cooldown:   cfg.BreachCooldown,
+	}
+	for i, p := range cfg.Percentiles {
+		if p == cfg.BreachPercentile {
+			h.index = i
+		}
+	}
+	return h
+}
+
+// Check runs the command in the background if the interval's percentile is
+// above the threshold.
+func (h *breachHook) Check(r report.Result) {
+	value := r.Values[h.index]
+	if value <= h.threshold {
+		return
+	}
+
+	now := time.Now()
+	h.mu.Lock()
+	if h.running || now.Before(h.next) {
+		h.skipped++
+		h.mu.Unlock()
+		return
+	}
+	h.running = true
+	h.next = now.Add(h.cooldown)
+	h.runs++
+	h.mu.Unlock()
+
+	result, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("failed to marshal report for -on-breach-cmd: %v", err)
+	}
+
+	cmd := exec.Command(h.args[0], h.args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"BREACH_LABEL="+r.Label,
+		"BREACH_PROBE="+r.Probe,
+		"BREACH_PERCENTILE="+stats.PercentileName(h.percentile),
+		fmt.Sprintf("BREACH_VALUE_NS=%d", value),
+		fmt.Sprintf("BREACH_THRESHOLD_NS=%d", h.threshold),
+		"BREACH_TIME="+now.Format(time.RFC3339Nano),
+	)
+	cmd.Stdin = bytes.NewReader(append(result, '\n'))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	go h.run(cmd, r.Probe, value)
+}
+
+func (h *breachHook) run(cmd *exec.Cmd, probe string, value time.Duration) {
+	defer func() {
+		h.mu.Lock()
+		h.running = false
+		h.mu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		log.Printf("-on-breach-cmd for %v %v %v failed after %v: %v",
+			probe, stats.PercentileName(h.percentile), stats.Truncate(value), time.Since(start).Truncate(time.Millisecond), err)
+		return
+	}
+	log.Printf("-on-breach-cmd for %v %v %v exited with status 0 after %v",
+		probe, stats.PercentileName(h.percentile), stats.Truncate(value), time.Since(start).Truncate(time.Millisecond))
+}
+
+func (h *breachHook) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return fmt.Sprintf("%d runs, %d breaches skipped by the cooldown", h.runs, h.skipped)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// buildInfo identifies the build of the tool that produced a result, so
+// results from different builds can be told apart.
+type buildInfo struct {
+	// Version is the module version, "(devel)" for a build from a checkout.
+	Version string `json:"version"`
+
+	// Revision and Modified are the VCS revision built, and whether the
+	// checkout had uncommitted changes, if the build recorded them.
+	Revision string `json:"vcs_revision,omitempty"`
+	Modified bool   `json:"vcs_modified,omitempty"`
+
+	GoVersion string `json:"go_version"`
+}
+
+// build is this build's info, read once at startup.
+var build = readBuildInfo()
+
+func readBuildInfo() buildInfo {
+	b := buildInfo{Version: "unknown", GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.Version = info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+func (b buildInfo) String() string {
+	s := "sched-latency " + b.Version
+	if b.Revision != "" {
+		s += " " + b.Revision
+		if b.Modified {
+			s += " (modified)"
+		}
+	}
+	return fmt.Sprintf("%v, %v", s, b.GoVersion)
+}
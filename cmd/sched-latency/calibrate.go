@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/metrics"
+	"sort"
+	"time"
+
+	"sched-latency/config"
+	"sched-latency/report"
+	"sched-latency/stats"
+)
+
+// calibrateMain measures the noise floor of an idle process: the cost of
+// reading the clock, and the sleep, timer and scheduling delays without any
+// load. Its summary JSON works as a -baseline for loaded runs.
+func calibrateMain(args []string) {
+	fs := newSubcommandFlags("calibrate", "[flags]")
+	intervalsFlag := fs.String("intervals", "10us,100us,1ms,15ms", "Comma-separated sleep intervals to measure the sleep and timer delay at")
+	sleepInterval := fs.Duration("sleep-interval", 15*time.Millisecond, "Interval whose delays are named like a run's probes, to match a -baseline")
+	samples := fs.Int("samples", 100, "Number of samples to take at each interval")
+	summaryPath := fs.String("summary-json", "", "File to write the calibration to as summary JSON, for use as a -baseline")
+	fs.Parse(args)
+
+	intervals, err := config.ParseDurationList(*intervalsFlag)
+	if err != nil {
+		fatalf("invalid -intervals: %v", err)
+	}
+	if *samples < 1 {
+		fatalf("-samples must be positive, got %v", *samples)
+	}
+	cfg := Config{Config: config.Config{Percentiles: config.DefaultPercentiles}}
+
+	sched := []metrics.Sample{{Name: schedMetric}}
+	schedErr := stats.CheckMetric(schedMetric, metrics.KindFloat64Histogram)
+	var schedStart stats.HistSnapshot
+	if schedErr != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: skipping /sched/latencies: %v\n", schedErr)
+	} else {
+		metrics.Read(sched)
+		schedStart = stats.NewHistSnapshot(sched[0].Value.Float64Histogram())
+	}
+
+	fmt.Printf("Calibration: %v\n", environment())
+	fmt.Printf("  time.Now cost: %v\n", timeNowCost())
+	fmt.Printf("  clock granularity: %v\n", clockGranularity())
+	fmt.Printf("  time.Sleep(1ns) takes: %v (p50)\n", minSleep(*samples))
+
+	for _, d := range intervals {
+		sleepName, timerName := "time.Sleep delay", "timer delay"
+		if d != *sleepInterval {
+			sleepName += " " + d.String()
+			timerName += " " + d.String()
+		}
+		runSummary.AddSamples(sleepName, sleepDelays(d, *samples))
+		runSummary.AddSamples(timerName, timerDelays(d, *samples))
+	}
+
+	if schedErr == nil {
+		metrics.Read(sched)
+		schedDiff, err := stats.NewHistSnapshot(sched[0].Value.Float64Histogram()).Diff(schedStart)
+		if err != nil {
+			fatalf("failed to diff /sched/latencies: %v", err)
+		}
+		runSummary.AddHistogram(schedProbe, schedDiff)
+	}
+
+	phase := runSummary.phases[len(runSummary.phases)-1]
+	for _, probe := range phase.probes {
+		fmt.Printf("%20s: %s samples %d\n", probe, report.FormatPercentiles(phase.percentiles(cfg, probe)), phase.count(probe))
+	}
+
+	if *summaryPath != "" {
+		if err := writeSummaryJSON(*summaryPath, runSummary.JSON(cfg)); err != nil {
+			fatalf("failed to write -summary-json: %v", err)
+		}
+	}
+}
+
+// timeNowCost returns the average cost of a time.Now call.
+func timeNowCost() time.Duration {
+	const n = 1000000
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		time.Now()
+	}
+	return time.Since(start) / n
+}
+
+// clockGranularity returns the smallest non-zero difference between
+// consecutive time.Now calls.
+func clockGranularity() time.Duration {
+	min := time.Duration(-1)
+	last := time.Now()
+	for seen := 0; seen < 1000; {
+		now := time.Now()
+		if d := now.Sub(last); d > 0 {
+			if min < 0 || d < min {
+				min = d
+			}
+			seen++
+		}
+		last = now
+	}
+	return min
+}
+
+// minSleep returns the median time taken by the shortest possible sleep.
+func minSleep(samples int) time.Duration {
+	taken := make([]time.Duration, samples)
+	for i := range taken {
+		start := time.Now()
+		time.Sleep(time.Nanosecond)
+		taken[i] = time.Since(start)
+	}
+	sort.Slice(taken, func(i, j int) bool { return taken[i] < taken[j] })
+	return taken[len(taken)/2]
+}
+
+func sleepDelays(d time.Duration, samples int) []time.Duration {
+	delays := make([]time.Duration, samples)
+	for i := range delays {
+		start := time.Now()
+		time.Sleep(d)
+		delays[i] = time.Since(start) - d
+	}
+	return delays
+}
+
+func timerDelays(d time.Duration, samples int) []time.Duration {
+	t := time.NewTimer(time.Second)
+	if !t.Stop() {
+		<-t.C
+	}
+	delays := make([]time.Duration, samples)
+	for i := range delays {
+		start := time.Now()
+		t.Reset(d)
+		stop := <-t.C
+		delays[i] = stop.Sub(start) - d
+	}
+	return delays
+}
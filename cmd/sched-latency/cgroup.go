@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"sched-latency/stats"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupCPU describes the CPU controller of the cgroup this process runs in.
+type cgroupCPU struct {
+	version  int
+	quota    float64 // in CPUs, or 0 if there's no quota
+	statPath string
+}
+
+// detectCgroupCPU finds the CPU quota applied to this process by cgroup v2 or
+// v1. It returns nil if there's no CPU controller, e.g., when not on Linux.
+func detectCgroupCPU() *cgroupCPU {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	// Each line is hierarchy-ID:controller-list:cgroup-path.
+	var v1Path, v2Path string
+	var hasV2 bool
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			hasV2, v2Path = true, parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller == "cpu" {
+				v1Path = parts[2]
+			}
+		}
+	}
+
+	if v1Path != "" {
+		for _, mount := range []string{"cpu", "cpu,cpuacct", "cpuacct,cpu"} {
+			if c := readCgroupV1(cgroupDirs(filepath.Join(cgroupRoot, mount), v1Path)); c != nil {
+				return c
+			}
+		}
+	}
+	if hasV2 {
+		return readCgroupV2(cgroupDirs(cgroupRoot, v2Path))
+	}
+	return nil
+}
+
+// cgroupDirs returns the directories that may hold the cgroup's files. Inside a
+// container, the cgroup is usually mounted at the root rather than at its path.
+func cgroupDirs(mount, path string) []string {
+	return []string{filepath.Join(mount, path), mount}
+}
+
+func readCgroupV2(dirs []string) *cgroupCPU {
+	for _, dir := range dirs {
+		// cpu.max contains "$MAX $PERIOD", where $MAX may be "max".
+		contents, err := os.ReadFile(filepath.Join(dir, "cpu.max"))
+		if err != nil {
+			continue
+		}
+
+		c := &cgroupCPU{version: 2, statPath: filepath.Join(dir, "cpu.stat")}
+		fields := strings.Fields(string(contents))
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, err1 := strconv.ParseFloat(fields[0], 64)
+			period, err2 := strconv.ParseFloat(fields[1], 64)
+			if err1 == nil && err2 == nil && period > 0 {
+				c.quota = quota / period
+			}
+		}
+		return c
+	}
+	return nil
+}
+
+func readCgroupV1(dirs []string) *cgroupCPU {
+	for _, dir := range dirs {
+		quota, err1 := readCgroupInt(filepath.Join(dir, "cpu.cfs_quota_us"))
+		period, err2 := readCgroupInt(filepath.Join(dir, "cpu.cfs_period_us"))
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		c := &cgroupCPU{version: 1, statPath: filepath.Join(dir, "cpu.stat")}
+		if quota > 0 && period > 0 {
+			c.quota = float64(quota) / float64(period)
+		}
+		return c
+	}
+	return nil
+}
+
+func readCgroupInt(path string) (int64, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(contents)), 10, 64)
+}
+
+// cpuThrottling is the cumulative throttling reported in cpu.stat.
+type cpuThrottling struct {
+	periods   uint64
+	throttled uint64
+	time      time.Duration
+}
+
+// throttling reads the cumulative throttling stats for the cgroup.
+func (c *cgroupCPU) throttling() (cpuThrottling, error) {
+	contents, err := os.ReadFile(c.statPath)
+	if err != nil {
+		return cpuThrottling{}, err
+	}
+
+	var t cpuThrottling
+	for _, line := range strings.Split(string(contents), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch key {
+		case "nr_periods":
+			t.periods = v
+		case "nr_throttled":
+			t.throttled = v
+		case "throttled_time": // v1, in nanoseconds
+			t.time = time.Duration(v)
+		case "throttled_usec": // v2
+			t.time = time.Duration(v) * time.Microsecond
+		}
+	}
+	return t, nil
+}
+
+// maxProcs returns the GOMAXPROCS that fits within the quota, which is the
+// quota rounded down, but at least 1. It returns 0 if there's no quota.
+func (c *cgroupCPU) maxProcs() int {
+	if c == nil || c.quota <= 0 {
+		return 0
+	}
+	return int(math.Max(1, math.Floor(c.quota)))
+}
+
+// printCPUs prints the host CPU count and the cgroup quota, and warns if
+// GOMAXPROCS exceeds the quota.
+func printCPUs(c *cgroupCPU) {
+	quota := "none"
+	if c != nil && c.quota > 0 {
+		quota = fmt.Sprintf("%.2f (cgroup v%d)", c.quota, c.version)
+	}
+	fmt.Printf("CPUs: host %d, quota %s, GOMAXPROCS %d\n", runtime.NumCPU(), quota, runtime.GOMAXPROCS(0))
+
+	if c != nil && c.quota > 0 && float64(runtime.GOMAXPROCS(0)) > c.quota {
+		fmt.Fprintf(os.Stderr, "WARNING: GOMAXPROCS %d exceeds the CPU quota of %.2f, expect throttling to dominate latencies (use -auto-maxprocs to cap GOMAXPROCS)\n",
+			runtime.GOMAXPROCS(0), c.quota)
+	}
+}
+
+// measureThrottling reports how often the cgroup was throttled in each interval.
+func measureThrottling(ctx context.Context, cfg Config, c *cgroupCPU) {
+	last, err := c.throttling()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read cgroup CPU stats, not reporting throttling: %v\n", err)
+		return
+	}
+
+	t := time.NewTicker(cfg.ReportInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+
+		cur, err := c.throttling()
+		if err != nil {
+			continue
+		}
+
+		out.Printf("%20s: %d of %d periods, %v\n", "cpu throttled",
+			cur.throttled-last.throttled, cur.periods-last.periods, stats.Truncate(cur.time-last.time))
+		last = cur
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// subcommands are run by name as the first argument. Without a subcommand,
+// the arguments are flags for run.
+var subcommands = []struct {
+	name    string
+	summary string
+	main    func(args []string)
+}{
+	{"run", "measure scheduling latencies, the default without a subcommand", runMain},
+	{"analyze", "recompute percentiles from a recorded sample file", analyzeMain},
+	{"compare", "compare or merge recordings and summary JSON files", compareMain},
+	{"calibrate", "measure the noise floor of an idle process", calibrateMain},
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if args[0] == "help" {
+			printSubcommands()
+			return
+		}
+		for _, sub := range subcommands {
+			if args[0] == sub.name {
+				sub.main(args[1:])
+				return
+			}
+		}
+	}
+	runMain(args)
+}
+
+func printSubcommands() {
+	fmt.Printf("Usage: %v [subcommand] [flags]\n\nSubcommands:\n", os.Args[0])
+	for _, sub := range subcommands {
+		fmt.Printf("  %-9s  %v\n", sub.name, sub.summary)
+	}
+	fmt.Printf("\nRun %v <subcommand> -h for the subcommand's flags.\n", os.Args[0])
+}
+
+// newSubcommandFlags returns a flag set for a subcommand, whose usage
+// describes its arguments.
+func newSubcommandFlags(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v %v %v\n\n", os.Args[0], name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
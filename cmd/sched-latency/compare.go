@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"sched-latency/config"
+	"sched-latency/stats"
+)
+
+// comparedRun is a run being compared, read from either a recording or a
+// summary JSON. Only recordings have samples, which are needed for merging
+// runs and for per-window significance hints.
+type comparedRun struct {
+	path    string
+	summary summaryJSON
+
+	// samples and windows are keyed by probe, with windows holding each
+	// window's percentiles.
+	samples map[string][]time.Duration
+	windows map[string][]map[string]int64
+}
+
+// configIgnoredFlags are flags that don't affect the measurements, so they
+// may differ between runs that are compared.
+var configIgnoredFlags = map[string]bool{
+	"record":             true,
+	"summary-json":       true,
+	"baseline":           true,
+	"fail-if":            true,
+	"fail-on-regression": true,
+	"duration":           true,
+	"samples":            true,
+	"pprof":              true,
+	"trace":              true,
+	"cpuprofile":         true,
+	"blockprofile":       true,
+	"mutexprofile":       true,
+	"label":              true,
+	"instance":           true,
+}
+
+// loadComparedRun reads a recording or summary JSON, computing a recording's
+// percentiles overall and per window.
+func loadComparedRun(path string, percentiles []float64, window time.Duration) (*comparedRun, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(b, []byte(recordMagic)) {
+		summary, err := readSummaryJSON(path)
+		if err != nil {
+			return nil, err
+		}
+		return &comparedRun{path: path, summary: summary}, nil
+	}
+
+	header, probes, err := readRecording(path)
+	if err != nil {
+		if header.Version == 0 {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: %v, comparing the samples before that\n", err)
+	}
+	a := analyzeRecording(header, probes, analyzeOptions{percentiles: percentiles, window: window})
+
+	run := &comparedRun{
+		path: path,
+		summary: summaryJSON{
+			Version:     summaryVersion,
+			Label:       header.Label,
+			Tags:        header.Tags,
+			Start:       header.Start,
+			GoVersion:   header.GoVersion,
+			Build:       header.Build,
+			GOOS:        header.GOOS,
+			GOARCH:      header.GOARCH,
+			NumCPU:      header.NumCPU,
+			GOMAXPROCS:  header.GOMAXPROCS,
+			Flags:       header.Flags,
+			Percentiles: percentiles,
+		},
+		samples: make(map[string][]time.Duration),
+		windows: make(map[string][]map[string]int64),
+	}
+	for _, p := range probes {
+		for _, s := range p.samples {
+			run.samples[p.name] = append(run.samples[p.name], s.delay)
+		}
+	}
+	for _, p := range a.Probes {
+		run.summary.Probes = append(run.summary.Probes, probeSummaryJSON{
+			Name:          p.Name,
+			Samples:       uint64(p.Samples),
+			PercentilesNs: p.PercentilesNs,
+		})
+		for _, w := range p.Windows {
+			run.windows[p.Name] = append(run.windows[p.Name], w.PercentilesNs)
+		}
+	}
+	return run, nil
+}
+
+// configDiffs describes how the runs' configs and environments differ.
+func configDiffs(a, b summaryJSON) []string {
+	var diffs []string
+	env := func(s summaryJSON) string {
+		return fmt.Sprintf("%v %v/%v, %d CPUs, GOMAXPROCS %d", s.GoVersion, s.GOOS, s.GOARCH, s.NumCPU, s.GOMAXPROCS)
+	}
+	if env(a) != env(b) {
+		diffs = append(diffs, fmt.Sprintf("environment: %v vs %v", env(a), env(b)))
+	}
+	// Files from before builds were recorded have no build to compare.
+	if a.Build.Version != "" && b.Build.Version != "" && a.Build != b.Build {
+		diffs = append(diffs, fmt.Sprintf("build: %v vs %v", a.Build, b.Build))
+	}
+
+	names := make(map[string]bool)
+	for name := range a.Flags {
+		names[name] = true
+	}
+	for name := range b.Flags {
+		names[name] = true
+	}
+	var sorted []string
+	for name := range names {
+		if !configIgnoredFlags[name] {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		av, aok := a.Flags[name]
+		bv, bok := b.Flags[name]
+		if !aok {
+			av = "(default)"
+		}
+		if !bok {
+			bv = "(default)"
+		}
+		if av != bv {
+			diffs = append(diffs, fmt.Sprintf("-%v: %v vs %v", name, av, bv))
+		}
+	}
+	return diffs
+}
+
+// windowRange returns the smallest and largest value of the percentile
+// across the run's windows.
+func (r *comparedRun) windowRange(probe, percentile string) (lo, hi int64, ok bool) {
+	for _, w := range r.windows[probe] {
+		v := w[percentile]
+		if !ok || v < lo {
+			lo = v
+		}
+		if !ok || v > hi {
+			hi = v
+		}
+		ok = true
+	}
+	return lo, hi, ok
+}
+
+// significance hints whether a percentile changed by more than the noise
+// between windows: "distinct" if the ranges of the percentile across each
+// run's windows don't overlap.
+func significance(a, b *comparedRun) func(probe, percentile string) string {
+	return func(probe, percentile string) string {
+		alo, ahi, aok := a.windowRange(probe, percentile)
+		blo, bhi, bok := b.windowRange(probe, percentile)
+		switch {
+		case !aok || !bok:
+			return "-"
+		case ahi < blo || bhi < alo:
+			return "distinct"
+		default:
+			return "overlaps"
+		}
+	}
+}
+
+// mergeRuns pools the runs' samples, printing each probe's percentiles over
+// all of them.
+func mergeRuns(runs []*comparedRun, percentiles []float64) error {
+	var probes []string
+	pooled := make(map[string][]time.Duration)
+	for _, r := range runs {
+		if r.samples == nil {
+			return fmt.Errorf("%v is a summary, but merging needs recordings from -record", r.path)
+		}
+		for _, p := range r.summary.Probes {
+			if _, ok := pooled[p.Name]; !ok {
+				probes = append(probes, p.Name)
+			}
+			pooled[p.Name] = append(pooled[p.Name], r.samples[p.Name]...)
+		}
+	}
+
+	cfg := Config{Config: config.Config{Percentiles: percentiles}}
+	fmt.Printf("Merged %d runs:\n", len(runs))
+	for _, probe := range probes {
+		samples := pooled[probe]
+		ps := cfg.SamplePercentiles(samples)
+		parts := make([]string, len(ps))
+		for i, p := range percentiles {
+			parts[i] = fmt.Sprintf("%v %-10v", stats.PercentileName(p), stats.Truncate(ps[i]))
+		}
+		fmt.Printf("%20s: %s samples %d\n", probe, strings.Join(parts, " "), len(samples))
+	}
+	return nil
+}
+
+// compareMain compares two runs, or merges several.
+func compareMain(args []string) {
+	fs := newSubcommandFlags("compare", "[flags] a.bin|a.json b.bin|b.json, or -merge run.bin...")
+	percentilesFlag := fs.String("percentiles", "0,0.5,0.99,1", "Comma-separated percentiles to compute for recordings, between 0 and 1")
+	window := fs.Duration("window", time.Second, "Window to compare recordings' percentiles over, for the significance hint")
+	merge := fs.Bool("merge", false, "Pool the samples of every recording, rather than comparing two runs")
+	var failOnRegress config.Percent
+	fs.Var(&failOnRegress, "fail-on-regression", "Exit with code 1 if any percentile in the second run is worse than in the first by more than this percentage, e.g. 20%")
+	fs.Parse(args)
+	if (*merge && fs.NArg() < 2) || (!*merge && fs.NArg() != 2) {
+		fs.Usage()
+		os.Exit(exitSetupError)
+	}
+	percentiles, err := parsePercentiles(*percentilesFlag)
+	if err != nil {
+		fatalf("invalid -percentiles: %v", err)
+	}
+	if *window <= 0 {
+		fatalf("-window must be positive, got %v", *window)
+	}
+
+	var runs []*comparedRun
+	for _, path := range fs.Args() {
+		r, err := loadComparedRun(path, percentiles, *window)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		runs = append(runs, r)
+	}
+
+	for _, r := range runs {
+		fmt.Printf("Run %v: label %v, tags %v\n", r.path, labelOrNone(r.summary.Label), labelOrNone(r.summary.Tags.String()))
+	}
+	for _, r := range runs[1:] {
+		diffs := configDiffs(runs[0].summary, r.summary)
+		if len(diffs) == 0 {
+			continue
+		}
+		fmt.Printf("WARNING: %v and %v have different configs:\n", runs[0].path, r.path)
+		for _, d := range diffs {
+			fmt.Printf("  %v\n", d)
+		}
+	}
+
+	if *merge {
+		if err := mergeRuns(runs, percentiles); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	a, b := runs[0], runs[1]
+	cfg := Config{Config: config.Config{Percentiles: b.summary.Percentiles}}
+	var hint func(probe, percentile string) string
+	if a.windows != nil && b.windows != nil {
+		hint = significance(a, b)
+	}
+	if compareBaseline(cfg, a.summary, b.summary, float64(failOnRegress), hint) {
+		os.Exit(exitFailed)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "time"
+
+// bucketedSamples is the number of samples per interval above which
+// "-accumulate auto" counts samples in buckets, rather than keeping and
+// sorting them all.
+const bucketedSamples = 100000
+
+// expectedSamples returns the most samples a sleep and timer style probe
+// can take in an interval, at the shortest of their intervals.
+func (c Config) expectedSamples() int64 {
+	shortest := c.SleepInterval
+	for _, opt := range c.ProbeOpts {
+		if d := c.ProbeInterval(opt.Probe); opt.Key == "interval" && d < shortest {
+			shortest = d
+		}
+	}
+	return int64(c.ReportInterval / shortest)
+}
+
+// intervalSamples returns the most samples a probe waiting for target can
+// take in an interval, for sizing its buffers up front. It's capped at
+// bucketedSamples, past which the buffers are better left to grow.
+func (c Config) intervalSamples(target time.Duration) int {
+	n := c.ReportInterval/target + 1
+	if n > bucketedSamples {
+		return bucketedSamples
+	}
+	return int(n)
+}
+
+// bucketed returns whether the sleep and timer style probes count their
+// samples in buckets.
+func (c Config) bucketed() bool {
+	switch c.Accumulate {
+	case "buckets":
+		return true
+	case "auto":
+		return c.expectedSamples() > bucketedSamples
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"sched-latency/config"
+)
+
+// envPrefix is the prefix of environment variables that set flags, e.g.
+// SCHED_LATENCY_SLEEP_INTERVAL for -sleep-interval.
+const envPrefix = "SCHED_LATENCY_"
+
+// hiddenFlags are internal flags that aren't part of a run's config.
+var hiddenFlags = map[string]bool{
+	config.ConfigFileFlag: true,
+	config.WorkerOnlyFlag: true,
+	config.SweepChildFlag: true,
+}
+
+// envName returns the environment variable that sets the flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadEnv sets flags in fs that weren't set on the command line from
+// environment variables, returning a description of each one that was set.
+func loadEnv(fs *flag.FlagSet) ([]string, error) {
+	var fromEnv []string
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || isFlagSet(fs, f.Name) || (hiddenFlags[f.Name] && f.Name != config.ConfigFileFlag) {
+			return
+		}
+		name := envName(f.Name)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, v); setErr != nil {
+			err = fmt.Errorf("invalid value for %v: %v", name, setErr)
+			return
+		}
+		fromEnv = append(fromEnv, fmt.Sprintf("-%v=%v from %v", f.Name, v, name))
+	})
+	return fromEnv, err
+}
+
+// loadConfigFile sets flags in fs from a JSON object whose keys are flag
+// names, e.g. {"sleep-interval": "1ms", "workers": 4}. Flags set on the
+// command line or from the environment take precedence over the file.
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var values map[string]interface{}
+	if err := d.Decode(&values); err != nil {
+		return fmt.Errorf("failed to parse %v: %v", path, err)
+	}
+
+	for name, v := range values {
+		if fs.Lookup(name) == nil || hiddenFlags[name] {
+			return fmt.Errorf("unknown key %q in %v, keys must be flag names such as sleep-interval", name, path)
+		}
+		if isFlagSet(fs, name) {
+			continue
+		}
+
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case json.Number:
+			s = v.String()
+		case bool:
+			s = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("key %q in %v must be a string, number or bool, got %v", name, path, v)
+		}
+		if err := fs.Set(name, s); err != nil {
+			return fmt.Errorf("invalid value for %q in %v: %v", name, path, err)
+		}
+	}
+	return nil
+}
+
+// effectiveFlags returns the flags set in fs on the command line or in a
+// config file, in the same format a config file uses.
+func effectiveFlags(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			values[f.Name] = f.Value.String()
+		}
+	})
+	return values
+}
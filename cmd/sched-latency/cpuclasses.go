@@ -0,0 +1,120 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"sched-latency/report"
+	"sched-latency/stats"
+)
+
+// cpuBreakdown is set when -cpu-breakdown reports how the process's CPU
+// time is spent each interval.
+var cpuBreakdown *cpuClasses
+
+// cpuClassMetrics are the /cpu/classes metrics the breakdown is from, with
+// the total first.
+var cpuClassMetrics = []string{
+	"/cpu/classes/total:cpu-seconds",
+	"/cpu/classes/user:cpu-seconds",
+	"/cpu/classes/gc/total:cpu-seconds",
+	"/cpu/classes/scavenge/total:cpu-seconds",
+	"/cpu/classes/idle:cpu-seconds",
+}
+
+// cpuClasses breaks down the process's CPU time each report interval into
+// its user code, the GC, the scavenger and idle time, so latency spikes can
+// be told apart as the GC taking CPU from the probes or the CPUs being
+// oversubscribed.
+type cpuClasses struct {
+	counters *stats.Counters
+	interval time.Duration
+	start    time.Time
+
+	mu   sync.Mutex
+	last *report.CPUClasses
+
+	// total is the CPU time of each class after the warmup.
+	total []float64
+}
+
+// newCPUClasses returns the breakdown, or an error if the runtime doesn't
+// have the /cpu/classes metrics, which Go 1.20 added.
+func newCPUClasses(cfg Config) (*cpuClasses, error) {
+	counters, err := stats.NewCounters(cpuClassMetrics...)
+	if err != nil {
+		return nil, err
+	}
+	return &cpuClasses{
+		counters: counters,
+		interval: cfg.ReportInterval,
+		start:    time.Now(),
+		total:    make([]float64, len(cpuClassMetrics)),
+	}, nil
+}
+
+// Next ends the interval at now, printing its breakdown. It's called on the
+// report tick before the probes report, so their reports for the interval
+// include it.
+func (c *cpuClasses) Next(now time.Time) {
+	diff := c.counters.Diff()
+	classes, ok := cpuFractions(diff)
+
+	c.mu.Lock()
+	c.last = nil
+	if ok {
+		c.last = &classes
+	}
+	if !c.start.Before(warmupEnd) {
+		for i, d := range diff {
+			c.total[i] += d
+		}
+	}
+	c.start = now
+	c.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if classes.Span-c.interval > time.Duration(overrunTolerance*float64(c.interval)) {
+		out.Printf("%20s: %v (over the last %v, since the runtime updates it at each GC)\n", "cpu", classes, classes.Span.Round(100*time.Millisecond))
+	} else {
+		out.Printf("%20s: %v\n", "cpu", classes)
+	}
+}
+
+// Last returns the breakdown of the last interval, or nil if there's none.
+func (c *cpuClasses) Last() *report.CPUClasses {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// Summary returns the breakdown of the whole run after the warmup, or
+// false if no CPU time was measured.
+func (c *cpuClasses) Summary() (report.CPUClasses, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cpuFractions(c.total)
+}
+
+// cpuFractions returns the CPU time of each class, in cpuClassMetrics
+// order, as fractions of the total, or false if the total is 0. The
+// runtime only updates the metrics at each GC, so an interval without one
+// has no CPU time, and the next one with a GC has the CPU time since the
+// last.
+func cpuFractions(d []float64) (report.CPUClasses, bool) {
+	total := d[0]
+	if total <= 0 {
+		return report.CPUClasses{}, false
+	}
+	return report.CPUClasses{
+		User:     d[1] / total,
+		GC:       d[2] / total,
+		Scavenge: d[3] / total,
+		Idle:     d[4] / total,
+		// The total is GOMAXPROCS integrated over the wall time.
+		Span: time.Duration(total / float64(runtime.GOMAXPROCS(0)) * float64(time.Second)),
+	}, true
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+
+	"sched-latency/config"
+)
+
+// validateCPUSet checks that affinity is supported and every CPU is online.
+func validateCPUSet(name string, s config.CPUSet) error {
+	if len(s) == 0 {
+		return nil
+	}
+	if err := errAffinityUnsupported; err != nil {
+		return fmt.Errorf("-%v: %v", name, err)
+	}
+
+	online := onlineCPUs()
+	for _, cpu := range s {
+		if !online[cpu] {
+			return fmt.Errorf("-%v: CPU %d is not online", name, cpu)
+		}
+	}
+	return nil
+}
+
+// pinThread locks the calling goroutine to its OS thread and restricts that
+// thread to the CPUs in s. It does nothing if s is empty.
+func pinThread(s config.CPUSet) {
+	if len(s) == 0 {
+		return
+	}
+
+	runtime.LockOSThread()
+	if err := setThreadAffinity(s); err != nil {
+		log.Printf("failed to set CPU affinity to %v: %v", s, err)
+	}
+}
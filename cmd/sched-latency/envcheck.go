@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"sched-latency/stats"
+)
+
+// envWarnings are the findings of the environment check at startup, for
+// the summary.
+var envWarnings []string
+
+// hypervisorVendors are DMI vendor and product names of virtual machines.
+var hypervisorVendors = []string{"QEMU", "KVM", "VMware", "Xen", "Amazon EC2", "Google Compute Engine", "innotek", "VirtualBox", "Virtual Machine", "Parallels", "BHYVE", "OpenStack"}
+
+// checkEnvironment looks for environments whose results are often
+// misread: a virtual machine, a powersave cpufreq governor, a laptop on
+// battery, or a CPU whose short spins and sleeps are noisy. It's best
+// effort, returning a warning for each finding, and takes well under a
+// second.
+func checkEnvironment() []string {
+	var warnings []string
+	if runtime.GOOS == "linux" {
+		if w := checkVirtualized(); w != "" {
+			warnings = append(warnings, w)
+		}
+		if w := checkGovernor(); w != "" {
+			warnings = append(warnings, w)
+		}
+		if w := checkBattery(); w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	return append(warnings, checkNoise()...)
+}
+
+// checkVirtualized returns a warning if the CPU has the hypervisor flag,
+// or the DMI vendor is a known hypervisor.
+func checkVirtualized() string {
+	var evidence []string
+	if f, err := os.Open("/proc/cpuinfo"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(scanner.Text(), ":")
+			if !ok || strings.TrimSpace(key) != "flags" {
+				continue
+			}
+			for _, flag := range strings.Fields(value) {
+				if flag == "hypervisor" {
+					evidence = append(evidence, "the CPU has the hypervisor flag")
+				}
+			}
+			break
+		}
+		f.Close()
+	}
+	for _, name := range []string{"sys_vendor", "product_name"} {
+		b, err := os.ReadFile(filepath.Join("/sys/class/dmi/id", name))
+		if err != nil {
+			continue
+		}
+		value := strings.TrimSpace(string(b))
+		for _, vendor := range hypervisorVendors {
+			if strings.Contains(value, vendor) {
+				evidence = append(evidence, fmt.Sprintf("the DMI %v is %q", strings.ReplaceAll(name, "_", " "), value))
+				break
+			}
+		}
+	}
+	if len(evidence) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("running in a virtual machine (%v), so the host can deschedule its vCPUs and steal time shows up as latency",
+		strings.Join(evidence, ", "))
+}
+
+// checkGovernor returns a warning if any CPU uses the powersave cpufreq
+// governor.
+func checkGovernor() string {
+	paths, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_governor")
+	var powersave int
+	for _, path := range paths {
+		if b, err := os.ReadFile(path); err == nil && strings.TrimSpace(string(b)) == "powersave" {
+			powersave++
+		}
+	}
+	if powersave == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d of %d CPUs use the powersave cpufreq governor, so clock speeds and wakeup latencies vary with load", powersave, len(paths))
+}
+
+// checkBattery returns a warning if a battery is discharging.
+func checkBattery() string {
+	dirs, _ := filepath.Glob("/sys/class/power_supply/*")
+	for _, dir := range dirs {
+		kind, err := os.ReadFile(filepath.Join(dir, "type"))
+		if err != nil || strings.TrimSpace(string(kind)) != "Battery" {
+			continue
+		}
+		if status, err := os.ReadFile(filepath.Join(dir, "status")); err == nil && strings.TrimSpace(string(status)) == "Discharging" {
+			return fmt.Sprintf("running on battery (%v is discharging), so the CPU may be throttled to save power", filepath.Base(dir))
+		}
+	}
+	return ""
+}
+
+const (
+	// noiseSamples is the number of spins and sleeps the noise check times.
+	noiseSamples = 200
+
+	// noiseSleep is how long each of the noise check's sleeps is.
+	noiseSleep = 100 * time.Microsecond
+)
+
+// checkNoise times repeated short spins and sleeps, returning warnings if
+// the same spin takes much longer at times, e.g., from frequency scaling or
+// other load, or if the sleeps overshoot by a lot.
+func checkNoise() []string {
+	spin := spinWork()
+	spins := make([]time.Duration, noiseSamples)
+	for i := range spins {
+		start := time.Now()
+		spin()
+		spins[i] = time.Since(start)
+	}
+	sleeps := make([]time.Duration, noiseSamples/2)
+	for i := range sleeps {
+		start := time.Now()
+		time.Sleep(noiseSleep)
+		sleeps[i] = time.Since(start) - noiseSleep
+	}
+
+	var warnings []string
+	ps := []float64{0.5, 0.99}
+	if taken := stats.SamplePercentiles(spins, ps); taken[1] > 2*taken[0] {
+		warnings = append(warnings, fmt.Sprintf("the same short spin took %v at p50 but %v at p99, so the CPU is noisy, e.g., from frequency scaling or other load",
+			taken[0].Round(time.Microsecond), taken[1].Round(time.Microsecond)))
+	}
+	if sleep := stats.SamplePercentiles(sleeps, ps); sleep[1] > time.Millisecond {
+		warnings = append(warnings, fmt.Sprintf("%v sleeps overshot by %v at p50 and %v at p99 before any load started",
+			noiseSleep, sleep[0].Round(time.Microsecond), sleep[1].Round(time.Microsecond)))
+	}
+	return warnings
+}
@@ -0,0 +1,12 @@
+//go:build linux
+
+package main
+
+import "sched-latency/probe"
+
+// registerEpollProbe registers the epoll_wait probe, as a reference for how
+// much of the timer probes' delay is from the kernel's epoll timeouts
+// rather than Go.
+func registerEpollProbe(cfg Config) {
+	probe.Register(threadProbe{&probe.EpollWait{Interval: cfg.ProbeInterval("epoll")}, cfg, false})
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+// registerEpollProbe registers nothing, as there's no epoll on this
+// platform.
+func registerEpollProbe(Config) {}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"sched-latency/stats"
+)
+
+// checkFailIf evaluates the -fail-if assertions against the whole-run
+// summary, printing each one's measured value, and returns whether any
+// failed.
+func checkFailIf(cfg Config, s *summary) (failed bool) {
+	fmt.Println("Fail-if:")
+	for _, a := range cfg.FailIf {
+		measured, ok := s.Percentile(cfg, a.Probe, a.Percentile)
+		switch {
+		case !ok:
+			failed = true
+			fmt.Printf("  FAIL %s: no samples recorded\n", a.Spec)
+		case measured > a.Limit:
+			failed = true
+			fmt.Printf("  FAIL %s: measured %v\n", a.Spec, stats.Truncate(measured))
+		default:
+			fmt.Printf("  ok   %s: measured %v\n", a.Spec, stats.Truncate(measured))
+		}
+	}
+	return failed
+}
@@ -0,0 +1,10 @@
+//go:build linux
+
+package main
+
+import "sched-latency/probe"
+
+// registerFutexProbe registers the futex wake probe.
+func registerFutexProbe(cfg Config) {
+	probe.Register(threadProbe{&probe.FutexWake{Interval: cfg.ProbeInterval("futex")}, cfg, false})
+}
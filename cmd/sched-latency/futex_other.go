@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+// registerFutexProbe registers nothing, as there are no futexes on this
+// platform.
+func registerFutexProbe(Config) {}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+)
+
+// heapProfiles is set when -heap-profile-every writes heap profiles.
+var heapProfiles *heapProfiler
+
+// heapProfiler writes a heap profile every period and once when stopped,
+// keeping only the most recent profiles.
+type heapProfiler struct {
+	dir    string
+	label  string
+	period time.Duration
+	keep   int
+	start  time.Time
+
+	written []string
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newHeapProfiler(cfg Config, start time.Time) (*heapProfiler, error) {
+	if err := os.MkdirAll(cfg.ProfileDir, 0o755); err != nil {
+		return nil, err
+	}
+	return &heapProfiler{
+		dir:    cfg.ProfileDir,
+		label:  fileLabel(cfg.Label),
+		period: cfg.HeapProfileEvery,
+		keep:   cfg.HeapProfileKeep,
+		start:  start,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Run writes a profile every period until stopped, then a final one.
+func (p *heapProfiler) Run() {
+	defer close(p.done)
+
+	t := time.NewTicker(p.period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.write("")
+		case <-p.stop:
+			p.write("-end")
+			return
+		}
+	}
+}
+
+// write writes a profile named by the time since the start, deleting the
+// oldest profiles beyond the ones to keep.
+func (p *heapProfiler) write(suffix string) {
+	elapsed := time.Since(p.start).Round(time.Second)
+	path := filepath.Join(p.dir, fmt.Sprintf("heap%v-%06ds%v.pb.gz", p.label, int64(elapsed/time.Second), suffix))
+	if err := writeHeapProfile(path); err != nil {
+		log.Printf("failed to write heap profile: %v", err)
+		return
+	}
+	p.written = append(p.written, path)
+
+	for p.keep > 0 && len(p.written) > p.keep {
+		if err := os.Remove(p.written[0]); err != nil {
+			log.Printf("failed to remove old heap profile: %v", err)
+		}
+		p.written = p.written[1:]
+	}
+}
+
+// Stop writes the final profile.
+func (p *heapProfiler) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+func (p *heapProfiler) String() string {
+	return fmt.Sprintf("%d heap profiles kept in %v", len(p.written), p.dir)
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.Lookup("heap").WriteTo(f, 0); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
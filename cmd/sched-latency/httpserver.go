@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"sync/atomic"
+)
+
+// httpServers are the HTTP servers started for endpoints, by address, so
+// endpoints configured with the same address share a server.
+var httpServers = struct {
+	sync.Mutex
+	muxes map[string]*http.ServeMux
+}{muxes: make(map[string]*http.ServeMux)}
+
+// serveMux returns the mux of the HTTP server on addr, starting the server
+// if it isn't running yet.
+func serveMux(addr string) (*http.ServeMux, error) {
+	httpServers.Lock()
+	defer httpServers.Unlock()
+
+	if mux, ok := httpServers.muxes[addr]; ok {
+		return mux, nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	httpServers.muxes[addr] = mux
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Printf("HTTP server on %v failed: %v", addr, err)
+		}
+	}()
+	return mux, nil
+}
+
+// cpuProfiled and traced are set once a CPU profile or execution trace is
+// requested from the pprof endpoint, since both perturb the measurements.
+var cpuProfiled, traced atomic.Bool
+
+// servePprof serves net/http/pprof on addr.
+func servePprof(addr string) error {
+	mux, err := serveMux(addr)
+	if err != nil {
+		return err
+	}
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/profile", func(w http.ResponseWriter, r *http.Request) {
+		cpuProfiled.Store(true)
+		pprof.Profile(w, r)
+	})
+	mux.HandleFunc("/debug/pprof/trace", func(w http.ResponseWriter, r *http.Request) {
+		traced.Store(true)
+		pprof.Trace(w, r)
+	})
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/trace"
+	"sync"
+	"time"
+
+	"sched-latency/probe"
+	"sched-latency/stats"
+)
+
+// warmupEnd is when the warmup ends. Samples taken before it are reported,
+// but aren't included in the run summary.
+var warmupEnd time.Time
+
+// reportNow is closed to ask every probe to report its current interval so
+// far, and then replaced for the next request.
+var reportNow = struct {
+	sync.Mutex
+	c chan struct{}
+}{c: make(chan struct{})}
+
+// reportNowC returns a channel that's closed on the next request for an
+// out-of-cycle report.
+func reportNowC() <-chan struct{} {
+	reportNow.Lock()
+	defer reportNow.Unlock()
+	return reportNow.c
+}
+
+// requestReport asks every probe for an out-of-cycle report.
+func requestReport() {
+	reportNow.Lock()
+	defer reportNow.Unlock()
+	close(reportNow.c)
+	reportNow.c = make(chan struct{})
+}
+
+// runSamples counts the samples each probe has taken after the warmup, for
+// stopping the run once they all reach -samples.
+var runSamples = &sampleCounts{
+	counts: make(map[string]int),
+	done:   make(chan struct{}),
+}
+
+type sampleCounts struct {
+	target int
+
+	mu     sync.Mutex
+	counts map[string]int
+	done   chan struct{}
+	closed bool
+}
+
+// Register adds a probe that must reach the target.
+func (c *sampleCounts) Register(probe string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[probe] = 0
+}
+
+// Add counts a probe's sample, and closes Done once every probe has
+// reached the target.
+func (c *sampleCounts) Add(probe string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts[probe]++
+	if c.closed {
+		return
+	}
+	for _, n := range c.counts {
+		if n < c.target {
+			return
+		}
+	}
+	c.closed = true
+	close(c.done)
+}
+
+// Progress returns the number of samples the probe has counted, or false if
+// it isn't counting samples.
+func (c *sampleCounts) Progress(probe string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.counts[probe]
+	return n, ok
+}
+
+// Done returns a channel that's closed once every probe has reached the
+// target, or nil if there's no target.
+func (c *sampleCounts) Done() <-chan struct{} {
+	if c.target == 0 {
+		return nil
+	}
+	return c.done
+}
+
+// sampleInterval accumulates a probe's samples over a report interval,
+// which the report tick hands over and reports.
+//
+// The probe runs as an execution trace task, with a region for each sample
+// from Start to Add, so samples can be found in traces.
+type sampleInterval struct {
+	cfg  Config
+	name string
+
+	ctx    context.Context
+	task   *trace.Task
+	region *trace.Region
+
+	// gcStart is the GC cycle count when the sample started, if outliers
+	// are logged.
+	gcStart uint64
+
+	samples  *probe.Interval
+	recordID uint64
+
+	// warm is the number of leading samples in the interval that were taken
+	// during the warmup. A bucketed interval also counts them in warmHist,
+	// to leave them out of the summary.
+	mu       sync.Mutex
+	warm     int
+	warmHist *stats.LogHist
+
+	// extras annotate every report, e.g., with how samples are accumulated.
+	extras []string
+
+	// spinner is set for a probe that spins for part of each wait, with
+	// its spinning and waiting totals at the start of the interval.
+	spinner      spinner
+	spun, waited time.Duration
+}
+
+// newSampleInterval returns the probe's interval, reported on reportTick
+// until Flush.
+func newSampleInterval(ctx context.Context, cfg Config, name string) *sampleInterval {
+	s := &sampleInterval{cfg: cfg, name: name}
+	s.ctx, s.task = trace.NewTask(ctx, name)
+	if cfg.bucketed() {
+		s.samples = probe.NewBucketedInterval(name, cfg.Percentiles, cfg.BucketResolution)
+		s.warmHist = stats.NewLogHist(cfg.BucketResolution)
+		s.extras = []string{"(bucketed)"}
+	} else {
+		s.samples = probe.NewInterval(name, cfg.Percentiles)
+	}
+	if cfg.TailThresholds != nil {
+		s.samples.SetThresholds(cfg.TailThresholds)
+	}
+	if runSamples.target > 0 {
+		runSamples.Register(name)
+	}
+	if recorder != nil {
+		s.recordID = recorder.Register(name)
+	}
+	reportTick.Add(s)
+	return s
+}
+
+// Start marks the start of taking a sample in execution traces.
+func (s *sampleInterval) Start() {
+	s.region = trace.StartRegion(s.ctx, s.name)
+	if outlierLog != nil {
+		s.gcStart = gcCycles()
+	}
+}
+
+// Add records a sample taken at the given time.
+func (s *sampleInterval) Add(sample time.Duration, at time.Time) {
+	logOutlier(s.ctx, s.name, sample, at, s.gcStart)
+	if s.region != nil {
+		s.region.End()
+		s.region = nil
+	}
+
+	s.mu.Lock()
+	s.samples.Add(sample, at)
+	if at.Before(warmupEnd) {
+		s.warm++
+		if s.warmHist != nil {
+			s.warmHist.Add(sample)
+		}
+	}
+	s.mu.Unlock()
+
+	observeSpike(s.name, sample, at)
+	if recorder != nil {
+		recorder.Record(s.recordID, at, sample)
+	}
+	if !at.Before(warmupEnd) && runSamples.target > 0 {
+		runSamples.Add(s.name)
+	}
+}
+
+// next ends the interval at now, recording its samples after the warmup in
+// the run summary.
+func (s *sampleInterval) next(now time.Time) probe.Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	warm := s.warm
+	s.warm = 0
+	if s.samples.Bucketed() {
+		snap, buckets := s.samples.NextBuckets(now)
+		if diff, err := buckets.Diff(s.warmHist.Snapshot()); err == nil {
+			runSummary.AddHistogram(s.name, diff)
+		}
+		s.warmHist.Reset()
+		return snap
+	}
+	snap, samples := s.samples.Next(now)
+	runSummary.AddSamples(s.name, samples[warm:])
+	return snap
+}
+
+// Next implements intervalSource. Intervals without samples, such as when
+// the sleep interval is longer than the report interval, aren't reported.
+func (s *sampleInterval) Next(now time.Time) {
+	if snap := s.next(now); snap.Interval.Count > 0 {
+		s.cfg.Report(s.name, snap.Interval.Start, now, snap.Interval.Values, snap.Interval.Count, snap.Interval.Negative, snap.Interval.Tail, s.extrasFor(true)...)
+	}
+}
+
+// Partial implements intervalSource.
+func (s *sampleInterval) Partial() {
+	snap := s.samples.Snapshot()
+	s.cfg.ReportPartial(s.name, snap.Interval.Start, snap.Interval.Values, snap.Interval.Count, snap.Interval.Negative, snap.Interval.Tail, s.extrasFor(false)...)
+}
+
+// Flush stops reporting the interval on reportTick, and reports the current
+// partial interval, for when the probe stops.
+func (s *sampleInterval) Flush() {
+	reportTick.Remove(s)
+	snap := s.next(time.Now())
+	if snap.Interval.Count > 0 {
+		s.cfg.ReportPartial(s.name, snap.Interval.Start, snap.Interval.Values, snap.Interval.Count, snap.Interval.Negative, snap.Interval.Tail, s.extrasFor(true)...)
+	}
+	if n := snap.Total.Negative; n > 0 {
+		runSummary.AddNote(fmt.Sprintf("%v: %d of %d samples had a negative delay, which suggests timer or clock problems rather than latency", s.name, n, snap.Total.Count))
+	}
+	if s.spinner != nil {
+		if spun, waited := s.spinner.Spinning(); waited > 0 {
+			runSummary.AddNote(fmt.Sprintf("%v: spun for %.1f%% of the time waiting (%v of %v)", s.name,
+				100*float64(spun)/float64(waited), spun.Truncate(time.Millisecond), waited.Truncate(time.Millisecond)))
+		}
+	}
+	s.task.End()
+}
+
+// extrasFor returns the extras for reporting the interval, which include the
+// fraction of the time spent spinning for a spinning probe. next is set when
+// the interval ends, to start counting the next one's spinning.
+func (s *sampleInterval) extrasFor(next bool) []string {
+	extras := s.extras
+	if spin := s.spinExtra(next); spin != "" {
+		extras = append(extras[:len(extras):len(extras)], spin)
+	}
+	return extras
+}
+
+// spinExtra returns the fraction of the interval's waiting spent spinning,
+// e.g., "spun 6.7%", or "" if the probe doesn't spin.
+func (s *sampleInterval) spinExtra(next bool) string {
+	if s.spinner == nil {
+		return ""
+	}
+	spun, waited := s.spinner.Spinning()
+	dSpun, dWaited := spun-s.spun, waited-s.waited
+	if next {
+		s.spun, s.waited = spun, waited
+	}
+	if dWaited <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("spun %.1f%%", 100*float64(dSpun)/float64(dWaited))
+}
@@ -0,0 +1,32 @@
+package main
+
+import "strings"
+
+// labelOrNone returns the label for display, or "(none)" for a run without
+// one.
+func labelOrNone(label string) string {
+	if label == "" {
+		return "(none)"
+	}
+	return label
+}
+
+// fileLabel returns the part of a captured file's name for the label, which
+// is "-" and the sanitized label, or "" without one.
+func fileLabel(label string) string {
+	if label == "" {
+		return ""
+	}
+	return "-" + sanitizeFileName(label)
+}
+
+// sanitizeFileName replaces the characters of s that aren't safe in a file
+// name on every OS with underscores.
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
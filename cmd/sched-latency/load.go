@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sched-latency/config"
+)
+
+// workerPool runs a variable number of CPU-bound workers. Each worker is
+// started with its own stop channel, and exits once it's closed.
+type workerPool struct {
+	start func(w *workload, stop <-chan struct{})
+	cpus  config.CPUSet
+
+	// reserved is the number of workers whose CPU is taken by probes that
+	// keep one busy, such as the spin wait probe, so the pool runs that
+	// many fewer to keep the total load as configured.
+	reserved int
+
+	// workloads is the workload run by each worker. If there are more
+	// workers than workloads, they're reused from the start.
+	workloads []*workload
+
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	active int
+	stops  []chan struct{}
+}
+
+func newWorkerPool(cfg Config, duty *dutyStats) *workerPool {
+	p := &workerPool{
+		start: cpuLoop,
+		cpus:  cfg.WorkerCPUs,
+	}
+	if cfg.ProbeEnabled("spin") {
+		p.reserved = 1
+	}
+	if cfg.WorkloadMix.Workers() > 0 {
+		p.workloads = mixWorkloads(cfg.WorkloadMix)
+	} else {
+		p.workloads = []*workload{{name: "json", newOp: jsonWork}}
+	}
+
+	if cfg.LoadProcess {
+		// Each child pins its own worker.
+		p.start = processWorker(cfg)
+		p.cpus = nil
+	} else if cfg.WorkerDuty < 1 {
+		p.start = func(w *workload, stop <-chan struct{}) {
+			dutyCycleLoop(cfg, w, duty, stop)
+		}
+	}
+	return p
+}
+
+// workloadTypes returns each distinct workload run by the pool.
+func (p *workerPool) workloadTypes() []*workload {
+	var types []*workload
+	seen := make(map[*workload]bool)
+	for _, w := range p.workloads {
+		if !seen[w] {
+			seen[w] = true
+			types = append(types, w)
+		}
+	}
+	return types
+}
+
+// SetActive starts or stops workers so that n are running, counting the
+// reserved ones.
+func (p *workerPool) SetActive(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.active = n
+	if n -= p.reserved; n < 0 {
+		n = 0
+	}
+	for len(p.stops) < n {
+		w := p.workloads[len(p.stops)%len(p.workloads)]
+		stop := make(chan struct{})
+		p.stops = append(p.stops, stop)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			pinThread(p.cpus)
+			p.start(w, stop)
+		}()
+	}
+	for len(p.stops) > n {
+		last := len(p.stops) - 1
+		close(p.stops[last])
+		p.stops = p.stops[:last]
+	}
+}
+
+// Stop stops all workers and waits for them to exit.
+func (p *workerPool) Stop() {
+	p.SetActive(0)
+	p.wg.Wait()
+}
+
+// Active returns the number of running workers, counting the reserved
+// ones.
+func (p *workerPool) Active() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active
+}
+
+func cpuLoop(w *workload, stop <-chan struct{}) {
+	op := w.newOp()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		op()
+		w.ops.Add(1)
+	}
+}
+
+// dutyStats tracks the time duty-cycle workers spent busy out of the total
+// time they were running, summed across workers.
+type dutyStats struct {
+	busy    atomic.Int64
+	elapsed atomic.Int64
+}
+
+// dutyCycleLoop burns CPU for cfg.WorkerDuty of every cfg.WorkerPeriod and
+// sleeps for the remainder, recording the time actually spent busy in stats.
+//
+// Periods are scheduled on a fixed grid from the start time, so lateness in
+// one period doesn't shift the periods after it.
+func dutyCycleLoop(cfg Config, w *workload, stats *dutyStats, stop <-chan struct{}) {
+	op := w.newOp()
+
+	t := time.NewTimer(time.Second)
+	if !t.Stop() {
+		<-t.C
+	}
+
+	busyFor := time.Duration(cfg.WorkerDuty * float64(cfg.WorkerPeriod))
+	periodStart := time.Now()
+	for {
+		start := time.Now()
+		if behind := start.Sub(periodStart); behind >= cfg.WorkerPeriod {
+			// We missed whole periods (e.g., we weren't scheduled), skip them
+			// rather than trying to catch up.
+			periodStart = periodStart.Add(behind.Truncate(cfg.WorkerPeriod))
+		}
+
+		busyUntil := periodStart.Add(busyFor)
+		for time.Now().Before(busyUntil) {
+			op()
+			w.ops.Add(1)
+		}
+		stats.busy.Add(int64(time.Since(start)))
+
+		periodStart = periodStart.Add(cfg.WorkerPeriod)
+		t.Reset(time.Until(periodStart))
+		select {
+		case <-t.C:
+		case <-stop:
+			stats.elapsed.Add(int64(time.Since(start)))
+			return
+		}
+		stats.elapsed.Add(int64(time.Since(start)))
+	}
+}
+
+// measureWorkerDuty reports the duty cycle achieved by the workers, based on
+// the busy time they measured rather than the configured target.
+func measureWorkerDuty(ctx context.Context, cfg Config, stats *dutyStats) {
+	t := time.NewTicker(cfg.ReportInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+
+		busy, elapsed := stats.busy.Swap(0), stats.elapsed.Swap(0)
+		if elapsed == 0 {
+			continue
+		}
+		achieved := float64(busy) / float64(elapsed)
+		out.Printf("%20s: %5.1f%% (target %.1f%%)\n", "worker duty", achieved*100, cfg.WorkerDuty*100)
+	}
+}
+
+// runRamp steps the pool through the ramp schedule, labelling each step as a
+// separate phase in report lines and the run summary. It returns once the
+// last step completes.
+func runRamp(steps []config.RampStep, pool *workerPool) {
+	for i, step := range steps {
+		setPhase(fmt.Sprintf("ramp step %d: %v", i+1, step))
+		pool.SetActive(step.Workers)
+		time.Sleep(step.Duration)
+	}
+}
+
+// burstLoad runs a set of extra workers for a burst at the start of every
+// period. Between bursts, the workers are parked on a channel so every
+// worker starts spinning as soon as the burst starts.
+type burstLoad struct {
+	cfg Config
+
+	mu       sync.Mutex
+	start    chan struct{} // closed to start the next burst
+	burstEnd time.Time
+
+	// lastEnd is the end of the most recent burst in Unix nanoseconds.
+	lastEnd atomic.Int64
+
+	// stop is closed by Stop, ending the current burst and every goroutine
+	// the load started, which wg waits for.
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// newBurstLoad returns the load with its workers parked until Start.
+func newBurstLoad(cfg Config) *burstLoad {
+	b := &burstLoad{
+		cfg:   cfg,
+		start: make(chan struct{}),
+		stop:  make(chan struct{}),
+	}
+	b.wg.Add(cfg.BurstWorkers)
+	for i := 0; i < cfg.BurstWorkers; i++ {
+		go func() {
+			defer b.wg.Done()
+			b.worker()
+		}()
+	}
+	return b
+}
+
+// Start starts a burst every burst period until Stop.
+func (b *burstLoad) Start() {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.run()
+	}()
+}
+
+// Stop ends the current burst, if any, and stops the load, waiting for its
+// workers to exit. It can be called more than once.
+func (b *burstLoad) Stop() {
+	b.stopOnce.Do(func() { close(b.stop) })
+	b.wg.Wait()
+}
+
+func (b *burstLoad) run() {
+	t := time.NewTicker(b.cfg.BurstPeriod)
+	defer t.Stop()
+
+	for {
+		var now time.Time
+		select {
+		case now = <-t.C:
+		case <-b.stop:
+			return
+		}
+		end := now.Add(b.cfg.BurstDuration)
+		b.lastEnd.Store(end.UnixNano())
+
+		b.mu.Lock()
+		b.burstEnd = end
+		close(b.start)
+		b.start = make(chan struct{})
+		b.mu.Unlock()
+	}
+}
+
+// Since returns whether a burst was running at any point since t.
+func (b *burstLoad) Since(t time.Time) bool {
+	return b.lastEnd.Load() >= t.UnixNano()
+}
+
+func (b *burstLoad) next() chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.start
+}
+
+func (b *burstLoad) end() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.burstEnd
+}
+
+func (b *burstLoad) worker() {
+	pinThread(b.cfg.WorkerCPUs)
+
+	op := jsonWork()
+
+	for {
+		select {
+		case <-b.next():
+		case <-b.stop:
+			return
+		}
+
+		end := b.end()
+		for time.Now().Before(end) {
+			select {
+			case <-b.stop:
+				return
+			default:
+			}
+			op()
+		}
+	}
+}
+
+// runExperiment runs an idle baseline phase, a loaded phase and an idle
+// recovery phase. The workers, bursts and load command only run in the
+// loaded phase.
+func runExperiment(cfg Config, pool *workerPool) {
+	setPhase("baseline")
+	time.Sleep(cfg.ExperimentDurations[0])
+
+	setPhase("loaded")
+	if bursts != nil {
+		bursts.Start()
+	}
+	if loadCmd != nil {
+		loadCmd.Start()
+	}
+	pool.SetActive(cfg.Workers)
+	time.Sleep(cfg.ExperimentDurations[1])
+	pool.Stop()
+	if bursts != nil {
+		bursts.Stop()
+	}
+	if loadCmd != nil {
+		loadCmd.Stop()
+	}
+
+	setPhase("recovery")
+	time.Sleep(cfg.ExperimentDurations[2])
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	loadCmdMinBackoff  = time.Second
+	loadCmdMaxBackoff  = 30 * time.Second
+	loadCmdKillTimeout = 5 * time.Second
+	loadCmdStderrTail  = 4096
+)
+
+// externalLoad runs an external command as load, restarting it with backoff
+// if it exits while the load is still meant to be running.
+type externalLoad struct {
+	args []string
+
+	stop chan struct{}
+	done chan struct{}
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	started  bool
+	stopping bool
+	starts   int
+	lastExit string
+	stderr   tailBuffer
+}
+
+// newExternalLoad returns an externalLoad for command, which is split on
+// whitespace (without any shell quoting) into the program and its arguments.
+func newExternalLoad(command string) *externalLoad {
+	return &externalLoad{
+		args:   strings.Fields(command),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		stderr: tailBuffer{max: loadCmdStderrTail},
+	}
+}
+
+// Start starts the command in the background.
+func (l *externalLoad) Start() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.started {
+		return
+	}
+	l.started = true
+	go l.supervise()
+}
+
+func (l *externalLoad) supervise() {
+	defer close(l.done)
+
+	backoff := loadCmdMinBackoff
+	for {
+		cmd := exec.Command(l.args[0], l.args[1:]...)
+		cmd.Stderr = &l.stderr
+
+		l.mu.Lock()
+		if l.stopping {
+			l.mu.Unlock()
+			return
+		}
+		err := cmd.Start()
+		if err == nil {
+			l.cmd = cmd
+			l.starts++
+		}
+		l.mu.Unlock()
+
+		started := time.Now()
+		if err == nil {
+			err = cmd.Wait()
+		}
+
+		l.mu.Lock()
+		l.cmd = nil
+		l.lastExit = exitStatus(err)
+		stopping := l.stopping
+		l.mu.Unlock()
+
+		if stopping {
+			return
+		}
+
+		if time.Since(started) > loadCmdMaxBackoff {
+			backoff = loadCmdMinBackoff
+		}
+		fmt.Fprintf(os.Stderr, "load command exited early (%v), restarting in %v\n", l.lastExitStatus(), backoff)
+		select {
+		case <-time.After(backoff):
+		case <-l.stop:
+			return
+		}
+		if backoff *= 2; backoff > loadCmdMaxBackoff {
+			backoff = loadCmdMaxBackoff
+		}
+	}
+}
+
+// Stop stops the command with SIGTERM, falling back to SIGKILL if it doesn't
+// exit promptly, and waits for it to exit.
+func (l *externalLoad) Stop() {
+	l.mu.Lock()
+	if !l.started || l.stopping {
+		l.mu.Unlock()
+		if l.started {
+			<-l.done
+		}
+		return
+	}
+	l.stopping = true
+	close(l.stop)
+	cmd := l.cmd
+	l.mu.Unlock()
+
+	if cmd != nil {
+		if err := cmd.Process.Signal(syscall.SIGTERM); err == nil {
+			select {
+			case <-l.done:
+				return
+			case <-time.After(loadCmdKillTimeout):
+			}
+		}
+		cmd.Process.Kill()
+	}
+	<-l.done
+}
+
+// Running returns whether the command is currently running.
+func (l *externalLoad) Running() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cmd != nil
+}
+
+func (l *externalLoad) lastExitStatus() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastExit
+}
+
+// PrintSummary prints how often the command ran, how it last exited, and the
+// tail of its stderr.
+func (l *externalLoad) PrintSummary() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Printf("Load command %q: started %d times, last exit: %v\n", strings.Join(l.args, " "), l.starts, l.lastExit)
+	if tail := l.stderr.String(); tail != "" {
+		fmt.Printf("Load command stderr (tail):\n%s\n", strings.TrimRight(tail, "\n"))
+	}
+}
+
+func exitStatus(err error) string {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "exit status 0"
+	case errors.As(err, &exitErr):
+		return exitErr.Error()
+	default:
+		return fmt.Sprintf("failed to run: %v", err)
+	}
+}
+
+// tailBuffer is an io.Writer that retains only the last max bytes written.
+type tailBuffer struct {
+	max int
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"sched-latency/config"
+)
+
+// processWorker returns a worker start function that re-executes this binary
+// as a child process running a single worker of the given workload. The child exits once its stdin
+// is closed, which happens when the worker is stopped or this process exits.
+func processWorker(cfg Config) func(w *workload, stop <-chan struct{}) {
+	exe, err := os.Executable()
+	if err != nil {
+		fatalf("failed to find executable for -load-process: %v", err)
+	}
+
+	args := []string{
+		"-" + config.WorkerOnlyFlag,
+		"-worker-duty=" + strconv.FormatFloat(cfg.WorkerDuty*100, 'g', -1, 64),
+		"-worker-period=" + cfg.WorkerPeriod.String(),
+	}
+	if len(cfg.WorkerCPUs) > 0 {
+		args = append(args, "-worker-cpus="+cfg.WorkerCPUs.String())
+	}
+	return func(w *workload, stop <-chan struct{}) {
+		cmd := exec.Command(exe, append(args, "-workload-mix="+w.name+":1")...)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			log.Printf("failed to create worker process stdin: %v", err)
+			return
+		}
+		if err := cmd.Start(); err != nil {
+			log.Printf("failed to start worker process: %v", err)
+			return
+		}
+
+		exited := make(chan error, 1)
+		go func() {
+			exited <- cmd.Wait()
+		}()
+
+		select {
+		case <-stop:
+			stdin.Close()
+			select {
+			case <-exited:
+			case <-time.After(loadCmdKillTimeout):
+				cmd.Process.Kill()
+				<-exited
+			}
+		case err := <-exited:
+			log.Printf("worker process %v exited unexpectedly: %v", cmd.Process.Pid, exitStatus(err))
+		}
+	}
+}
+
+// runWorkerOnly runs the workers until stdin is closed by the parent process.
+func runWorkerOnly(cfg Config) {
+	pool := newWorkerPool(cfg, &dutyStats{})
+	pool.SetActive(cfg.WorkloadMix.Workers())
+	io.Copy(io.Discard, os.Stdin)
+}
@@ -0,0 +1,1263 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"sched-latency/config"
+	"sched-latency/probe"
+	"sched-latency/report"
+	"sched-latency/stats"
+)
+
+const (
+	// exitFailed is the exit code when a -fail-if assertion fails.
+	exitFailed = 1
+
+	// exitSetupError is the exit code when the run couldn't be set up,
+	// such as for invalid flags.
+	exitSetupError = 2
+)
+
+// fatalf reports an error setting up the run and exits.
+func fatalf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	os.Exit(exitSetupError)
+}
+
+var (
+	// pool runs the CPU-bound workers that load the process.
+	pool *workerPool
+
+	// bursts is set when periodic bursts of extra workers are enabled.
+	bursts *burstLoad
+
+	// loadCmd is set when an external command is run as load.
+	loadCmd *externalLoad
+
+	// sinks are where each probe's interval results are reported.
+	sinks report.Sinks
+
+	// runTags identify this instance in every sink's results and in the
+	// summary.
+	runTags report.Tags
+
+	// out serializes everything printed to stdout while the probes run, so
+	// the reports, periodic lines and summaries don't tear or interleave.
+	out = report.NewWriter(os.Stdout)
+
+	// forcedGCs is set when GCs are forced periodically.
+	forcedGCs *forcedGC
+
+	// loadPhase is the name of the current load phase, e.g., "idle" or "loaded".
+	// It's only set when the run is split into load phases.
+	loadPhase atomic.Pointer[string]
+)
+
+// runFlags are the flags of the run, set by runMain once they're parsed.
+var runFlags *flag.FlagSet
+
+// isFlagSet returns whether the named flag was set on fs, on the command
+// line or from the environment or a config file.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	var set bool
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// setPhase starts a new load phase, both for report lines and the summary.
+func setPhase(name string) {
+	applyPhase(name)
+	if role != nil {
+		role.SendPhase(name)
+	}
+}
+
+// applyPhase starts the phase in this process, for setPhase, or when the
+// -role=load process starts it.
+func applyPhase(name string) {
+	loadPhase.Store(&name)
+	runSummary.SetPhase(name)
+}
+
+// Config is a run's config.Config, with what the command derives from it
+// for a sweep.
+type Config struct {
+	config.Config
+
+	// Sweep is the points to run the measurement at, if it's a sweep, and
+	// SweepSideBySide prints an -ab-godebug sweep's points side by side.
+	Sweep           []sweepPoint
+	SweepSideBySide bool
+}
+
+// runMain measures latencies, which is the default subcommand.
+func runMain(args []string) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	parsed := config.NewConfigFromFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v [run] [flags]\n\nMeasures scheduling latencies, optionally under load.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	runFlags = fs
+	if parsed.Version {
+		fmt.Println(build)
+		return
+	}
+
+	fromEnv, err := loadEnv(fs)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if parsed.ConfigFile != "" {
+		if err := loadConfigFile(fs, parsed.ConfigFile); err != nil {
+			fatalf("invalid -config: %v", err)
+		}
+	}
+	if !isFlagSet(fs, "seed") {
+		// Set the flag rather than the config, so the seed is passed on to
+		// child processes and recorded with the other flags.
+		fs.Set("seed", strconv.FormatInt(time.Now().UnixNano(), 10))
+	}
+	cfg := Config{Config: *parsed}
+	if isFlagSet(fs, "gomaxprocs") {
+		if cfg.AutoMaxProcs {
+			fatalf("-gomaxprocs can't be combined with -auto-maxprocs")
+		}
+		runtime.GOMAXPROCS(cfg.GOMAXPROCS)
+		if !isFlagSet(fs, "workers") {
+			cfg.Workers = cfg.GOMAXPROCS
+		}
+	}
+	if cfg.MonitorURL != "" {
+		// The point is the target's latencies, so don't load this process
+		// or measure it unless asked to.
+		if !isFlagSet(fs, "probes") {
+			cfg.Probes = config.ProbeList{}
+		}
+		if !isFlagSet(fs, "workers") && cfg.WorkloadMix == nil {
+			cfg.Workers = 0
+		}
+		if !isFlagSet(fs, "cpu-breakdown") {
+			cfg.CPUBreakdown = false
+		}
+	}
+	switch cfg.Role {
+	case "measure":
+		if !isFlagSet(fs, "workers") && cfg.WorkloadMix == nil {
+			cfg.Workers = 0
+		}
+	case "load":
+		if !isFlagSet(fs, "probes") {
+			cfg.Probes = config.ProbeList{}
+		}
+	}
+	if cfg.WorkloadMix != nil {
+		if n := cfg.WorkloadMix.Workers(); isFlagSet(fs, "workers") && n != cfg.Workers {
+			fatalf("-workload-mix has %d workers, but -workers is %d", n, cfg.Workers)
+		}
+		cfg.Workers = cfg.WorkloadMix.Workers()
+	}
+	if cfg.SweepChild {
+		// Only the summary JSON is read from sweep points, so discard
+		// everything else printed.
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			fatalf("failed to open %v: %v", os.DevNull, err)
+		}
+		os.Stdout = devNull
+	}
+	out = report.NewWriter(os.Stdout)
+	built, warnings, err := config.NewConfig(config.WithFlags(cfg.Config))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	cfg.Config = built
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "WARNING: %v\n", w)
+	}
+	if cfg.WorkerOnly {
+		runWorkerOnly(cfg)
+		return
+	}
+
+	runTags = report.NewTags(cfg.Instance)
+	var closeSinks []func() error
+	for _, spec := range strings.Split(cfg.Sink, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		sink, closeSink, err := report.Open(spec, out)
+		if err != nil {
+			fatalf("invalid -sink: %v", err)
+		}
+		sinks = append(sinks, sink)
+		closeSinks = append(closeSinks, closeSink)
+	}
+	if cfg.Reporter != nil {
+		sinks = append(sinks, cfg.Reporter)
+	}
+
+	if err := validateCPUSet("worker-cpus", cfg.WorkerCPUs); err != nil {
+		fatalf("%v", err)
+	}
+	if err := validateCPUSet("probe-cpus", cfg.ProbeCPUs); err != nil {
+		fatalf("%v", err)
+	}
+	if isFlagSet(fs, "nice") && errNiceUnsupported != nil {
+		fatalf("-nice: %v", errNiceUnsupported)
+	}
+	if cfg.ProbeRTPrio != 0 {
+		if err := checkRT(cfg.ProbeRTPolicy, cfg.ProbeRTPrio); err != nil {
+			fatalf("-probe-rt-priority: %v", err)
+		}
+	}
+	if isFlagSet(fs, "timer-slack") {
+		if errTimerSlackUnsupported != nil {
+			fatalf("-timer-slack: %v", errTimerSlackUnsupported)
+		}
+		// A slack of 0 resets the thread to its default slack, so use the
+		// smallest non-zero slack instead.
+		if cfg.TimerSlack == 0 {
+			cfg.TimerSlack = time.Nanosecond
+		}
+	}
+	if cfg.LoadCmd != "" {
+		if strings.TrimSpace(cfg.LoadCmd) == "" {
+			fatalf("-load-cmd must not be empty")
+		}
+		loadCmd = newExternalLoad(cfg.LoadCmd)
+	}
+	if err := checkRole(cfg, isFlagSet(fs, "probes")); err != nil {
+		fatalf("%v", err)
+	}
+	var baseline summaryJSON
+	if cfg.Baseline != "" {
+		var err error
+		if baseline, err = readSummaryJSON(cfg.Baseline); err != nil {
+			fatalf("invalid -baseline: %v", err)
+		}
+	} else if cfg.FailOnRegress > 0 {
+		fatalf("-fail-on-regression requires -baseline")
+	}
+	var sweeps [][]sweepPoint
+	if cfg.SweepGOMAXPROCS != "" {
+		values, err := parseIntList(cfg.SweepGOMAXPROCS, 1)
+		if err != nil {
+			fatalf("invalid -sweep-gomaxprocs: %v", err)
+		}
+		sweeps = append(sweeps, gomaxprocsSweep(values))
+	}
+	if cfg.SweepWorkers != "" {
+		values, err := parseIntList(cfg.SweepWorkers, 0)
+		if err != nil {
+			fatalf("invalid -sweep-workers: %v", err)
+		}
+		sweeps = append(sweeps, workersSweep(values))
+	}
+	if cfg.SweepSleep != "" {
+		intervals, err := config.ParseDurationList(cfg.SweepSleep)
+		if err != nil {
+			fatalf("invalid -sweep-sleep: %v", err)
+		}
+		sweeps = append(sweeps, sleepSweep(intervals))
+	}
+	if cfg.ABGODEBUG != "" {
+		variants, err := parseGODEBUGVariants(cfg.ABGODEBUG)
+		if err != nil {
+			fatalf("invalid -ab-godebug: %v", err)
+		}
+		cfg.SweepSideBySide = len(sweeps) == 0
+		sweeps = append(sweeps, godebugSweep(variants))
+	}
+	switch {
+	case len(sweeps) > 1 && !cfg.Matrix:
+		fatalf("only one -sweep-* flag can be set without -matrix")
+	case len(sweeps) > 0:
+		cfg.Sweep = matrixSweep(sweeps)
+	case cfg.Matrix:
+		fatalf("-matrix requires -sweep-* flags")
+	}
+	if cfg.Sweep != nil {
+		if cfg.PointDuration > 0 {
+			cfg.Duration = cfg.PointDuration
+		}
+		if cfg.Duration == 0 {
+			fatalf("sweeps require a -duration or -point-duration for each point")
+		}
+		if cfg.Baseline != "" || cfg.FailIf != nil {
+			fatalf("-baseline and -fail-if can't be combined with sweeps")
+		}
+		if cfg.Role != "" {
+			fatalf("-role can't be combined with sweeps")
+		}
+		sweepMain(fs, cfg)
+		return
+	}
+
+	cgroup := detectCgroupCPU()
+	if n := cgroup.maxProcs(); cfg.AutoMaxProcs && n > 0 && n < runtime.GOMAXPROCS(0) {
+		runtime.GOMAXPROCS(n)
+		cfg.GOMAXPROCS = n
+		if !isFlagSet(fs, "workers") {
+			cfg.Workers = n
+		}
+	}
+
+	var niceResult string
+	if isFlagSet(fs, "nice") {
+		niceResult = fmt.Sprintf("set to %d", cfg.Nice)
+		if err := setNice(cfg.Nice); err != nil {
+			niceResult = fmt.Sprintf("failed to set to %d: %v", cfg.Nice, err)
+		}
+		runSummary.AddNote("nice: " + niceResult)
+	}
+
+	var slackResult string
+	if cfg.TimerSlack > 0 {
+		prev, _ := getTimerSlack()
+		slackResult = fmt.Sprintf("%v -> %v", prev, cfg.TimerSlack)
+		if err := setTimerSlack(cfg.TimerSlack); err != nil {
+			slackResult = fmt.Sprintf("%v, failed to set to %v: %v", prev, cfg.TimerSlack, err)
+		}
+		runSummary.AddNote("timer slack: " + slackResult)
+	}
+
+	// On Windows, the default timer resolution quantizes every sleep to
+	// ~15.6ms, so it's raised for the run unless -keep-timer-resolution.
+	timerRes := setupTimerRes(cfg)
+	if timerRes != "" {
+		runSummary.AddNote("timer resolution: " + timerRes)
+	}
+
+	applyGCPercent(cfg.GOGC)
+	if cfg.GOMEMLIMIT > 0 {
+		debug.SetMemoryLimit(int64(cfg.GOMEMLIMIT))
+	}
+	if cfg.Ballast > 0 {
+		allocBallast(cfg.Ballast)
+	}
+
+	if cfg.IdleTimers > 0 {
+		addExitHook(startIdleTimers(cfg.IdleTimers))
+	}
+	if cfg.IdleGoroutines > 0 {
+		addExitHook(startIdleGoroutines(cfg.IdleGoroutines))
+	}
+	var conns *idleConns
+	if cfg.IdleConns > 0 {
+		var err error
+		if conns, err = startIdleConns(cfg.IdleConns); err != nil {
+			fatalf("failed to open -idle-conns: %v", err)
+		}
+		addExitHook(conns.Close)
+		if cfg.ActiveConns > 0 {
+			go conns.Activate(cfg.ActiveConns, cfg.ActiveInterval)
+		}
+	}
+
+	fmt.Println("Build:", build)
+	if cfg.Label != "" {
+		fmt.Println("Label:", cfg.Label)
+	}
+	fmt.Printf("Config: %+v\n", cfg.Config)
+	fmt.Println("Tags:", runTags)
+	fmt.Println("Seed:", cfg.Seed)
+	enabledProbes, skippedProbes := cfg.Probes.Resolve()
+	if cfg.WakeupBurst && !containsString(enabledProbes, "wakeup-burst") {
+		enabledProbes = append(enabledProbes, "wakeup-burst")
+	}
+	for i, name := range enabledProbes {
+		enabledProbes[i] = cfg.describeProbe(name)
+	}
+	if len(enabledProbes) == 0 {
+		enabledProbes = []string{"none"}
+	}
+	fmt.Println("Probes:", strings.Join(enabledProbes, ", "))
+	if cfg.MonitorURL != "" {
+		fmt.Printf("Monitor: /sched/latencies of %v, reported as %q\n", cfg.MonitorURL, monitorProbe(cfg.MonitorURL))
+		runSummary.AddNote("monitor: " + cfg.MonitorURL)
+	}
+	runSummary.AddNote("probes: " + strings.Join(enabledProbes, ", "))
+	if len(skippedProbes) > 0 {
+		runSummary.AddNote("probes: skipped from -probes=all: " + strings.Join(skippedProbes, ", "))
+	}
+	runSummary.SetResolution(cfg.BucketResolution)
+	runSummary.AddNote(fmt.Sprintf("summary: percentiles of the sleep and timer style probes are interpolated from buckets with %v resolution",
+		(*config.Percent)(&cfg.BucketResolution)))
+	if cfg.bucketed() {
+		fmt.Printf("Accumulation: samples are counted in log-spaced buckets with %v resolution, for up to %d samples per interval\n",
+			(*config.Percent)(&cfg.BucketResolution), cfg.expectedSamples())
+		runSummary.AddNote(fmt.Sprintf("accumulation: sleep and timer style percentiles are interpolated from buckets with %v resolution",
+			(*config.Percent)(&cfg.BucketResolution)))
+	}
+	if cfg.Pprof != "" {
+		if err := servePprof(cfg.Pprof); err != nil {
+			fatalf("failed to serve -pprof: %v", err)
+		}
+		fmt.Printf("Pprof: serving on %v\n", cfg.Pprof)
+		fmt.Fprintln(os.Stderr, "WARNING: CPU profiles and execution traces from -pprof perturb the measurements, e.g. with SIGPROF")
+	}
+	if cfg.Prometheus != "" {
+		promMetrics = newPromExporter(cfg)
+		if err := servePrometheus(cfg.Prometheus, promMetrics); err != nil {
+			fatalf("failed to serve -prometheus: %v", err)
+		}
+		fmt.Printf("Prometheus: serving /metrics on %v\n", cfg.Prometheus)
+	}
+	for _, e := range fromEnv {
+		fmt.Println("Environment:", e)
+		runSummary.AddNote("environment: " + e)
+	}
+	if cfg.ConfigFile != "" {
+		fmt.Println("Config file:", cfg.ConfigFile)
+		runSummary.AddNote("config file: " + cfg.ConfigFile)
+	}
+	printCPUs(cgroup)
+	if !cfg.SkipEnvCheck {
+		envWarnings = checkEnvironment()
+		for _, w := range envWarnings {
+			fmt.Fprintf(os.Stderr, "WARNING: environment: %v\n", w)
+		}
+	}
+	if niceResult != "" {
+		fmt.Println("Nice:", niceResult)
+	}
+	if slackResult != "" {
+		fmt.Println("Timer slack:", slackResult)
+	}
+	if timerRes != "" {
+		fmt.Println("Timer resolution:", timerRes)
+	}
+	if cfg.IdleTimers > 0 {
+		fmt.Printf("Idle timers: %d\n", cfg.IdleTimers)
+	}
+	if cfg.IdleGoroutines > 0 {
+		goroutines, stack, heap := goroutineMemory()
+		fmt.Printf("Idle goroutines: %d (%d total), stacks %v, heap %v\n",
+			cfg.IdleGoroutines, goroutines, formatBytes(stack), formatBytes(heap))
+	}
+	if conns != nil {
+		fmt.Printf("Idle connections: %v, %d active\n", conns, cfg.ActiveConns)
+		if n := conns.Count(); n < cfg.IdleConns {
+			fmt.Fprintf(os.Stderr, "WARNING: only opened %d of %d -idle-conns: %v\n", n, cfg.IdleConns, conns.openErr)
+		}
+	}
+	if len(cfg.WorkerCPUs) > 0 || len(cfg.ProbeCPUs) > 0 {
+		fmt.Printf("CPU affinity: workers %q, probes %q\n", cfg.WorkerCPUs, cfg.ProbeCPUs)
+	}
+	if cfg.LoadAfter > 0 {
+		fmt.Printf("Load: idle for %v, then loaded\n", cfg.LoadAfter)
+	}
+	if cfg.LoadProcess {
+		fmt.Println("Load: workers run in separate processes, probes share this process only with the Go runtime")
+	}
+
+	// The -role processes start together, so the warmup is from then.
+	switch cfg.Role {
+	case "measure":
+		fmt.Println("Role: measure, waiting for a -role=load process on", cfg.RoleSocket)
+		var err error
+		if role, err = listenRole(cfg.RoleSocket); err != nil {
+			fatalf("-role=measure: %v", err)
+		}
+		fmt.Printf("Role: started the load process (pid %d)\n", role.hello.Pid)
+		runSummary.AddNote(fmt.Sprintf("role: measure, with load process pid %d (%v), config: %v", role.hello.Pid, role.hello.Build, role.hello.Config))
+	case "load":
+		fmt.Println("Role: load, connecting to the -role=measure process on", cfg.RoleSocket)
+		var err error
+		if role, err = dialRole(cfg.RoleSocket, cfg); err != nil {
+			fatalf("-role=load: %v", err)
+		}
+		fmt.Println("Role: started by the measure process")
+		runSummary.AddNote("role: load, started by the measure process on " + cfg.RoleSocket)
+	}
+
+	if cfg.Warmup > 0 {
+		fmt.Printf("Warmup: %v, samples before then aren't in the summary\n", cfg.Warmup)
+		warmupEnd = time.Now().Add(cfg.Warmup)
+		runSummary.AddNote(fmt.Sprintf("warmup: first %v excluded", cfg.Warmup))
+	}
+
+	if cfg.Record != "" {
+		var err error
+		if recorder, err = newSampleRecorder(cfg.Record, cfg.Label, time.Now(), cfg.Seed); err != nil {
+			fatalf("failed to create -record file: %v", err)
+		}
+		fmt.Println("Recording samples to", cfg.Record)
+	}
+	var runTracer, runProfiler *runCapture
+	if cfg.Trace != "" {
+		var err error
+		if runTracer, err = startRunTrace(cfg.Trace); err != nil {
+			fatalf("failed to create -trace file: %v", err)
+		}
+		fmt.Println("Tracing: execution trace of the run after the warmup to", cfg.Trace)
+		fmt.Fprintln(os.Stderr, "WARNING: -trace perturbs the measurements slightly, so latencies are a little higher than untraced")
+	}
+	if cfg.CPUProfile != "" {
+		var err error
+		if runProfiler, err = startRunCPUProfile(cfg.CPUProfile); err != nil {
+			fatalf("failed to create -cpuprofile file: %v", err)
+		}
+		fmt.Println("CPU profile: of the run after the warmup to", cfg.CPUProfile)
+		fmt.Fprintln(os.Stderr, "WARNING: -cpuprofile perturbs the measurements, since SIGPROF interrupts the probes and workers")
+	}
+	startContentionProfiles(cfg)
+	if cfg.BlockProfile != "" {
+		fmt.Printf("Block profile: at a rate of one sample per %vns blocked, to %v\n", cfg.BlockProfileRate, cfg.BlockProfile)
+		runSummary.AddNote(fmt.Sprintf("blockprofile: written to %v, with a block profile rate of %d", cfg.BlockProfile, cfg.BlockProfileRate))
+	}
+	if cfg.MutexProfile != "" {
+		fmt.Printf("Mutex profile: sampling 1 in %d contention events, to %v\n", cfg.MutexProfileFraction, cfg.MutexProfile)
+		runSummary.AddNote(fmt.Sprintf("mutexprofile: written to %v, with a mutex profile fraction of %d", cfg.MutexProfile, cfg.MutexProfileFraction))
+	}
+	if cfg.TraceOnSpike > 0 {
+		var err error
+		if spikeTraces, err = newSpikeTracer(cfg); err != nil {
+			fatalf("failed to create -trace-dir: %v", err)
+		}
+		fmt.Printf("Traces: %v after any sample above %v, to %v, at most one per %v\n",
+			cfg.TraceDuration, cfg.TraceOnSpike, cfg.TraceDir, cfg.TraceCooldown)
+	}
+	if cfg.DumpOnSpike > 0 {
+		var err error
+		if spikeDumps, err = newSpikeDumper(cfg); err != nil {
+			fatalf("failed to create -dump-dir: %v", err)
+		}
+		fmt.Printf("Goroutine dumps: after any sample above %v, to %v, at most one per %v\n",
+			cfg.DumpOnSpike, cfg.DumpDir, cfg.DumpCooldown)
+	}
+	if cfg.HeapProfileEvery > 0 {
+		var err error
+		if heapProfiles, err = newHeapProfiler(cfg, time.Now()); err != nil {
+			fatalf("failed to create -profile-dir: %v", err)
+		}
+		go heapProfiles.Run()
+		fmt.Printf("Heap profiles: every %v and at the end, to %v\n", cfg.HeapProfileEvery, cfg.ProfileDir)
+	}
+	if cfg.OnBreachCmd != "" {
+		breaches = newBreachHook(cfg)
+		fmt.Printf("Breach command: %q when %v is above %v, at most once per %v\n",
+			cfg.OnBreachCmd, stats.PercentileName(cfg.BreachPercentile), cfg.BreachThreshold, cfg.BreachCooldown)
+	}
+	if cfg.Smooth > 0 {
+		smoothing = newSmoother(cfg.Smooth)
+		fmt.Printf("Smoothing: moving average over about %d intervals, restarting each phase\n", cfg.Smooth)
+	}
+	if cfg.TailThresholds != nil {
+		fmt.Printf("Tail counts: samples over each of %v, per interval and in the summary\n", cfg.TailThresholds)
+	}
+	if cfg.AnomalyK > 0 {
+		anomalies = newAnomalyDetector(cfg)
+		fmt.Println("Anomalies: flagging", describeAnomalies(cfg))
+	}
+	reportOnSignal(cfg)
+	runSamples.target = cfg.Samples
+	outlierThreshold = cfg.OutlierThreshold
+	if cfg.LogOutliers {
+		outlierLog = newOutlierLogger()
+	}
+	// The load is set up before the probes start, since their results
+	// read it, and only started once they're running.
+	var duty dutyStats
+	pool = newWorkerPool(cfg, &duty)
+	if cfg.BurstWorkers > 0 {
+		bursts = newBurstLoad(cfg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var probes sync.WaitGroup
+	startProbe := func(probe func(ctx context.Context)) {
+		probes.Add(1)
+		go func() {
+			defer probes.Done()
+			probe(ctx)
+		}()
+	}
+
+	// The intervals are created up front, so they're reported in the same
+	// order on every tick.
+	registerProbes(cfg)
+	for _, p := range probe.Registered() {
+		p, interval := p, newSampleInterval(ctx, cfg, p.Name())
+		if target := probeTarget(p); target > 0 {
+			probeTargets[p.Name()] = target
+			interval.samples.Reserve(cfg.intervalSamples(target))
+		}
+		interval.spinner = probeSpinner(p)
+		startProbe(func(ctx context.Context) { runProbe(ctx, interval, p) })
+	}
+	if cfg.ProbeEnabled("sched") {
+		if err := stats.CheckMetric(schedMetric, metrics.KindFloat64Histogram); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: skipping the /sched/latencies probe: %v\n", err)
+			runSummary.AddNote("skipped /sched/latencies: " + err.Error())
+		} else {
+			s, _ := newSchedInterval(ctx, cfg, schedProbe, readLocalSched())
+			startProbe(s.Run)
+		}
+	}
+	if cfg.MonitorURL != "" {
+		s, err := newSchedInterval(ctx, cfg, monitorProbe(cfg.MonitorURL), newMonitor(cfg).read)
+		if err != nil {
+			fatalf("failed to read -monitor-url: %v", err)
+		}
+		startProbe(s.Run)
+	}
+	if cfg.CPUBreakdown {
+		if cpuBreakdown, err = newCPUClasses(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: not reporting the CPU breakdown: %v\n", err)
+			runSummary.AddNote("cpu-breakdown: skipped: " + err.Error())
+		}
+	}
+	startProbe(func(ctx context.Context) { reportTick.Run(ctx, cfg) })
+	if cfg.GOMEMLIMIT > 0 {
+		if err := checkMemoryLimitMetrics(); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: not reporting the memory limit: %v\n", err)
+		} else {
+			startProbe(func(ctx context.Context) { measureMemoryLimit(ctx, cfg) })
+		}
+	}
+	if cgroup != nil && cgroup.quota > 0 {
+		startProbe(func(ctx context.Context) { measureThrottling(ctx, cfg, cgroup) })
+	}
+	if cfg.StallThreshold > 0 {
+		stalls = newStallWatchdog(cfg)
+		startProbe(stalls.Run)
+	}
+	if cfg.ForceGCEvery > 0 {
+		forcedGCs = newForcedGC(cfg)
+		go forcedGCs.Run()
+	}
+
+	if !cfg.LoadProcess {
+		if cfg.WorkerDuty < 1 {
+			startProbe(func(ctx context.Context) { measureWorkerDuty(ctx, cfg, &duty) })
+		}
+		if cfg.WorkloadMix != nil || cfg.Role == "load" {
+			startProbe(func(ctx context.Context) { measureWorkerOps(ctx, cfg, pool) })
+		}
+	}
+
+	var timeout <-chan time.Time
+	if cfg.Duration > 0 {
+		timeout = time.After(cfg.Duration)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case <-runLoad(cfg):
+	case <-timeout:
+	case <-runSamples.Done():
+	case <-roleDone():
+		runSummary.AddNote("stopped: the other -role process stopped")
+	case sig := <-sigs:
+		fmt.Fprintf(os.Stderr, "received %v, stopping (again to exit immediately)\n", sig)
+		exitCode = 128 + int(sig.(syscall.Signal))
+		runSummary.AddNote(fmt.Sprintf("stopped early: received %v", sig))
+		go func() {
+			sig := <-sigs
+			fmt.Fprintf(os.Stderr, "received %v, exiting\n", sig)
+			os.Exit(exitCode)
+		}()
+	}
+
+	if role != nil {
+		role.Close()
+	}
+
+	// Stop the probes first, so they record their last partial interval
+	// before the load is stopped.
+	cancel()
+	probes.Wait()
+	for _, closeSink := range closeSinks {
+		if err := closeSink(); err != nil {
+			log.Printf("failed to close -sink: %v", err)
+		}
+	}
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			log.Printf("failed to write -record file: %v", err)
+		}
+		runSummary.AddNote(fmt.Sprintf("recorded to %v: %v", cfg.Record, recorder))
+		if n := recorder.dropped.Load(); n > 0 {
+			fmt.Fprintf(os.Stderr, "WARNING: dropped %d samples from -record, since writing fell behind\n", n)
+		}
+	}
+	if runTracer != nil {
+		if err := runTracer.Stop(); err != nil {
+			log.Printf("failed to write -trace: %v", err)
+		}
+		runSummary.AddNote(fmt.Sprintf("trace: the run was traced to %v, which perturbs its latencies slightly", cfg.Trace))
+	}
+	if runProfiler != nil {
+		if err := runProfiler.Stop(); err != nil {
+			log.Printf("failed to write -cpuprofile: %v", err)
+		}
+		runSummary.AddNote(fmt.Sprintf("cpuprofile: the run was CPU profiled to %v, whose SIGPROF signals perturb its latencies", cfg.CPUProfile))
+	}
+	if spikeTraces != nil {
+		spikeTraces.Stop()
+		runSummary.AddNote(fmt.Sprintf("trace-on-spike: %d traces captured in %v", spikeTraces.Captures(), cfg.TraceDir))
+	}
+	if spikeDumps != nil {
+		spikeDumps.Stop()
+		runSummary.AddNote(fmt.Sprintf("dump-on-spike: %d goroutine dumps written to %v", spikeDumps.Captures(), cfg.DumpDir))
+	}
+	pool.Stop()
+	if breaches != nil {
+		runSummary.AddNote("on-breach-cmd: " + breaches.String())
+	}
+	if anomalies != nil {
+		runSummary.AddNote("anomalies: " + anomalies.String())
+	}
+	if cpuBreakdown != nil {
+		if classes, ok := cpuBreakdown.Summary(); ok {
+			runSummary.AddNote(fmt.Sprintf("cpu: %v", classes))
+		}
+	}
+	if heapProfiles != nil {
+		heapProfiles.Stop()
+		runSummary.AddNote("heap-profile-every: " + heapProfiles.String())
+	}
+	if cfg.BlockProfile != "" {
+		if err := writeProfile("block", cfg.BlockProfile); err != nil {
+			log.Printf("failed to write -blockprofile: %v", err)
+		}
+	}
+	if cfg.MutexProfile != "" {
+		if err := writeProfile("mutex", cfg.MutexProfile); err != nil {
+			log.Printf("failed to write -mutexprofile: %v", err)
+		}
+	}
+
+	if cpuProfiled.Load() {
+		runSummary.AddNote("pprof: a CPU profile was taken during the run")
+	}
+	if traced.Load() {
+		runSummary.AddNote("pprof: an execution trace was taken during the run")
+	}
+	if cfg.Experiment {
+		out.Do(func(w io.Writer) { runSummary.PrintComparison(w, cfg, "baseline", "loaded", "recovery") })
+	} else {
+		out.Do(func(w io.Writer) { runSummary.Print(w, cfg, "Summary") })
+	}
+	printLoadCmdSummary()
+	if cfg.SummaryJSON != "" || cfg.Baseline != "" {
+		summary := runSummary.JSON(cfg)
+		if cfg.SummaryJSON != "" {
+			if err := writeSummaryJSON(cfg.SummaryJSON, summary); err != nil {
+				log.Printf("failed to write -summary-json: %v", err)
+			}
+		}
+		if cfg.Baseline != "" && compareBaseline(cfg, baseline, summary, float64(cfg.FailOnRegress), nil) {
+			exitCode = exitFailed
+		}
+	}
+	if cfg.FailIf != nil && checkFailIf(cfg, runSummary) {
+		exitCode = exitFailed
+	}
+	runExitHooks()
+	os.Exit(exitCode)
+}
+
+// runLoad runs the load for the configured schedule, returning a channel
+// that's closed once the schedule completes. Without a fixed schedule, the
+// load runs forever.
+func runLoad(cfg Config) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		if cfg.LoadAfter > 0 {
+			setPhase("idle")
+			time.Sleep(cfg.LoadAfter)
+			setPhase("loaded")
+		}
+
+		if cfg.Experiment {
+			runExperiment(cfg, pool)
+			close(done)
+			return
+		}
+
+		if bursts != nil {
+			bursts.Start()
+		}
+		if loadCmd != nil {
+			loadCmd.Start()
+		}
+
+		if cfg.Ramp == nil {
+			pool.SetActive(cfg.Workers)
+			return
+		}
+
+		runRamp(cfg.Ramp, pool)
+		close(done)
+	}()
+	return done
+}
+
+// exitHooks release resources used to load the process when the run ends.
+var exitHooks []func()
+
+func addExitHook(f func()) {
+	exitHooks = append(exitHooks, f)
+}
+
+// runExitHooks runs the exit hooks in the reverse order they were added.
+func runExitHooks() {
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+}
+
+// printLoadCmdSummary stops the external load command, if any, and prints
+// its summary.
+func printLoadCmdSummary() {
+	if loadCmd == nil {
+		return
+	}
+	loadCmd.Stop()
+	loadCmd.PrintSummary()
+}
+
+// setupProbeThread pins the probe's thread to -probe-cpus and, for
+// real-time probes, switches it to the real-time scheduling policy.
+// Probes locked to their thread also get the -timer-slack.
+func setupProbeThread(cfg Config, name string, rt bool) {
+	pinThread(cfg.ProbeCPUs)
+	if rt {
+		runtime.LockOSThread()
+		if err := setThreadRT(cfg.ProbeRTPolicy, cfg.ProbeRTPrio); err != nil {
+			log.Printf("failed to set real-time priority for %v: %v", name, err)
+		}
+	}
+
+	if locked := rt || len(cfg.ProbeCPUs) > 0; locked && cfg.TimerSlack > 0 {
+		if err := setThreadTimerSlack(cfg.TimerSlack); err != nil {
+			log.Printf("failed to set timer slack for %v: %v", name, err)
+		}
+	}
+}
+
+// schedMetric is the runtime/metrics histogram of scheduling latencies.
+const schedMetric = "/sched/latencies:seconds"
+
+// schedProbe is the name the /sched/latencies histogram is reported under.
+const schedProbe = config.SchedProbe
+
+// schedInterval reports a /sched/latencies histogram's percentiles over
+// each report interval, diffing the histogram at each tick against the
+// last. The histogram is this process's, or a -monitor-url target's.
+type schedInterval struct {
+	cfg  Config
+	name string
+	read func() (stats.HistSnapshot, error)
+	ctx  context.Context
+	task *trace.Task
+
+	mu    sync.Mutex
+	last  stats.HistSnapshot
+	start time.Time
+
+	// The summary only counts latencies after the warmup, so it diffs
+	// against its own snapshot, taken again when the warmup ends.
+	summaryLast stats.HistSnapshot
+
+	// The latencies are only known per interval, so outliers are logged
+	// with whether a GC ran during the interval.
+	gcLast uint64
+
+	// resets counts the intervals discarded since the histogram was reset,
+	// and failed the reads of it that failed.
+	resets int
+	failed int
+
+	// total has the latencies of every interval since totalStart, the start
+	// of the run or the end of the warmup, for -sched-total.
+	total      stats.HistSnapshot
+	totalStart time.Time
+}
+
+// newSchedInterval returns the interval of the histogram read by read,
+// reported on reportTick under name until its Run exits. It fails if the
+// first read does.
+func newSchedInterval(ctx context.Context, cfg Config, name string, read func() (stats.HistSnapshot, error)) (*schedInterval, error) {
+	s := &schedInterval{cfg: cfg, name: name, read: read}
+	last, err := read()
+	if err != nil {
+		return nil, err
+	}
+	s.ctx, s.task = trace.NewTask(ctx, name)
+	if outlierLog != nil {
+		s.gcLast = gcCycles()
+	}
+	s.last = last
+	s.start = time.Now()
+	s.summaryLast = s.last
+	s.totalStart = s.start
+	if s.totalStart.Before(warmupEnd) {
+		s.totalStart = warmupEnd
+	}
+	reportTick.Add(s)
+	return s, nil
+}
+
+// readLocalSched returns a reader of this process's /sched/latencies
+// histogram, which never fails.
+func readLocalSched() func() (stats.HistSnapshot, error) {
+	read := []metrics.Sample{{Name: schedMetric}}
+	return func() (stats.HistSnapshot, error) {
+		metrics.Read(read)
+		return stats.NewHistSnapshot(read[0].Value.Float64Histogram()), nil
+	}
+}
+
+// snapshot reads the histogram, or warns and returns false if the read
+// fails, in which case the interval carries on until the next read.
+func (s *schedInterval) snapshot() (stats.HistSnapshot, bool) {
+	h, err := s.read()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: %v: %v\n", s.name, err)
+		s.failed++
+		return stats.HistSnapshot{}, false
+	}
+	return h, true
+}
+
+// discard drops the interval ending at now, since the histogram went
+// backwards or changed its buckets in it, which would make its diff
+// nonsense. cur becomes the baseline for the next interval and the summary.
+func (s *schedInterval) discard(now time.Time, cur stats.HistSnapshot, err error) {
+	fmt.Fprintf(os.Stderr, "WARNING: %v: histogram reset detected (%v), discarding the interval\n", s.name, err)
+	s.resets++
+	s.last, s.summaryLast, s.start = cur, cur, now
+}
+
+// Run resets the summary's snapshot when the warmup ends, and reports the
+// last partial interval once ctx is done.
+func (s *schedInterval) Run(ctx context.Context) {
+	defer s.task.End()
+
+	var warmupDone <-chan time.Time
+	if d := time.Until(warmupEnd); d > 0 {
+		warmupDone = time.After(d)
+	}
+	select {
+	case <-warmupDone:
+		s.mu.Lock()
+		if cur, ok := s.snapshot(); ok {
+			s.summaryLast = cur
+		}
+		s.mu.Unlock()
+		<-ctx.Done()
+	case <-ctx.Done():
+	}
+
+	reportTick.Remove(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() {
+		if s.resets > 0 {
+			runSummary.AddNote(fmt.Sprintf("%v: discarded %d intervals after histogram resets", s.name, s.resets))
+		}
+		if s.failed > 0 {
+			runSummary.AddNote(fmt.Sprintf("%v: %d reads of the histogram failed", s.name, s.failed))
+		}
+	}()
+
+	now := time.Now()
+	cur, ok := s.snapshot()
+	if !ok {
+		return
+	}
+	diff, err := cur.Diff(s.last)
+	if err != nil {
+		s.discard(now, cur, err)
+		return
+	}
+	if !now.Before(warmupEnd) {
+		if summaryDiff, err := cur.Diff(s.summaryLast); err == nil {
+			runSummary.AddHistogram(s.name, summaryDiff)
+		}
+	}
+	if diff.Count() > 0 {
+		s.cfg.ReportHistPartial(s.name, s.start, diff)
+	}
+}
+
+// Next implements intervalSource.
+func (s *schedInterval) Next(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, ok := s.snapshot()
+	if !ok {
+		return
+	}
+	diff, err := cur.Diff(s.last)
+	if err != nil {
+		s.discard(now, cur, err)
+		return
+	}
+	if !now.Before(warmupEnd) {
+		// The summary's baseline is never newer than the interval's, so
+		// it's consistent with cur if the interval is.
+		if summaryDiff, err := cur.Diff(s.summaryLast); err == nil {
+			runSummary.AddHistogram(s.name, summaryDiff)
+		}
+		s.summaryLast = cur
+	}
+	maxBound := diff.MaxBound()
+	logOutlier(s.ctx, s.name, maxBound, now, s.gcLast)
+	if outlierLog != nil {
+		outlierLog.Refresh()
+		s.gcLast = gcCycles()
+	}
+	observeSpike(s.name, maxBound, now)
+	s.cfg.ReportHist(s.name, s.start, now, diff)
+	if s.cfg.SchedTotal && !s.start.Before(s.totalStart) {
+		s.reportTotal(now, diff)
+	}
+	s.start = now
+	s.last = cur
+}
+
+// reportTotal adds the interval's diff to the total since totalStart, and
+// reports the total's percentiles. Intervals discarded after a reset are
+// left out of the total, rather than restarting it.
+func (s *schedInterval) reportTotal(now time.Time, diff stats.HistSnapshot) {
+	total, err := s.total.Merge(diff)
+	if err != nil {
+		// The buckets changed without the counts going down, so start a new
+		// total with them.
+		total, s.totalStart = diff, s.start
+	}
+	s.total = total
+	s.cfg.emit(s.cfg.histResult(s.name+" (total)", s.totalStart, now, total, false, nil))
+}
+
+// Partial implements intervalSource.
+func (s *schedInterval) Partial() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, ok := s.snapshot()
+	if !ok {
+		return
+	}
+	diff, err := cur.Diff(s.last)
+	if err != nil {
+		s.discard(time.Now(), cur, err)
+		return
+	}
+	s.cfg.ReportHistPartial(s.name, s.start, diff)
+}
+
+// percentileIndex returns the index of the percentile p in the configured
+// percentiles, or -1 if it isn't one of them.
+func (c Config) percentileIndex(p float64) int {
+	for i, q := range c.Percentiles {
+		if q == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// Report reports the percentiles measured by a probe over count samples in
+// the interval from intervalStart to end, of which negative had a negative
+// delay, and tail, the number over each -tail-thresholds, if any. The
+// probe's extras annotate how it was measured.
+func (c Config) Report(name string, intervalStart, end time.Time, percentileSamples []time.Duration, count, negative uint64, tail []uint64, extras ...string) {
+	r := c.result(name, intervalStart, end, percentileSamples, count, negative, tail, false, extras)
+	if promMetrics != nil {
+		promMetrics.SetPercentiles(r)
+	}
+	c.report(r)
+}
+
+// ReportHist reports the values in a histogram added in the interval from
+// intervalStart to end, as Report does, with its buckets if -raw-buckets
+// is set.
+func (c Config) ReportHist(name string, intervalStart, end time.Time, h stats.HistSnapshot, extras ...string) {
+	if promMetrics != nil {
+		promMetrics.AddHistogram(name, h)
+	}
+	c.report(c.histResult(name, intervalStart, end, h, false, extras))
+}
+
+// ReportHistPartial is ReportHist for an interval that's still in
+// progress, as ReportPartial is for Report.
+func (c Config) ReportHistPartial(name string, intervalStart time.Time, h stats.HistSnapshot, extras ...string) {
+	c.emit(c.histResult(name, intervalStart, time.Now(), h, true, extras))
+}
+
+// histResult is result for the values in a histogram.
+func (c Config) histResult(name string, intervalStart, end time.Time, h stats.HistSnapshot, partial bool, extras []string) report.Result {
+	r := c.result(name, intervalStart, end, h.Percentiles(c.Percentiles), h.Count(), 0, c.tail(h), partial, extras)
+	if c.RawBuckets {
+		r.Buckets = report.NonEmptyBuckets(h)
+	}
+	return r
+}
+
+// report smooths, checks and records a completed interval's result, then
+// emits it.
+func (c Config) report(r report.Result) {
+	if smoothing != nil {
+		r.Smoothed = smoothing.Smooth(r)
+	}
+	if !r.Warmup {
+		runSummary.AddInterval(r.Probe, r.Start, r.End(), r.Values, c.percentileIndex(0.99))
+		if breaches != nil {
+			breaches.Check(r)
+		}
+		if anomalies != nil && anomalies.Check(r) {
+			r.Anomaly = true
+			r.Extras = append(r.Extras, "⚠ anomaly")
+		}
+	}
+	c.emit(r)
+}
+
+// ReportPartial reports the percentiles of an interval that's still in
+// progress, marked with how long it's run so far.
+func (c Config) ReportPartial(name string, intervalStart time.Time, percentileSamples []time.Duration, count, negative uint64, tail []uint64, extras ...string) {
+	c.emit(c.result(name, intervalStart, time.Now(), percentileSamples, count, negative, tail, true, extras))
+}
+
+// overrunTolerance is how far, as a fraction of the report interval, an
+// interval's length can be from it before the report shows the length.
+const overrunTolerance = 0.1
+
+// overran returns whether an interval of length d is too far from the report
+// interval, e.g., since the reporting goroutine was starved and missed ticks.
+func (c Config) overran(d time.Duration) bool {
+	off := d - c.ReportInterval
+	if off < 0 {
+		off = -off
+	}
+	return float64(off) > overrunTolerance*float64(c.ReportInterval)
+}
+
+// result returns the result for an interval, annotated with the state of
+// the load and any files captured during it.
+func (c Config) result(name string, intervalStart, end time.Time, percentileSamples []time.Duration, count, negative uint64, tail []uint64, partial bool, extras []string) report.Result {
+	r := report.Result{
+		Probe:       name,
+		Start:       intervalStart,
+		Duration:    end.Sub(intervalStart),
+		Percentiles: c.Percentiles,
+		Values:      percentileSamples,
+		Count:       count,
+		Negative:    negative,
+		Tail:        tail,
+		Partial:     partial,
+		Warmup:      intervalStart.Before(warmupEnd),
+		Label:       c.Label,
+		Relative:    c.Relative,
+		RawBuckets:  c.RawBuckets,
+	}
+	if tail != nil {
+		r.Thresholds = c.TailThresholds
+	}
+	if c.Relative {
+		r.Target = probeTargets[name]
+	}
+	if partial {
+		r.Extras = append(r.Extras, fmt.Sprintf("(partial %v)", r.Duration.Truncate(time.Millisecond)))
+	} else if c.overran(r.Duration) {
+		r.Extras = append(r.Extras, fmt.Sprintf("(interval %v)", r.Duration.Round(100*time.Millisecond)))
+	}
+	r.Extras = append(r.Extras, extras...)
+	if c.Ramp != nil {
+		r.Extras = append(r.Extras, fmt.Sprintf("workers %d", pool.Active()))
+	}
+	if phase := loadPhase.Load(); phase != nil {
+		r.Extras = append(r.Extras, "phase "+*phase)
+	}
+	if loadCmd != nil {
+		if loadCmd.Running() {
+			r.Extras = append(r.Extras, "load-cmd running")
+		} else {
+			r.Extras = append(r.Extras, "load-cmd stopped")
+		}
+	}
+	if forcedGCs != nil {
+		r.Extras = append(r.Extras, fmt.Sprintf("forced-gc %d", forcedGCs.Since(intervalStart)))
+	}
+	if cpuBreakdown != nil && !partial {
+		r.CPU = cpuBreakdown.Last()
+	}
+	if role != nil {
+		if ops := role.Ops(); ops != "" {
+			r.Extras = append(r.Extras, "load "+ops)
+		}
+	}
+	if stalls != nil {
+		if d := stalls.During(intervalStart, end); d > 0 {
+			r.Extras = append(r.Extras, fmt.Sprintf("[stalled %v]", d.Truncate(time.Millisecond)))
+		}
+	}
+	if bursts != nil && bursts.Since(intervalStart) {
+		r.Extras = append(r.Extras, "[burst]")
+	}
+	if spikeTraces != nil {
+		for _, path := range spikeTraces.Since(name, intervalStart) {
+			r.Extras = append(r.Extras, "trace "+path)
+		}
+	}
+	if spikeDumps != nil {
+		for _, path := range spikeDumps.Since(name, intervalStart) {
+			r.Extras = append(r.Extras, "dump "+path)
+		}
+	}
+	if c.Samples > 0 {
+		if n, ok := runSamples.Progress(name); ok {
+			r.Extras = append(r.Extras, fmt.Sprintf("samples %d/%d", n, c.Samples))
+		}
+	}
+	if r.Warmup {
+		r.Extras = append(r.Extras, "(warmup)")
+	}
+	return r
+}
+
+// emit sends the result to the -sink sinks, skipping warmup intervals
+// unless they're reported.
+func (c Config) emit(r report.Result) {
+	if r.Warmup && !c.ReportWarmup {
+		return
+	}
+	if err := (report.Tagged{Sink: sinks, Tags: runTags}).Report(r); err != nil {
+		log.Printf("failed to report %v: %v", r.Probe, err)
+	}
+}
+
+// SamplePercentiles returns the samples' values at the configured
+// percentiles.
+func (c Config) SamplePercentiles(samples []time.Duration) []time.Duration {
+	return stats.SamplePercentiles(samples, c.Percentiles)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"time"
+
+	"sched-latency/config"
+	"sched-latency/stats"
+)
+
+// ballast is retained for the whole run so the GC pacer sees a larger live heap.
+var ballast []byte
+
+// allocBallast allocates the ballast and touches every page so it's resident.
+func allocBallast(size config.ByteSize) {
+	ballast = make([]byte, size)
+	pageSize := os.Getpagesize()
+	for i := 0; i < len(ballast); i += pageSize {
+		ballast[i] = 1
+	}
+}
+
+// applyGCPercent sets GOGC if it was specified on the command line.
+func applyGCPercent(g config.GCPercent) {
+	if percent, ok := g.Percent(); ok {
+		debug.SetGCPercent(percent)
+	}
+}
+
+// memoryLimitMetrics are the metrics read by measureMemoryLimit.
+var memoryLimitMetrics = []string{"/gc/heap/goal:bytes", "/memory/classes/total:bytes"}
+
+// checkMemoryLimitMetrics returns an error if the runtime doesn't support
+// the metrics measureMemoryLimit reads.
+func checkMemoryLimitMetrics() error {
+	for _, name := range memoryLimitMetrics {
+		if err := stats.CheckMetric(name, metrics.KindUint64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// measureMemoryLimit reports how close the heap goal and the total memory
+// mapped by the runtime are to the memory limit.
+func measureMemoryLimit(ctx context.Context, cfg Config) {
+	t := time.NewTicker(cfg.ReportInterval)
+	defer t.Stop()
+
+	samples := make([]metrics.Sample, len(memoryLimitMetrics))
+	for i, name := range memoryLimitMetrics {
+		samples[i].Name = name
+	}
+
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		metrics.Read(samples)
+
+		goal, total := samples[0].Value.Uint64(), samples[1].Value.Uint64()
+		out.Printf("%20s: heap goal %v (%.1f%%) total %v (%.1f%%) of %v\n", "memory limit",
+			formatBytes(goal), 100*float64(goal)/float64(cfg.GOMEMLIMIT),
+			formatBytes(total), 100*float64(total)/float64(cfg.GOMEMLIMIT),
+			cfg.GOMEMLIMIT)
+	}
+}
+
+// binaryUnits are the units formatBytes uses, from the smallest.
+var binaryUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+}
+
+// formatBytes formats n using the largest binary unit it exceeds, e.g. "1.5GiB".
+func formatBytes(n uint64) string {
+	for i := len(binaryUnits) - 1; i >= 0; i-- {
+		if u := binaryUnits[i]; n >= u.size {
+			return strconv.FormatFloat(float64(n)/float64(u.size), 'f', 2, 64) + u.suffix
+		}
+	}
+	return strconv.FormatUint(n, 10) + "B"
+}
+
+// forcedGC runs runtime.GC on a fixed period, and records when each forced
+// collection ran so reports can be annotated with them.
+type forcedGC struct {
+	period  time.Duration
+	history time.Duration
+
+	mu   sync.Mutex
+	runs []time.Time
+}
+
+func newForcedGC(cfg Config) *forcedGC {
+	return &forcedGC{
+		period: cfg.ForceGCEvery,
+		// Keep enough history to cover a report interval, even if the probe
+		// reporting it was delayed.
+		history: 10 * cfg.ReportInterval,
+	}
+}
+
+// Run forces a GC every period.
+func (g *forcedGC) Run() {
+	t := time.NewTicker(g.period)
+	for {
+		<-t.C
+		runtime.GC()
+
+		now := time.Now()
+		g.mu.Lock()
+		for len(g.runs) > 0 && now.Sub(g.runs[0]) > g.history {
+			g.runs = g.runs[1:]
+		}
+		g.runs = append(g.runs, now)
+		g.mu.Unlock()
+	}
+}
+
+// Since returns the number of forced GCs that completed since t.
+func (g *forcedGC) Since(t time.Time) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var n int
+	for _, run := range g.runs {
+		if !run.Before(t) {
+			n++
+		}
+	}
+	return n
+}
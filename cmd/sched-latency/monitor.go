@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"sched-latency/export"
+	"sched-latency/stats"
+)
+
+// monitorTimeout is the longest a read of the -monitor-url target's
+// histogram can take, so a hung target doesn't stall the report tick.
+const monitorTimeout = 5 * time.Second
+
+// monitor reads the /sched/latencies histogram of another Go process, as
+// served by the export package.
+type monitor struct {
+	url    string
+	client *http.Client
+}
+
+func newMonitor(cfg Config) *monitor {
+	timeout := cfg.ReportInterval / 2
+	if timeout > monitorTimeout {
+		timeout = monitorTimeout
+	}
+	return &monitor{url: cfg.MonitorURL, client: &http.Client{Timeout: timeout}}
+}
+
+// read fetches the target's histogram.
+func (m *monitor) read() (stats.HistSnapshot, error) {
+	resp, err := m.client.Get(m.url)
+	if err != nil {
+		return stats.HistSnapshot{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return stats.HistSnapshot{}, fmt.Errorf("%v: %v", m.url, resp.Status)
+	}
+	h, err := export.Decode(resp.Body)
+	if err != nil {
+		return stats.HistSnapshot{}, fmt.Errorf("%v: %v", m.url, err)
+	}
+	return stats.HistSnapshot{Counts: h.Counts, Buckets: h.Buckets}, nil
+}
+
+// monitorProbe returns the name the target's latencies are reported under,
+// e.g., "/sched/latencies (api-1:8080)".
+func monitorProbe(rawURL string) string {
+	target := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		target = u.Host
+	}
+	return fmt.Sprintf("%v (%v)", schedProbe, target)
+}
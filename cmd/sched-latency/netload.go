@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// connsPerListener limits the connections to each listener so we don't run
+// out of ephemeral ports for a single destination address.
+const connsPerListener = 20000
+
+// idleConns holds loopback TCP connections open, with a reader blocked on
+// the accepted side of each so they're all waiting in the netpoller.
+type idleConns struct {
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     []net.Conn // client side of each connection
+	accepted  []net.Conn
+	stop      chan struct{}
+
+	// openErr is why fewer connections than requested were opened.
+	openErr error
+}
+
+// startIdleConns opens up to n loopback connections. Opening stops early if
+// we run out of file descriptors, so callers should check Count.
+func startIdleConns(n int) (*idleConns, error) {
+	raiseFileLimit(uint64(2*n + 1024))
+
+	c := &idleConns{stop: make(chan struct{})}
+	for opened := 0; opened < n; {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			if opened == 0 {
+				return nil, err
+			}
+			c.openErr = err
+			break
+		}
+		c.listeners = append(c.listeners, ln)
+		go c.accept(ln)
+
+		batch := n - opened
+		if batch > connsPerListener {
+			batch = connsPerListener
+		}
+		dialed, err := c.dial(ln.Addr().String(), batch)
+		opened += dialed
+		if err != nil {
+			if opened == 0 {
+				c.Close()
+				return nil, err
+			}
+			c.openErr = err
+			break
+		}
+	}
+	return c, nil
+}
+
+func (c *idleConns) dial(addr string, n int) (int, error) {
+	for i := 0; i < n; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return i, err
+		}
+
+		c.mu.Lock()
+		c.conns = append(c.conns, conn)
+		c.mu.Unlock()
+	}
+	return n, nil
+}
+
+func (c *idleConns) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		c.mu.Lock()
+		c.accepted = append(c.accepted, conn)
+		c.mu.Unlock()
+
+		go func() {
+			buf := make([]byte, 1)
+			for {
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Count returns the number of connections opened.
+func (c *idleConns) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.conns)
+}
+
+// Activate writes a byte to each of the first n connections every interval,
+// until the connections are closed.
+func (c *idleConns) Activate(n int, interval time.Duration) {
+	c.mu.Lock()
+	if n > len(c.conns) {
+		n = len(c.conns)
+	}
+	active := c.conns[:n]
+	c.mu.Unlock()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	b := []byte{0}
+	for {
+		select {
+		case <-t.C:
+		case <-c.stop:
+			return
+		}
+
+		for _, conn := range active {
+			conn.Write(b)
+		}
+	}
+}
+
+// Close closes all connections and listeners.
+func (c *idleConns) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	close(c.stop)
+	for _, ln := range c.listeners {
+		ln.Close()
+	}
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+	for _, conn := range c.accepted {
+		conn.Close()
+	}
+}
+
+// String describes the connections for the banner.
+func (c *idleConns) String() string {
+	return fmt.Sprintf("%d loopback TCP connections over %d listeners", c.Count(), len(c.listeners))
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+var errNiceUnsupported = errors.New("setting the nice value is not supported on this platform")
+
+func setNice(int) error {
+	return errNiceUnsupported
+}
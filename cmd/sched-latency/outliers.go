@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outlierThreshold is the delay above which samples are outliers.
+var outlierThreshold time.Duration
+
+// outlierLog is set when -log-outliers logs a snapshot of the runtime for
+// each outlier.
+var outlierLog *outlierLogger
+
+// logOutlier logs the sample to the execution trace and the outlier log if
+// it's an outlier. gcStart is the GC cycle count when the sample started.
+func logOutlier(ctx context.Context, probe string, sample time.Duration, at time.Time, gcStart uint64) {
+	if sample <= outlierThreshold {
+		return
+	}
+	if trace.IsEnabled() {
+		trace.Log(ctx, "outlier", probe+" "+sample.String())
+	}
+	if outlierLog != nil {
+		outlierLog.Log(probe, sample, at, gcStart)
+	}
+}
+
+// runtimeSnapshot holds the stats that are too costly to read for every
+// outlier, which are refreshed on each report interval instead.
+type runtimeSnapshot struct {
+	at        time.Time
+	heapInUse uint64
+	threads   int
+}
+
+// outlierLogger writes a line for each outlier describing the runtime's
+// state, to help tell GC, thread creation and external causes apart.
+type outlierLogger struct {
+	snapshot atomic.Pointer[runtimeSnapshot]
+
+	mu sync.Mutex
+}
+
+func newOutlierLogger() *outlierLogger {
+	l := &outlierLogger{}
+	l.Refresh()
+	return l
+}
+
+// Refresh updates the cached runtime stats.
+func (l *outlierLogger) Refresh() {
+	samples := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(samples)
+	l.snapshot.Store(&runtimeSnapshot{
+		at:        time.Now(),
+		heapInUse: samples[0].Value.Uint64(),
+		threads:   pprof.Lookup("threadcreate").Count(),
+	})
+}
+
+// Log writes the outlier's line to stderr.
+func (l *outlierLogger) Log(probe string, delay time.Duration, at time.Time, gcStart uint64) {
+	gc := gcCycles()
+	snap := l.snapshot.Load()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "outlier probe=%q delay=%v time=%v goroutines=%d gc_cycles=%d gc_during=%v heap_in_use=%v threads=%d stats_age=%v\n",
+		probe, delay, at.Format(time.RFC3339Nano), runtime.NumGoroutine(), gc, gc > gcStart,
+		formatBytes(snap.heapInUse), snap.threads, at.Sub(snap.at).Truncate(time.Millisecond))
+}
+
+// gcCycles returns the number of completed GC cycles.
+func gcCycles() uint64 {
+	samples := []metrics.Sample{{Name: "/gc/cycles/total:gc-cycles"}}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"sched-latency/probe"
+)
+
+// describeProbe returns the probe's name with its effective parameters,
+// e.g., "sleep (interval 1ms)".
+func (c Config) describeProbe(name string) string {
+	switch name {
+	case "sleep", "timer", "mach", "epoll", "futex", "http":
+		return fmt.Sprintf("%v (interval %v)", name, c.ProbeInterval(name))
+	case "sleep-spin":
+		return fmt.Sprintf("%v (interval %v, guard %v)", name, c.ProbeInterval(name), c.SpinGuard())
+	case "spin":
+		return fmt.Sprintf("%v (interval %v, counted as one of the workers)", name, c.ProbeInterval(name))
+	case "wakeup-burst":
+		return fmt.Sprintf("%v (interval %v, size %d)", name, c.ProbeInterval(name), c.BurstSize())
+	case "alloc":
+		return fmt.Sprintf("%v (interval %v, %d allocations of 4KiB)", name, c.ProbeInterval(name), c.AllocBurst())
+	}
+	return name
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// registerProbes registers the built-in probes that take samples, as
+// enabled by cfg.
+func registerProbes(cfg Config) {
+	if cfg.ProbeEnabled("sleep") {
+		probe.Register(threadProbe{&probe.Sleep{Interval: cfg.ProbeInterval("sleep")}, cfg, false})
+	}
+	if cfg.ProbeEnabled("sleep-spin") {
+		probe.Register(threadProbe{&probe.SleepSpin{Interval: cfg.ProbeInterval("sleep-spin"), Guard: cfg.SpinGuard()}, cfg, false})
+	}
+	if cfg.ProbeEnabled("timer") {
+		probe.Register(threadProbe{&probe.Timer{Interval: cfg.ProbeInterval("timer")}, cfg, false})
+	}
+	if cfg.ProbeEnabled("spin") {
+		probe.Register(threadProbe{&probe.SpinWait{Interval: cfg.ProbeInterval("spin")}, cfg, false})
+	}
+	if cfg.ProbeEnabled("mach") {
+		registerOSProbes(cfg)
+	}
+	if cfg.ProbeEnabled("epoll") {
+		registerEpollProbe(cfg)
+	}
+	if cfg.ProbeEnabled("futex") {
+		registerFutexProbe(cfg)
+	}
+	if cfg.ProbeEnabled("wakeup-burst") {
+		probe.Register(threadProbe{&probe.BurstWakeup{Interval: cfg.ProbeInterval("wakeup-burst"), Size: cfg.BurstSize()}, cfg, false})
+	}
+	if cfg.ProbeEnabled("alloc") {
+		probe.Register(threadProbe{&probe.AllocBurst{Interval: cfg.ProbeInterval("alloc"), Size: cfg.AllocBurst()}, cfg, false})
+	}
+	if cfg.ProbeEnabled("http") {
+		if p, err := probe.NewHTTPLoopback(cfg.ProbeInterval("http")); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: skipping the http loopback probe: %v\n", err)
+			runSummary.AddNote("skipped http loopback: " + err.Error())
+		} else {
+			probe.Register(threadProbe{p, cfg, false})
+		}
+	}
+	if cfg.ProbeRTPrio > 0 {
+		if cfg.ProbeEnabled("sleep") {
+			probe.Register(threadProbe{&probe.Sleep{Interval: cfg.ProbeInterval("sleep")}, cfg, true})
+		}
+		if cfg.ProbeEnabled("timer") {
+			probe.Register(threadProbe{&probe.Timer{Interval: cfg.ProbeInterval("timer")}, cfg, true})
+		}
+	}
+}
+
+// runProbe runs a registered probe, recording its samples in the interval
+// until ctx is done.
+func runProbe(ctx context.Context, interval *sampleInterval, p probe.Probe) {
+	defer interval.Flush()
+	p.Run(ctx, interval)
+	if n := probeFailures(p); n > 0 {
+		runSummary.AddNote(fmt.Sprintf("%v: %d attempts failed, which have no sample", p.Name(), n))
+	}
+}
+
+// failer is implemented by probes whose attempts at a sample can fail,
+// such as the http loopback probe's requests.
+type failer interface {
+	Failed() uint64
+}
+
+// probeFailures returns the number of the probe's attempts that failed.
+func probeFailures(p probe.Probe) uint64 {
+	if tp, ok := p.(threadProbe); ok {
+		p = tp.Probe
+	}
+	if f, ok := p.(failer); ok {
+		return f.Failed()
+	}
+	return 0
+}
+
+// probeTargets are the intervals waited for by the probes that have one,
+// such as the sleep probe, by probe name. They're set before the probes
+// start.
+var probeTargets = make(map[string]time.Duration)
+
+// probeTarget returns the interval the probe waits for, or 0 if it has none.
+func probeTarget(p probe.Probe) time.Duration {
+	if tp, ok := p.(threadProbe); ok {
+		p = tp.Probe
+	}
+	if t, ok := p.(probe.Targeted); ok {
+		return t.Target()
+	}
+	return 0
+}
+
+// spinner is implemented by probes that spin for part of each wait, such as
+// the sleep+spin probe, so the CPU cost can be reported.
+type spinner interface {
+	Spinning() (spun, waited time.Duration)
+}
+
+// probeSpinner returns the probe's spinner, or nil if it doesn't spin.
+func probeSpinner(p probe.Probe) spinner {
+	if tp, ok := p.(threadProbe); ok {
+		p = tp.Probe
+	}
+	s, _ := p.(spinner)
+	return s
+}
+
+// threadProbe runs a built-in probe on a thread set up by setupProbeThread,
+// with real-time probes reported under their own name.
+type threadProbe struct {
+	probe.Probe
+	cfg Config
+	rt  bool
+}
+
+func (p threadProbe) Name() string {
+	if p.rt {
+		return p.Probe.Name() + " (rt)"
+	}
+	return p.Probe.Name()
+}
+
+func (p threadProbe) Run(ctx context.Context, r probe.Recorder) {
+	setupProbeThread(p.cfg, p.Name(), p.rt)
+	p.Probe.Run(ctx, r)
+}
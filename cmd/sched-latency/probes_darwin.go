@@ -0,0 +1,11 @@
+//go:build darwin && cgo && machwait
+
+package main
+
+import "sched-latency/probe"
+
+// registerOSProbes registers the mach_wait_until probe, as a reference for
+// how much of the timer probes' delay is from XNU rather than Go.
+func registerOSProbes(cfg Config) {
+	probe.Register(threadProbe{&probe.MachWait{Interval: cfg.ProbeInterval("mach")}, cfg, false})
+}
@@ -0,0 +1,8 @@
+//go:build !darwin || !cgo || !machwait
+
+package main
+
+// registerOSProbes registers nothing, as the mach_wait_until probe is only
+// built on macOS with cgo and the machwait build tag. The Linux reference
+// probes are registered by registerEpollProbe and registerFutexProbe.
+func registerOSProbes(Config) {}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sched-latency/report"
+	"sched-latency/stats"
+)
+
+// promMetrics is set when -prometheus serves the probes' results.
+var promMetrics *promExporter
+
+// promExporter serves the probes' results as Prometheus metrics in the text
+// exposition format. Probes reading a runtime/metrics histogram, such as
+// /sched/latencies, are exported as a classic histogram of every interval
+// since the run started, so histogram_quantile can aggregate them across
+// instances. The others are exported as gauges of their last interval's
+// percentiles, which can't be aggregated.
+//
+// Native histograms need the protobuf exposition format, so only classic
+// ones are served.
+type promExporter struct {
+	labels string
+
+	// The probes are written in the order they first reported.
+	mu         sync.Mutex
+	hists      map[string]stats.HistSnapshot
+	histOrder  []string
+	gauges     map[string]report.Result
+	gaugeOrder []string
+}
+
+// promHistogram and promGauge are the names of the exported metrics.
+const (
+	promHistogram = "sched_latency_seconds"
+	promGauge     = "sched_latency_percentile_seconds"
+)
+
+// newPromExporter returns the exporter, whose metrics are labeled with the
+// run's tags and -label, if any.
+func newPromExporter(cfg Config) *promExporter {
+	var labels []string
+	for _, k := range runTags.Keys() {
+		labels = append(labels, promLabel(k, runTags[k]))
+	}
+	if cfg.Label != "" {
+		labels = append(labels, promLabel("label", cfg.Label))
+	}
+	return &promExporter{
+		labels: strings.Join(labels, ","),
+		hists:  make(map[string]stats.HistSnapshot),
+		gauges: make(map[string]report.Result),
+	}
+}
+
+// servePrometheus serves the exporter's metrics on addr at /metrics.
+func servePrometheus(addr string, e *promExporter) error {
+	mux, err := serveMux(addr)
+	if err != nil {
+		return err
+	}
+	mux.Handle("/metrics", e)
+	return nil
+}
+
+// AddHistogram adds a histogram probe's interval to its cumulative
+// histogram. If the buckets changed, the histogram starts again from the
+// interval, which Prometheus treats as a counter reset.
+func (e *promExporter) AddHistogram(probe string, diff stats.HistSnapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	last, ok := e.hists[probe]
+	if !ok {
+		e.histOrder = append(e.histOrder, probe)
+	}
+	merged, err := last.Merge(diff)
+	if err != nil {
+		merged = diff
+	}
+	e.hists[probe] = merged
+}
+
+// SetPercentiles sets a scalar probe's gauges to the interval's
+// percentiles.
+func (e *promExporter) SetPercentiles(r report.Result) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.gauges[r.Probe]; !ok {
+		e.gaugeOrder = append(e.gaugeOrder, r.Probe)
+	}
+	e.gauges[r.Probe] = r
+}
+
+func (e *promExporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v Latencies of the probes reading a runtime histogram, such as /sched/latencies, since the run started. The sum is estimated from the bucket midpoints.\n", promHistogram)
+	fmt.Fprintf(w, "# TYPE %v histogram\n", promHistogram)
+	for _, probe := range e.histOrder {
+		e.writeHistogram(w, probe, e.hists[probe])
+	}
+
+	fmt.Fprintf(w, "# HELP %v Percentiles of the other probes' last report interval.\n", promGauge)
+	fmt.Fprintf(w, "# TYPE %v gauge\n", promGauge)
+	for _, probe := range e.gaugeOrder {
+		r := e.gauges[probe]
+		for i, v := range r.Values {
+			fmt.Fprintf(w, "%v{%v} %v\n", promGauge, e.join(promLabel("probe", probe), promLabel("percentile", stats.PercentileName(r.Percentiles[i]))), promFloat(v.Seconds()))
+		}
+	}
+}
+
+// writeHistogram writes the histogram's buckets, merged down to the
+// promBuckets bounds so there are a few dozen rather than the runtime's
+// hundreds, then its sum and count.
+func (e *promExporter) writeHistogram(w io.Writer, probe string, h stats.HistSnapshot) {
+	bounds := promBuckets(h.Buckets)
+	var cumulative uint64
+	var sum float64
+	next := 0
+	for i, c := range h.Counts {
+		lower, upper := h.Buckets[i], h.Buckets[i+1]
+		// Buckets are written once every count up to their bound is in.
+		for next < len(bounds) && bounds[next] <= lower {
+			fmt.Fprintf(w, "%v_bucket{%v} %d\n", promHistogram, e.join(promLabel("probe", probe), promLabel("le", promFloat(bounds[next]))), cumulative)
+			next++
+		}
+		cumulative += c
+		sum += float64(c) * bucketMid(lower, upper)
+	}
+	for ; next < len(bounds); next++ {
+		fmt.Fprintf(w, "%v_bucket{%v} %d\n", promHistogram, e.join(promLabel("probe", probe), promLabel("le", promFloat(bounds[next]))), cumulative)
+	}
+	fmt.Fprintf(w, "%v_bucket{%v} %d\n", promHistogram, e.join(promLabel("probe", probe), promLabel("le", "+Inf")), cumulative)
+	fmt.Fprintf(w, "%v_sum{%v} %v\n", promHistogram, e.join(promLabel("probe", probe)), promFloat(sum))
+	fmt.Fprintf(w, "%v_count{%v} %d\n", promHistogram, e.join(promLabel("probe", probe)), cumulative)
+}
+
+// promBuckets returns the runtime bucket bounds to export as classic
+// buckets: the first positive finite bound, then each one at least twice
+// the last. The runtime's time histograms have power-of-two bounds in
+// nanoseconds, so this keeps those, and every instance with the same
+// runtime exports the same buckets.
+func promBuckets(bounds []float64) []float64 {
+	var kept []float64
+	for _, b := range bounds {
+		if b <= 0 || math.IsInf(b, 0) {
+			continue
+		}
+		if len(kept) == 0 || b >= 2*kept[len(kept)-1] {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// bucketMid returns the middle of a bucket, or its finite bound if the
+// other is infinite.
+func bucketMid(lower, upper float64) float64 {
+	switch {
+	case math.IsInf(upper, 1):
+		return math.Max(lower, 0)
+	case math.IsInf(lower, -1):
+		return math.Max(upper, 0)
+	}
+	return (lower + upper) / 2
+}
+
+// join returns the given labels with the exporter's own.
+func (e *promExporter) join(labels ...string) string {
+	if e.labels != "" {
+		labels = append(labels, e.labels)
+	}
+	return strings.Join(labels, ",")
+}
+
+// promLabel formats a label pair, escaping the value as the exposition
+// format requires.
+func promLabel(name, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return name + `="` + value + `"`
+}
+
+func promFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
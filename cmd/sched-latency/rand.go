@@ -0,0 +1,16 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// Rand returns a source of randomness for a randomized component, derived
+// from -seed and the component's name. Each goroutine that needs randomness
+// should get its own, so runs with the same seed are reproducible and the
+// components don't contend on the global source.
+func (c Config) Rand(component string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(component))
+	return rand.New(rand.NewSource(c.Seed ^ int64(h.Sum64())))
+}
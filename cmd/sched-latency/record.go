@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sched-latency/report"
+)
+
+// Recordings start with recordMagic, then a uvarint length and a JSON
+// recordingHeader, then a stream of records. Each record is a type byte
+// followed by uvarint and varint fields:
+//
+//	recordProbe:  probe id, name length, name
+//	recordSample: probe id, nanoseconds since the start, delay in nanoseconds
+const (
+	recordMagic   = "SCHEDLAT"
+	recordVersion = 1
+
+	recordProbe  byte = 1
+	recordSample byte = 2
+)
+
+// recordingHeader describes the run a recording is from.
+type recordingHeader struct {
+	Version    int               `json:"version"`
+	Label      string            `json:"label,omitempty"`
+	Tags       report.Tags       `json:"tags,omitempty"`
+	Start      time.Time         `json:"start"`
+	GoVersion  string            `json:"go_version"`
+	Build      buildInfo         `json:"build"`
+	GOOS       string            `json:"goos"`
+	GOARCH     string            `json:"goarch"`
+	NumCPU     int               `json:"num_cpu"`
+	GOMAXPROCS int               `json:"gomaxprocs"`
+	Flags      map[string]string `json:"flags"`
+	Seed       int64             `json:"seed"`
+}
+
+// recorder is set when -record is streaming samples to a file.
+var recorder *sampleRecorder
+
+// recordBuffer is how many records can be queued for the writer before
+// samples are dropped.
+const recordBuffer = 64 << 10
+
+// recordFlushInterval is how often buffered records are written out.
+const recordFlushInterval = time.Second
+
+// sampleRecorder streams samples to a file from a dedicated goroutine, so a
+// slow disk drops samples rather than delaying the probes.
+type sampleRecorder struct {
+	f     *os.File
+	start time.Time
+
+	records chan []byte
+	done    chan error
+
+	mu     sync.Mutex
+	probes map[string]uint64
+
+	written atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func newSampleRecorder(path, label string, start time.Time, seed int64) (*sampleRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := json.Marshal(recordingHeader{
+		Version:    recordVersion,
+		Label:      label,
+		Tags:       runTags,
+		Start:      start,
+		GoVersion:  runtime.Version(),
+		Build:      build,
+		GOOS:       runtime.GOOS,
+		GOARCH:     runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Flags:      effectiveFlags(runFlags),
+		Seed:       seed,
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	b := []byte(recordMagic)
+	b = binary.AppendUvarint(b, uint64(len(header)))
+	b = append(b, header...)
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r := &sampleRecorder{
+		f:       f,
+		start:   start,
+		records: make(chan []byte, recordBuffer),
+		done:    make(chan error, 1),
+		probes:  make(map[string]uint64),
+	}
+	go r.writeLoop()
+	return r, nil
+}
+
+func (r *sampleRecorder) writeLoop() {
+	w := bufio.NewWriterSize(r.f, 64<<10)
+	flush := time.NewTicker(recordFlushInterval)
+	defer flush.Stop()
+
+	var err error
+	for {
+		select {
+		case rec, ok := <-r.records:
+			if !ok {
+				if flushErr := w.Flush(); err == nil {
+					err = flushErr
+				}
+				if closeErr := r.f.Close(); err == nil {
+					err = closeErr
+				}
+				r.done <- err
+				return
+			}
+			if _, writeErr := w.Write(rec); err == nil {
+				err = writeErr
+			}
+			r.written.Add(1)
+		case <-flush.C:
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+		}
+	}
+}
+
+// Register assigns the probe an id, recording its name. It blocks rather
+// than drop the name, since samples are meaningless without it.
+func (r *sampleRecorder) Register(probe string) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id, ok := r.probes[probe]; ok {
+		return id
+	}
+	id := uint64(len(r.probes))
+	r.probes[probe] = id
+
+	rec := []byte{recordProbe}
+	rec = binary.AppendUvarint(rec, id)
+	rec = binary.AppendUvarint(rec, uint64(len(probe)))
+	rec = append(rec, probe...)
+	r.records <- rec
+	return id
+}
+
+// Record queues a sample for the writer, dropping it if the writer is
+// behind.
+func (r *sampleRecorder) Record(id uint64, at time.Time, delay time.Duration) {
+	rec := make([]byte, 1, 1+3*binary.MaxVarintLen64)
+	rec[0] = recordSample
+	rec = binary.AppendUvarint(rec, id)
+	rec = binary.AppendVarint(rec, int64(at.Sub(r.start)))
+	rec = binary.AppendVarint(rec, int64(delay))
+
+	select {
+	case r.records <- rec:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Close writes out every queued record and closes the file. The probes must
+// have stopped.
+func (r *sampleRecorder) Close() error {
+	close(r.records)
+	return <-r.done
+}
+
+func (r *sampleRecorder) String() string {
+	return fmt.Sprintf("%d records written, %d samples dropped", r.written.Load(), r.dropped.Load())
+}
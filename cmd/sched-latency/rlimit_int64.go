@@ -0,0 +1,10 @@
+//go:build dragonfly || freebsd
+
+package main
+
+import "syscall"
+
+// setRlimitCur sets the soft limit, which is signed on this platform.
+func setRlimitCur(lim *syscall.Rlimit, n uint64) {
+	lim.Cur = int64(n)
+}
@@ -0,0 +1,10 @@
+//go:build linux || darwin || netbsd || openbsd
+
+package main
+
+import "syscall"
+
+// setRlimitCur sets the soft limit.
+func setRlimitCur(lim *syscall.Rlimit, n uint64) {
+	lim.Cur = n
+}
@@ -0,0 +1,31 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"log"
+	"syscall"
+)
+
+// raiseFileLimit raises the soft limit on open files to at least n, if the
+// hard limit allows it.
+func raiseFileLimit(n uint64) {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		log.Printf("failed to get open file limit: %v", err)
+		return
+	}
+	if uint64(lim.Cur) >= n {
+		return
+	}
+
+	want := n
+	if uint64(lim.Max) < want {
+		want = uint64(lim.Max)
+		log.Printf("open file limit %d is lower than the %d needed", lim.Max, n)
+	}
+	setRlimitCur(&lim, want)
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		log.Printf("failed to raise open file limit to %d: %v", want, err)
+	}
+}
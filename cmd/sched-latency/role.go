@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// roleDialTimeout is how long a -role=load process keeps trying to connect
+// to the -role=measure process, which may be started after it.
+const roleDialTimeout = 30 * time.Second
+
+// role is the link to the other process of a -role pair, if this is one.
+var role *roleLink
+
+// roleMessage is a line of JSON sent between the processes of a -role
+// pair. Kind is one of:
+//
+//   - "hello", from the load process once it connects, with its config;
+//   - "start", from the measure process, to start the load;
+//   - "phase", from the load process, as it starts a phase of its load;
+//   - "ops", from the load process, with its workers' recent ops/sec.
+type roleMessage struct {
+	Kind   string            `json:"kind"`
+	Pid    int               `json:"pid,omitempty"`
+	Build  string            `json:"build,omitempty"`
+	Flags  map[string]string `json:"flags,omitempty"`
+	Config string            `json:"config,omitempty"`
+	Phase  string            `json:"phase,omitempty"`
+	Ops    string            `json:"ops,omitempty"`
+}
+
+// roleLink is a connection between a -role=measure process, which runs the
+// probes, and a -role=load process, which runs the workers. The load
+// process connects and sends its config, and the measure process tells it
+// when to start, so both start at the same moment. From then on, the load
+// process reports its phases, which the measure process starts too, and
+// its workers' ops/sec, which annotate the measure process's reports.
+// Either process stops once the other does.
+type roleLink struct {
+	conn net.Conn
+	dec  *json.Decoder
+	done chan struct{}
+
+	// hello is the load process's config, on the measure side.
+	hello roleMessage
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	ops string
+}
+
+func newRoleLink(conn net.Conn) *roleLink {
+	return &roleLink{conn: conn, dec: json.NewDecoder(conn), enc: json.NewEncoder(conn), done: make(chan struct{})}
+}
+
+// listenRole waits on the socket for the load process to connect, and
+// starts it.
+func listenRole(path string) (*roleLink, error) {
+	// A socket left by an earlier run would make the listen fail.
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l := newRoleLink(conn)
+	if err := l.dec.Decode(&l.hello); err != nil || l.hello.Kind != "hello" {
+		conn.Close()
+		return nil, fmt.Errorf("no hello from the load process: %v", err)
+	}
+	if err := l.send(roleMessage{Kind: "start"}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go l.receive()
+	return l, nil
+}
+
+// dialRole connects to the measure process's socket, retrying until it's
+// listening, and waits for it to start the load.
+func dialRole(path string, cfg Config) (*roleLink, error) {
+	deadline := time.Now().Add(roleDialTimeout)
+	var conn net.Conn
+	for {
+		var err error
+		if conn, err = net.Dial("unix", path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("no -role=measure process listening after %v: %v", roleDialTimeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	l := newRoleLink(conn)
+	hello := roleMessage{Kind: "hello", Pid: os.Getpid(), Build: build.String(), Flags: effectiveFlags(runFlags), Config: fmt.Sprintf("%+v", cfg.Config)}
+	if err := l.send(hello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var start roleMessage
+	if err := l.dec.Decode(&start); err != nil || start.Kind != "start" {
+		conn.Close()
+		return nil, fmt.Errorf("the measure process didn't start the load: %v", err)
+	}
+	go l.receive()
+	return l, nil
+}
+
+// receive handles the other process's messages until it disconnects.
+func (l *roleLink) receive() {
+	defer close(l.done)
+	for {
+		var m roleMessage
+		if err := l.dec.Decode(&m); err != nil {
+			return
+		}
+		switch m.Kind {
+		case "phase":
+			applyPhase(m.Phase)
+		case "ops":
+			l.mu.Lock()
+			l.ops = m.Ops
+			l.mu.Unlock()
+		}
+	}
+}
+
+func (l *roleLink) send(m roleMessage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(m)
+}
+
+// SendPhase tells the measure process the load started a phase.
+func (l *roleLink) SendPhase(name string) {
+	l.send(roleMessage{Kind: "phase", Phase: name})
+}
+
+// SendOps tells the measure process the workers' recent ops/sec, e.g.,
+// "json 1234/s".
+func (l *roleLink) SendOps(ops string) {
+	l.send(roleMessage{Kind: "ops", Ops: ops})
+}
+
+// Ops returns the load process's most recent ops/sec, or "" if it hasn't
+// sent any.
+func (l *roleLink) Ops() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ops
+}
+
+// roleDone returns a channel that's closed once the other -role process
+// disconnects, or nil if this isn't one.
+func roleDone() <-chan struct{} {
+	if role == nil {
+		return nil
+	}
+	return role.done
+}
+
+// Close disconnects from the other process, which stops it.
+func (l *roleLink) Close() {
+	l.conn.Close()
+}
+
+// roleLoadJSON is the load process's config, in the measure process's
+// summary.
+type roleLoadJSON struct {
+	Pid    int               `json:"pid"`
+	Build  string            `json:"build"`
+	Flags  map[string]string `json:"flags"`
+	Config string            `json:"config"`
+}
+
+// LoadJSON returns the load process's config for the summary.
+func (l *roleLink) LoadJSON() *roleLoadJSON {
+	return &roleLoadJSON{Pid: l.hello.Pid, Build: l.hello.Build, Flags: l.hello.Flags, Config: l.hello.Config}
+}
+
+// checkRole returns an error if the config runs load in a -role=measure
+// process, or probes in a -role=load one.
+func checkRole(cfg Config, explicitProbes bool) error {
+	switch cfg.Role {
+	case "measure":
+		if cfg.Workers > 0 || cfg.Ramp != nil || cfg.Experiment || cfg.LoadAfter > 0 || cfg.BurstWorkers > 0 || cfg.LoadCmd != "" {
+			return errors.New("-role=measure runs no load; set -workers, -ramp, -experiment, -load-after, -burst-workers and -load-cmd on the -role=load process")
+		}
+	case "load":
+		if explicitProbes {
+			return errors.New("-role=load runs no probes; set -probes on the -role=measure process")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	schedFIFO = 1
+	schedRR   = 2
+)
+
+var errRTUnsupported error
+
+// setThreadRT switches the calling OS thread to a real-time scheduling policy
+// ("fifo" or "rr") with the given priority.
+func setThreadRT(policy string, priority int) error {
+	p := schedFIFO
+	if policy == "rr" {
+		p = schedRR
+	}
+
+	param := struct{ priority int32 }{int32(priority)}
+	// A pid of 0 refers to the calling thread.
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, 0, uintptr(p), uintptr(unsafe.Pointer(&param)))
+	if errno != 0 {
+		if errno == syscall.EPERM {
+			return fmt.Errorf("%v (real-time scheduling requires CAP_SYS_NICE or root)", errno)
+		}
+		return errno
+	}
+	return nil
+}
+
+// checkRT verifies that a thread can be switched to the real-time policy.
+// The thread used for the check is discarded afterwards.
+func checkRT(policy string, priority int) error {
+	errC := make(chan error)
+	go func() {
+		// Exit without unlocking, so the runtime terminates the thread
+		// rather than reusing it with a real-time policy.
+		runtime.LockOSThread()
+		errC <- setThreadRT(policy, priority)
+	}()
+	return <-errC
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errRTUnsupported = errors.New("real-time scheduling is only supported on Linux")
+
+func setThreadRT(string, int) error {
+	return errRTUnsupported
+}
+
+func checkRT(string, int) error {
+	return errRTUnsupported
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+	"time"
+)
+
+// runCapture is a trace or profile of the whole run after the warmup, from
+// -trace or -cpuprofile.
+type runCapture struct {
+	f     *os.File
+	timer *time.Timer
+	begin func(w io.Writer) error
+	end   func()
+
+	mu      sync.Mutex
+	started bool
+	stopped bool
+	err     error
+}
+
+// startRunTrace creates the -trace file, and starts an execution trace to
+// it once the warmup ends.
+func startRunTrace(path string) (*runCapture, error) {
+	return startRunCapture(path, trace.Start, trace.Stop)
+}
+
+// startRunCPUProfile creates the -cpuprofile file, and starts a CPU profile
+// to it once the warmup ends.
+func startRunCPUProfile(path string) (*runCapture, error) {
+	return startRunCapture(path, pprof.StartCPUProfile, pprof.StopCPUProfile)
+}
+
+func startRunCapture(path string, begin func(w io.Writer) error, end func()) (*runCapture, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &runCapture{f: f, begin: begin, end: end}
+	c.timer = time.AfterFunc(time.Until(warmupEnd), c.start)
+	return c, nil
+}
+
+func (c *runCapture) start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+	// Capturing fails if one is already running, e.g., from -pprof.
+	if c.err = c.begin(c.f); c.err == nil {
+		c.started = true
+	}
+}
+
+// Stop stops the capture and closes the file, returning any error from
+// starting the capture or writing it.
+func (c *runCapture) Stop() error {
+	c.timer.Stop()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopped = true
+	if c.started {
+		c.end()
+	}
+	if err := c.f.Close(); c.err == nil {
+		c.err = err
+	}
+	return c.err
+}
+
+// startContentionProfiles sets the block and mutex profile rates for
+// -blockprofile and -mutexprofile, for the whole run including the warmup.
+func startContentionProfiles(cfg Config) {
+	if cfg.BlockProfile != "" {
+		runtime.SetBlockProfileRate(cfg.BlockProfileRate)
+	}
+	if cfg.MutexProfile != "" {
+		runtime.SetMutexProfileFraction(cfg.MutexProfileFraction)
+	}
+}
+
+// writeProfile writes the named runtime/pprof profile to path.
+func writeProfile(name, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
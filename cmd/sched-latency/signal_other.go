@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+// reportOnSignal is a no-op, since there's no SIGUSR1 or SIGUSR2 on this
+// platform.
+func reportOnSignal(cfg Config) {}
@@ -0,0 +1,28 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reportOnSignal reports without stopping the run: SIGUSR1 asks the probes
+// for an out-of-cycle report of their current interval, and SIGUSR2 prints
+// the summary so far. SIGQUIT is left alone, so it still dumps stacks.
+func reportOnSignal(cfg Config) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			switch sig {
+			case syscall.SIGUSR1:
+				requestReport()
+			case syscall.SIGUSR2:
+				out.Do(func(w io.Writer) { runSummary.Print(w, cfg, "Summary so far") })
+			}
+		}
+	}()
+}
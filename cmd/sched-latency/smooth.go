@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"sched-latency/report"
+)
+
+// smoothing is set when -smooth reports moving averages of the percentiles.
+var smoothing *smoother
+
+// smoother keeps an exponentially weighted moving average of each probe's
+// interval percentiles. The averages restart at each phase boundary, such
+// as the end of the warmup or a ramp step, so they don't mix phases.
+type smoother struct {
+	alpha float64
+
+	mu     sync.Mutex
+	probes map[string]*movingAverage
+}
+
+// movingAverage is a probe's moving averages, and the phase they're for.
+type movingAverage struct {
+	phase  *phaseSummary
+	warmup bool
+	values []float64
+}
+
+// newSmoother returns a smoother averaging over about n intervals.
+func newSmoother(n int) *smoother {
+	return &smoother{alpha: 2 / float64(n+1), probes: make(map[string]*movingAverage)}
+}
+
+// Smooth adds the interval's percentiles to its probe's moving averages,
+// and returns the averages.
+func (s *smoother) Smooth(r report.Result) []time.Duration {
+	phase := runSummary.Phase()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	avg, ok := s.probes[r.Probe]
+	if !ok || avg.phase != phase || avg.warmup != r.Warmup || len(avg.values) != len(r.Values) {
+		avg = &movingAverage{phase: phase, warmup: r.Warmup, values: make([]float64, len(r.Values))}
+		for i, v := range r.Values {
+			avg.values[i] = float64(v)
+		}
+		s.probes[r.Probe] = avg
+	} else {
+		for i, v := range r.Values {
+			avg.values[i] += s.alpha * (float64(v) - avg.values[i])
+		}
+	}
+
+	smoothed := make([]time.Duration, len(avg.values))
+	for i, v := range avg.values {
+		smoothed[i] = time.Duration(v)
+	}
+	return smoothed
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/trace"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// spikeTraces is set when -trace-on-spike captures execution traces.
+	spikeTraces *spikeTrigger
+
+	// spikeDumps is set when -dump-on-spike writes goroutine dumps.
+	spikeDumps *spikeTrigger
+)
+
+// spike is a sample above a spike trigger's threshold.
+type spike struct {
+	probe string
+	delay time.Duration
+	at    time.Time
+}
+
+// spikeCapture is a file written in response to a spike.
+type spikeCapture struct {
+	spike
+	path string
+}
+
+// spikeTrigger runs an action that captures a file when a probe's sample is
+// above the threshold, at most once per cooldown. The action runs on the
+// trigger's own goroutine, so the probes never wait for it.
+type spikeTrigger struct {
+	kind      string
+	dir       string
+	label     string
+	threshold time.Duration
+	cooldown  time.Duration
+
+	// capture writes the file for the spike to path. It should return early
+	// once stop is closed.
+	capture func(s spike, path string, stop <-chan struct{}) error
+
+	spikes chan spike
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu       sync.Mutex
+	captures []spikeCapture
+}
+
+func newSpikeTrigger(kind, dir, label string, threshold, cooldown time.Duration, capture func(spike, string, <-chan struct{}) error) (*spikeTrigger, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	t := &spikeTrigger{
+		kind:      kind,
+		dir:       dir,
+		label:     label,
+		threshold: threshold,
+		cooldown:  cooldown,
+		capture:   capture,
+		spikes:    make(chan spike, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go t.run()
+	return t, nil
+}
+
+func (t *spikeTrigger) run() {
+	defer close(t.done)
+
+	var next time.Time
+	for {
+		var s spike
+		select {
+		case s = <-t.spikes:
+		case <-t.stop:
+			return
+		}
+		// Spikes that queued up during the last capture are skipped along
+		// with any others in the cooldown.
+		if s.at.Before(next) {
+			continue
+		}
+		next = time.Now().Add(t.cooldown)
+
+		// The capture is listed as soon as it starts, so the report for the
+		// spike's interval can name the file.
+		path := spikeFile(t.dir, t.kind, t.label, s)
+		t.mu.Lock()
+		t.captures = append(t.captures, spikeCapture{s, path})
+		t.mu.Unlock()
+
+		if err := t.capture(s, path, t.stop); err != nil {
+			log.Printf("failed to capture %v for %v spike of %v: %v", t.kind, s.probe, s.delay, err)
+			t.mu.Lock()
+			t.captures = t.captures[:len(t.captures)-1]
+			t.mu.Unlock()
+		}
+	}
+}
+
+// Observe triggers a capture if the sample is above the threshold. It never
+// blocks, dropping the spike if a capture is already pending.
+func (t *spikeTrigger) Observe(probe string, delay time.Duration, at time.Time) {
+	if delay <= t.threshold {
+		return
+	}
+	select {
+	case t.spikes <- spike{probe, delay, at}:
+	default:
+	}
+}
+
+// Since returns the files captured for the probe's spikes since t.
+func (t *spikeTrigger) Since(probe string, since time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var paths []string
+	for _, c := range t.captures {
+		if c.probe == probe && !c.at.Before(since) {
+			paths = append(paths, c.path)
+		}
+	}
+	return paths
+}
+
+// Stop ends any capture in progress, waiting for its file to be written.
+func (t *spikeTrigger) Stop() {
+	close(t.stop)
+	<-t.done
+}
+
+// Captures returns the number of files captured.
+func (t *spikeTrigger) Captures() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.captures)
+}
+
+// observeSpike passes a probe's sample to the spike triggers.
+func observeSpike(probe string, delay time.Duration, at time.Time) {
+	if spikeTraces != nil {
+		spikeTraces.Observe(probe, delay, at)
+	}
+	if spikeDumps != nil {
+		spikeDumps.Observe(probe, delay, at)
+	}
+}
+
+// spikeFile returns a path in dir for a file captured for the spike, named
+// by the run's label, and the spike's time and probe.
+func spikeFile(dir, kind, label string, s spike) string {
+	probe := sanitizeFileName(strings.Trim(s.probe, "/"))
+	name := fmt.Sprintf("%v%v-%v-%v.out", kind, fileLabel(label), s.at.Format("20060102-150405.000"), probe)
+	return filepath.Join(dir, name)
+}
+
+// newSpikeTracer returns a trigger that captures an execution trace of the
+// given duration after each spike.
+func newSpikeTracer(cfg Config) (*spikeTrigger, error) {
+	return newSpikeTrigger("trace", cfg.TraceDir, cfg.Label, cfg.TraceOnSpike, cfg.TraceCooldown, func(s spike, path string, stop <-chan struct{}) error {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		// Tracing fails if a trace is already running, e.g., from -pprof.
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			os.Remove(path)
+			return err
+		}
+		select {
+		case <-time.After(cfg.TraceDuration):
+		case <-stop:
+		}
+		trace.Stop()
+		return f.Close()
+	})
+}
+
+// newSpikeDumper returns a trigger that writes a stack dump of every
+// goroutine after each spike, with a header describing the spike and the
+// heap.
+func newSpikeDumper(cfg Config) (*spikeTrigger, error) {
+	return newSpikeTrigger("goroutines", cfg.DumpDir, cfg.Label, cfg.DumpOnSpike, cfg.DumpCooldown, func(s spike, path string, _ <-chan struct{}) error {
+		buf := make([]byte, 1<<20)
+		for {
+			n := runtime.Stack(buf, true)
+			if n < len(buf) {
+				buf = buf[:n]
+				break
+			}
+			buf = make([]byte, 2*len(buf))
+		}
+
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		var lastGC time.Time
+		if ms.LastGC > 0 {
+			lastGC = time.Unix(0, int64(ms.LastGC))
+		}
+
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if cfg.Label != "" {
+			fmt.Fprintf(f, "label: %v\n", cfg.Label)
+		}
+		fmt.Fprintf(f, "probe: %v\n", s.probe)
+		fmt.Fprintf(f, "delay: %v\n", s.delay)
+		fmt.Fprintf(f, "at: %v\n", s.at.Format(time.RFC3339Nano))
+		fmt.Fprintf(f, "dumped: %v later\n", time.Since(s.at))
+		fmt.Fprintf(f, "goroutines: %d\n", runtime.NumGoroutine())
+		fmt.Fprintf(f, "heap: %v in use, %v goal, %v objects\n", formatBytes(ms.HeapInuse), formatBytes(ms.NextGC), ms.HeapObjects)
+		fmt.Fprintf(f, "gc: %d cycles, last at %v, last pause %v, total pause %v\n",
+			ms.NumGC, lastGC.Format(time.RFC3339Nano), time.Duration(ms.PauseNs[(ms.NumGC+255)%256]), time.Duration(ms.PauseTotalNs))
+		fmt.Fprintln(f)
+		if _, err := f.Write(buf); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
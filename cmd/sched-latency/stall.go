@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sched-latency/config"
+)
+
+// stalls is set when whole-process stalls are detected.
+var stalls *stallWatchdog
+
+// stall is a gap in the heartbeats, during which the whole process was
+// stopped.
+type stall struct {
+	start time.Time
+	gap   time.Duration
+}
+
+// end returns when the stall ended.
+func (s stall) end() time.Time {
+	return s.start.Add(s.gap)
+}
+
+// stallWatchdog detects stalls of the whole process, e.g., from a cgroup
+// freezer, a VM pause or ptrace, which every probe would otherwise show as
+// one giant sample. A heartbeat goroutine stores its time on every tick, and
+// the watchdog reports a stall once it sees a gap in the heartbeats longer
+// than the threshold. Gaps are measured on the monotonic clock, so wall
+// clock steps aren't mistaken for stalls.
+type stallWatchdog struct {
+	threshold time.Duration
+	history   time.Duration
+	start     time.Time
+
+	// beat is the time of the last heartbeat, as nanoseconds since start.
+	beat atomic.Int64
+
+	mu sync.Mutex
+	// last is the time, as nanoseconds since start, up to which the
+	// process is known not to have stalled.
+	last    int64
+	recent  []stall
+	count   int
+	total   time.Duration
+	longest stall
+}
+
+func newStallWatchdog(cfg Config) *stallWatchdog {
+	return &stallWatchdog{
+		threshold: cfg.StallThreshold,
+		// Keep enough history to cover a report interval, even if the probe
+		// reporting it was delayed.
+		history: 10 * cfg.ReportInterval,
+		start:   time.Now(),
+	}
+}
+
+// Run runs the heartbeat and watches for gaps in it until ctx is done.
+func (w *stallWatchdog) Run(ctx context.Context) {
+	go func() {
+		t := time.NewTicker(config.HeartbeatInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				w.beat.Store(int64(time.Since(w.start)))
+			}
+		}
+	}()
+
+	t := time.NewTicker(config.HeartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			w.check()
+		}
+	}
+}
+
+// check records a stall if the heartbeats have a gap longer than the
+// threshold. The heartbeat may not have run yet when the process resumes,
+// so the gap up to now also counts.
+func (w *stallWatchdog) check() {
+	now := int64(time.Since(w.start))
+	beat := w.beat.Load()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if beat > w.last {
+		if gap := time.Duration(beat - w.last); gap > w.threshold {
+			w.record(gap)
+		}
+		w.last = beat
+	}
+	if gap := time.Duration(now - w.last); gap > w.threshold {
+		w.record(gap)
+		w.last = now
+	}
+}
+
+// record records a stall of gap starting at w.last. The lock must be
+// held.
+func (w *stallWatchdog) record(gap time.Duration) {
+	s := stall{start: w.start.Add(time.Duration(w.last)), gap: gap}
+	fmt.Fprintf(os.Stderr, "WARNING: process stall: no heartbeat for %v from %v\n",
+		gap.Truncate(time.Millisecond), s.start.Format(time.RFC3339Nano))
+
+	w.count++
+	w.total += gap
+	if gap > w.longest.gap {
+		w.longest = s
+	}
+	for len(w.recent) > 0 && time.Since(w.recent[0].end()) > w.history {
+		w.recent = w.recent[1:]
+	}
+	w.recent = append(w.recent, s)
+}
+
+// During returns how long the process stalled between start and end. It
+// checks for a stall that just ended first, so an interval reported as the
+// process resumes includes it.
+func (w *stallWatchdog) During(start, end time.Time) time.Duration {
+	w.check()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var d time.Duration
+	for _, s := range w.recent {
+		from, to := s.start, s.end()
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if to.After(from) {
+			d += to.Sub(from)
+		}
+	}
+	return d
+}
+
+// stallsJSON is the summary of the run's stalls.
+type stallsJSON struct {
+	Count     int       `json:"count"`
+	TotalNs   int64     `json:"total_ns"`
+	LongestNs int64     `json:"longest_ns"`
+	LongestAt time.Time `json:"longest_at"`
+}
+
+// Summary returns the summary of the stalls, or false if there were none.
+func (w *stallWatchdog) Summary() (stallsJSON, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.count == 0 {
+		return stallsJSON{}, false
+	}
+	return stallsJSON{
+		Count:     w.count,
+		TotalNs:   int64(w.total),
+		LongestNs: int64(w.longest.gap),
+		LongestAt: w.longest.start,
+	}, true
+}
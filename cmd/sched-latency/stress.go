@@ -0,0 +1,93 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// idleTimerDuration is long enough that idle timers never fire during a run.
+const idleTimerDuration = 24 * time.Hour
+
+// startIdleTimers creates n long-duration timers to grow the runtime's timer
+// heaps. The timers are created from one goroutine per P, so they're spread
+// across the per-P heaps. It returns a function that stops all the timers.
+func startIdleTimers(n int) (stop func()) {
+	goroutines := runtime.GOMAXPROCS(0)
+	timers := make([][]*time.Timer, goroutines)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		count := n / goroutines
+		if g < n%goroutines {
+			count++
+		}
+
+		g := g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Func timers stay in the timer heap, unlike channel timers,
+			// which may only be added once something waits on the channel.
+			timers[g] = make([]*time.Timer, count)
+			for i := range timers[g] {
+				timers[g][i] = time.AfterFunc(idleTimerDuration, func() {})
+			}
+		}()
+	}
+	wg.Wait()
+
+	return func() {
+		for _, ts := range timers {
+			for _, t := range ts {
+				t.Stop()
+			}
+		}
+	}
+}
+
+// idleGoroutineBatch is how many idle goroutines each spawning goroutine
+// starts, so spawning many goroutines is spread across Ps.
+const idleGoroutineBatch = 10000
+
+// startIdleGoroutines starts n goroutines that are parked on a channel until
+// the returned function releases them. It returns once all are running.
+func startIdleGoroutines(n int) (release func()) {
+	done := make(chan struct{})
+	var running sync.WaitGroup
+	running.Add(n)
+
+	var spawners sync.WaitGroup
+	for start := 0; start < n; start += idleGoroutineBatch {
+		count := idleGoroutineBatch
+		if start+count > n {
+			count = n - start
+		}
+
+		spawners.Add(1)
+		go func() {
+			defer spawners.Done()
+			for i := 0; i < count; i++ {
+				go func() {
+					running.Done()
+					<-done
+				}()
+			}
+		}()
+	}
+	spawners.Wait()
+	running.Wait()
+
+	return func() {
+		close(done)
+	}
+}
+
+// goroutineMemory returns the number of goroutines, and the memory used by
+// goroutine stacks and by the heap.
+func goroutineMemory() (goroutines int, stack, heap uint64) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return runtime.NumGoroutine(), m.StackInuse, m.HeapInuse
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"sched-latency/report"
+	"sched-latency/stats"
+)
+
+// runSummary accumulates samples across the whole run for the end-of-run summary.
+var runSummary = newSummary()
+
+// summary accumulates every probe's samples, grouped by the phase of the run
+// (e.g., a ramp step) that was active when the probe reported them.
+//
+// Samples are counted in log-spaced buckets of the given resolution, rather
+// than kept, so the summary's memory doesn't grow over a long run.
+type summary struct {
+	start      time.Time
+	resolution float64
+
+	mu     sync.Mutex
+	notes  []string
+	phases []*phaseSummary
+
+	// run has the samples of every phase, added along with the phase's, so
+	// the whole run's percentiles are as cheap to get as a phase's, however
+	// often they're asked for, e.g., on each SIGUSR2.
+	run *phaseSummary
+
+	// worst is the interval with the highest top percentile for each probe,
+	// in the order the probes first reported, and worstTail the interval
+	// with the highest p99, if it's one of the percentiles.
+	worst       map[string]worstInterval
+	worstTail   map[string]worstInterval
+	worstProbes []string
+}
+
+// worstInterval is a reported interval and its percentiles.
+type worstInterval struct {
+	start       time.Time
+	length      time.Duration
+	percentiles []time.Duration
+}
+
+// phaseSummary has the probes' samples in a phase. The samples of probes
+// that take them are counted in samples, and the values of probes that
+// read a histogram, such as /sched/latencies, in hists.
+type phaseSummary struct {
+	name    string
+	probes  []string
+	samples map[string]*stats.LogHist
+	hists   map[string]stats.HistSnapshot
+}
+
+// defaultSummaryResolution is the summary's resolution until SetResolution.
+const defaultSummaryResolution = 0.01
+
+func newSummary() *summary {
+	s := &summary{
+		start:      time.Now(),
+		resolution: defaultSummaryResolution,
+		worst:      make(map[string]worstInterval),
+		worstTail:  make(map[string]worstInterval),
+		run:        newPhaseSummary("whole run"),
+	}
+	s.SetPhase("")
+	return s
+}
+
+func newPhaseSummary(name string) *phaseSummary {
+	return &phaseSummary{
+		name:    name,
+		samples: make(map[string]*stats.LogHist),
+		hists:   make(map[string]stats.HistSnapshot),
+	}
+}
+
+// SetResolution sets the resolution of the buckets samples are counted in.
+// It must be called before any samples are added.
+func (s *summary) SetResolution(resolution float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resolution = resolution
+}
+
+// AddNote adds a note about how the run was set up to the summary.
+func (s *summary) AddNote(note string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notes = append(s.notes, note)
+}
+
+// SetPhase starts a new phase, which subsequently added samples belong to.
+func (s *summary) SetPhase(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Replace the initial phase if nothing was recorded in it.
+	if n := len(s.phases); n > 0 && len(s.phases[n-1].probes) == 0 {
+		s.phases = s.phases[:n-1]
+	}
+	s.phases = append(s.phases, newPhaseSummary(name))
+}
+
+// Phase returns the current phase, which changes at each phase boundary.
+func (s *summary) Phase() *phaseSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.phases[len(s.phases)-1]
+}
+
+func (s *summary) current(probe string) *phaseSummary {
+	return s.phases[len(s.phases)-1].probe(probe)
+}
+
+// probe returns p after making sure the probe is listed in p.probes.
+func (p *phaseSummary) probe(probe string) *phaseSummary {
+	if _, ok := p.samples[probe]; !ok {
+		if _, ok := p.hists[probe]; !ok {
+			p.probes = append(p.probes, probe)
+		}
+	}
+	return p
+}
+
+// AddSamples counts samples for the probe in the current phase.
+func (s *summary) AddSamples(probe string, samples []time.Duration) {
+	if len(samples) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.current(probe).addSamples(probe, samples, s.resolution)
+	s.run.probe(probe).addSamples(probe, samples, s.resolution)
+}
+
+func (p *phaseSummary) addSamples(probe string, samples []time.Duration, resolution float64) {
+	h, ok := p.samples[probe]
+	if !ok {
+		h = stats.NewLogHist(resolution)
+		p.samples[probe] = h
+	}
+	for _, d := range samples {
+		h.Add(d)
+	}
+}
+
+// AddHistogram records the latencies in a histogram snapshot, the diff
+// between two readings, for the probe in the current phase.
+func (s *summary) AddHistogram(probe string, diff stats.HistSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.current(probe).addHistogram(probe, diff)
+	s.run.probe(probe).addHistogram(probe, diff)
+}
+
+func (p *phaseSummary) addHistogram(probe string, diff stats.HistSnapshot) {
+	merged, err := p.hists[probe].Merge(diff)
+	if err != nil {
+		log.Printf("%v: %v", probe, err)
+	}
+	p.hists[probe] = merged
+}
+
+// wholeRun returns the samples of all phases combined. The lock must be
+// held while it's used.
+func (s *summary) wholeRun() *phaseSummary {
+	return s.run
+}
+
+// AddInterval records the percentiles of a reported interval, keeping the
+// interval with the highest top percentile for each probe, and the one with
+// the highest percentile at index tail, unless it's negative.
+func (s *summary) AddInterval(probe string, start, end time.Time, percentiles []time.Duration, tail int) {
+	if len(percentiles) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	interval := worstInterval{start: start, length: end.Sub(start), percentiles: percentiles}
+	top := len(percentiles) - 1
+	w, ok := s.worst[probe]
+	if !ok {
+		s.worstProbes = append(s.worstProbes, probe)
+	}
+	if !ok || percentiles[top] > w.percentiles[top] {
+		interval.percentiles = append([]time.Duration(nil), percentiles...)
+		s.worst[probe] = interval
+	}
+	if tail < 0 || tail == top {
+		return
+	}
+	if w, ok := s.worstTail[probe]; !ok || percentiles[tail] > w.percentiles[tail] {
+		interval.percentiles = append([]time.Duration(nil), percentiles...)
+		s.worstTail[probe] = interval
+	}
+}
+
+// Print prints the config and environment of the run, and the percentiles
+// for each probe over the whole run and per phase.
+func (s *summary) Print(w io.Writer, cfg Config, title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintln(w, title+":")
+	if cfg.Label != "" {
+		fmt.Fprintf(w, "  label: %v\n", cfg.Label)
+	}
+	fmt.Fprintf(w, "  duration: %v\n", time.Since(s.start).Truncate(time.Millisecond))
+	fmt.Fprintf(w, "  config: %+v\n", cfg.Config)
+	fmt.Fprintf(w, "  environment: %v\n", environment())
+	fmt.Fprintf(w, "  tags: %v\n", runTags)
+	fmt.Fprintf(w, "  build: %v\n", build)
+	if cfg.Ballast > 0 {
+		fmt.Fprintf(w, "  ballast: %v\n", cfg.Ballast)
+	}
+	if cfg.GOMEMLIMIT > 0 {
+		fmt.Fprintf(w, "  gomemlimit: %v\n", cfg.GOMEMLIMIT)
+	}
+	if _, ok := cfg.GOGC.Percent(); ok {
+		fmt.Fprintf(w, "  gogc: %v\n", cfg.GOGC)
+	}
+	for _, note := range s.notes {
+		fmt.Fprintf(w, "  %s\n", note)
+	}
+	for _, warning := range envWarnings {
+		fmt.Fprintf(w, "  environment warning: %s\n", warning)
+	}
+
+	phases := s.phases
+	if len(phases) > 1 {
+		phases = append([]*phaseSummary{s.wholeRun()}, phases...)
+	}
+	for _, p := range phases {
+		if p.name != "" {
+			fmt.Fprintf(w, "  %s\n", p.name)
+		}
+		for _, probe := range p.probes {
+			fmt.Fprintf(w, "%20s: %s samples %d\n", probe, report.FormatPercentiles(p.percentiles(cfg, probe)), p.count(probe))
+			if tail := p.tail(cfg, probe); tail != nil {
+				fmt.Fprintf(w, "%20s  %s\n", "", report.FormatTail(cfg.TailThresholds, tail))
+			}
+		}
+	}
+
+	if stalls != nil {
+		if st, ok := stalls.Summary(); ok {
+			fmt.Fprintf(w, "  process stalls: %d for %v in total, longest %v at +%v\n", st.Count,
+				time.Duration(st.TotalNs).Truncate(time.Millisecond), time.Duration(st.LongestNs).Truncate(time.Millisecond),
+				st.LongestAt.Sub(s.start).Truncate(time.Millisecond))
+		}
+	}
+
+	s.printWorst(w, "worst interval by max", s.worst)
+	s.printWorst(w, "worst interval by p99", s.worstTail)
+}
+
+// printWorst prints each probe's worst interval, with its percentiles and
+// when it was, both relative to the start and on the wall clock.
+func (s *summary) printWorst(w io.Writer, title string, worst map[string]worstInterval) {
+	if len(worst) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %v\n", title)
+	for _, probe := range s.worstProbes {
+		interval, ok := worst[probe]
+		if !ok {
+			continue
+		}
+		end := interval.start.Add(interval.length)
+		fmt.Fprintf(w, "%20s: %s at +%v for %v (%v to %v)\n", probe, report.FormatPercentiles(interval.percentiles),
+			interval.start.Sub(s.start).Truncate(time.Millisecond), interval.length.Truncate(time.Millisecond),
+			interval.start.Format("15:04:05.000"), end.Format("15:04:05.000"))
+	}
+}
+
+// environment describes the Go runtime and machine the run is on.
+func environment() string {
+	return fmt.Sprintf("%v %v/%v, %d CPUs, GOMAXPROCS %d",
+		runtime.Version(), runtime.GOOS, runtime.GOARCH, runtime.NumCPU(), runtime.GOMAXPROCS(0))
+}
+
+// percentiles returns the percentiles of all samples recorded for the probe,
+// interpolated within their buckets.
+func (p *phaseSummary) percentiles(cfg Config, probe string) []time.Duration {
+	if h, ok := p.samples[probe]; ok {
+		return h.Percentiles(cfg.Percentiles)
+	}
+	return p.hists[probe].Percentiles(cfg.Percentiles)
+}
+
+// Percentile returns the probe's p percentile over the whole run, or false
+// if it has no samples.
+func (s *summary) Percentile(cfg Config, probe string, p float64) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := s.wholeRun()
+	if all.count(probe) == 0 {
+		return 0, false
+	}
+	cfg.Percentiles = []float64{p}
+	return all.percentiles(cfg, probe)[0], true
+}
+
+// count returns the number of samples recorded for the probe.
+func (p *phaseSummary) count(probe string) uint64 {
+	if h, ok := p.samples[probe]; ok {
+		return h.Count()
+	}
+	return p.hists[probe].Count()
+}
+
+// PrintComparison prints a table comparing each probe's percentiles in the
+// loaded phase against the baseline phase, along with the recovery phase.
+func (s *summary) PrintComparison(w io.Writer, cfg Config, baseline, loaded, recovery string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	phases := make(map[string]*phaseSummary)
+	for _, p := range s.phases {
+		phases[p.name] = p
+	}
+	base := phases[baseline]
+	if base == nil {
+		fmt.Fprintln(w, "No samples recorded during the baseline phase.")
+		return
+	}
+
+	forPhase := func(name, probe string) []time.Duration {
+		p := phases[name]
+		if p == nil {
+			return nil
+		}
+		if _, ok := p.samples[probe]; !ok {
+			if _, ok := p.hists[probe]; !ok {
+				return nil
+			}
+		}
+		return p.percentiles(cfg, probe)
+	}
+
+	fmt.Fprintf(w, "%20s  %-4s  %-10s  %-10s  %-9s  %-10s\n", "probe", "", baseline, loaded, "ratio", recovery)
+	for _, probe := range base.probes {
+		basePs := base.percentiles(cfg, probe)
+		loadedPs := forPhase(loaded, probe)
+		recoveryPs := forPhase(recovery, probe)
+		for i, p := range cfg.Percentiles {
+			ratio := "-"
+			if loadedPs != nil && basePs[i] > 0 {
+				ratio = fmt.Sprintf("%.2fx", float64(loadedPs[i])/float64(basePs[i]))
+			}
+			fmt.Fprintf(w, "%20s  %-4s  %-10v  %-10v  %-9s  %-10v\n",
+				probe, stats.PercentileName(p), stats.Truncate(basePs[i]), at(loadedPs, i), ratio, at(recoveryPs, i))
+		}
+	}
+}
+
+// at returns the truncated duration at index i, or "-" if ps is empty.
+func at(ps []time.Duration, i int) interface{} {
+	if ps == nil {
+		return "-"
+	}
+	return stats.Truncate(ps[i])
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"sched-latency/report"
+	"sched-latency/stats"
+)
+
+// summaryVersion is the version of the summary JSON schema, bumped on any
+// change that would make an older summary be misread.
+const summaryVersion = 1
+
+// summaryJSON is the machine-readable run summary written by -summary-json
+// and read back by -baseline. Its flags can be used as a -config file to
+// repeat the run.
+type summaryJSON struct {
+	Version     int                `json:"version"`
+	Label       string             `json:"label,omitempty"`
+	Tags        report.Tags        `json:"tags,omitempty"`
+	Start       time.Time          `json:"start"`
+	DurationNs  int64              `json:"duration_ns"`
+	GoVersion   string             `json:"go_version"`
+	Build       buildInfo          `json:"build"`
+	GOOS        string             `json:"goos"`
+	GOARCH      string             `json:"goarch"`
+	NumCPU      int                `json:"num_cpu"`
+	GOMAXPROCS  int                `json:"gomaxprocs"`
+	Notes       []string           `json:"notes,omitempty"`
+	EnvWarnings []string           `json:"environment_warnings,omitempty"`
+	Flags       map[string]string  `json:"flags"`
+	Seed        int64              `json:"seed"`
+	Percentiles []float64          `json:"percentiles"`
+	Probes      []probeSummaryJSON `json:"probes"`
+	Phases      []phaseSummaryJSON `json:"phases,omitempty"`
+	Stalls      *stallsJSON        `json:"stalls,omitempty"`
+	Sweep       []sweepPointJSON   `json:"sweep,omitempty"`
+
+	// Load is the config of the -role=load process, in the measure
+	// process's summary.
+	Load *roleLoadJSON `json:"load,omitempty"`
+}
+
+type phaseSummaryJSON struct {
+	Name   string             `json:"name"`
+	Probes []probeSummaryJSON `json:"probes"`
+}
+
+type probeSummaryJSON struct {
+	Name    string `json:"name"`
+	Samples uint64 `json:"samples"`
+
+	// PercentilesNs maps percentile names, such as "p99", to nanoseconds.
+	PercentilesNs map[string]int64 `json:"percentiles_ns"`
+
+	// Tail maps each -tail-thresholds, such as "10ms", to the number of
+	// samples over it.
+	Tail map[string]uint64 `json:"tail,omitempty"`
+}
+
+// JSON returns the machine-readable summary, with the whole run's
+// percentiles for each probe, and per phase if there's more than one.
+func (s *summary) JSON(cfg Config) summaryJSON {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := summaryJSON{
+		Version:     summaryVersion,
+		Label:       cfg.Label,
+		Tags:        runTags,
+		Start:       s.start,
+		DurationNs:  int64(time.Since(s.start)),
+		GoVersion:   runtime.Version(),
+		Build:       build,
+		GOOS:        runtime.GOOS,
+		GOARCH:      runtime.GOARCH,
+		NumCPU:      runtime.NumCPU(),
+		GOMAXPROCS:  runtime.GOMAXPROCS(0),
+		Notes:       s.notes,
+		EnvWarnings: envWarnings,
+		Flags:       effectiveFlags(runFlags),
+		Seed:        cfg.Seed,
+		Percentiles: cfg.Percentiles,
+		Probes:      s.wholeRun().probesJSON(cfg),
+	}
+	if stalls != nil {
+		if st, ok := stalls.Summary(); ok {
+			out.Stalls = &st
+		}
+	}
+	if role != nil && cfg.Role == "measure" {
+		out.Load = role.LoadJSON()
+	}
+	if len(s.phases) > 1 {
+		for _, p := range s.phases {
+			out.Phases = append(out.Phases, phaseSummaryJSON{Name: p.name, Probes: p.probesJSON(cfg)})
+		}
+	}
+	return out
+}
+
+func (p *phaseSummary) probesJSON(cfg Config) []probeSummaryJSON {
+	probes := make([]probeSummaryJSON, 0, len(p.probes))
+	for _, probe := range p.probes {
+		ps := p.percentiles(cfg, probe)
+		byName := make(map[string]int64, len(ps))
+		for i, v := range ps {
+			byName[stats.PercentileName(cfg.Percentiles[i])] = int64(v)
+		}
+		probes = append(probes, probeSummaryJSON{
+			Name:          probe,
+			Samples:       p.count(probe),
+			PercentilesNs: byName,
+			Tail:          p.tailJSON(cfg, probe),
+		})
+	}
+	return probes
+}
+
+// writeSummaryJSON writes the summary as indented JSON to path.
+func writeSummaryJSON(path string, s summaryJSON) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// readSummaryJSON reads a summary written by writeSummaryJSON, rejecting
+// summaries from other schema versions.
+func readSummaryJSON(path string) (summaryJSON, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return summaryJSON{}, err
+	}
+	var s summaryJSON
+	if err := json.Unmarshal(b, &s); err != nil {
+		return summaryJSON{}, fmt.Errorf("failed to parse summary %v: %v", path, err)
+	}
+	if s.Version != summaryVersion {
+		return summaryJSON{}, fmt.Errorf("summary %v has version %d, but only version %d is supported", path, s.Version, summaryVersion)
+	}
+	return s, nil
+}
+
+// compareBaseline prints how each probe's percentiles changed from the
+// baseline to the current run, and returns whether any worsened by more than
+// the allowed margin. A zero margin reports changes without failing. If hint
+// is set, it's printed for each percentile, e.g. whether the change looks
+// significant.
+func compareBaseline(cfg Config, baseline, cur summaryJSON, margin float64, hint func(probe, percentile string) string) (regressed bool) {
+	fmt.Println("Baseline comparison:")
+	if baseline.Label != "" || cur.Label != "" {
+		fmt.Printf("  baseline label: %v\n  current label:  %v\n", labelOrNone(baseline.Label), labelOrNone(cur.Label))
+	}
+	fmt.Printf("%20s  %-4s  %-10s  %-10s  %-10s  %-9s\n", "probe", "", "baseline", "current", "delta", "change")
+
+	baseProbes := make(map[string]probeSummaryJSON)
+	for _, p := range baseline.Probes {
+		baseProbes[p.Name] = p
+	}
+	for _, probe := range cur.Probes {
+		base, ok := baseProbes[probe.Name]
+		if !ok {
+			fmt.Printf("%20s: not in baseline\n", probe.Name)
+			continue
+		}
+		for _, p := range cfg.Percentiles {
+			name := stats.PercentileName(p)
+			baseNs, ok := base.PercentilesNs[name]
+			if !ok {
+				continue
+			}
+			curNs := probe.PercentilesNs[name]
+
+			delta := "+" + stats.Truncate(time.Duration(curNs-baseNs)).String()
+			if curNs < baseNs {
+				delta = "-" + stats.Truncate(time.Duration(baseNs-curNs)).String()
+			}
+			change := "-"
+			var mark string
+			if baseNs > 0 {
+				rel := float64(curNs-baseNs) / float64(baseNs)
+				change = fmt.Sprintf("%+.1f%%", rel*100)
+				if margin > 0 && rel > margin {
+					regressed = true
+					mark = "REGRESSED"
+				}
+			}
+			if hint != nil {
+				mark = strings.TrimSpace(fmt.Sprintf("%-9s  %s", hint(probe.Name, name), mark))
+			}
+			fmt.Printf("%20s  %-4s  %-10v  %-10v  %-10s  %-9s  %s\n", probe.Name, name,
+				stats.Truncate(time.Duration(baseNs)), stats.Truncate(time.Duration(curNs)), delta, change, mark)
+		}
+	}
+	return regressed
+}
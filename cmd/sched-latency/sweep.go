@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"sched-latency/config"
+	"sched-latency/stats"
+)
+
+// sweepFlags are the flags that configure a sweep, which aren't passed on to
+// the runs for each point.
+var sweepFlags = map[string]bool{
+	"sweep-gomaxprocs":    true,
+	"sweep-sleep":         true,
+	"sweep-workers":       true,
+	"matrix":              true,
+	"point-duration":      true,
+	"quiesce":             true,
+	"ab-godebug":          true,
+	config.ConfigFileFlag: true,
+	"record":              true,
+	"summary-json":        true,
+	"baseline":            true,
+	"fail-on-regression":  true,
+	"fail-if":             true,
+	"pprof":               true,
+	"trace":               true,
+	"cpuprofile":          true,
+	"blockprofile":        true,
+	"mutexprofile":        true,
+	"sink":                true,
+}
+
+// sweepPoint is a single run of a sweep.
+type sweepPoint struct {
+	// params describe the point, e.g. GOMAXPROCS=4, in the order they're
+	// printed in.
+	params []sweepParam
+
+	env  []string
+	args []string
+}
+
+type sweepParam struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// sweepPointJSON is a point's results in the summary JSON.
+type sweepPointJSON struct {
+	Params []sweepParam       `json:"params"`
+	Probes []probeSummaryJSON `json:"probes"`
+}
+
+// parseIntList parses a comma-separated list of integers that are at least min.
+func parseIntList(s string, min int) ([]int, error) {
+	var vs []int
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || v < min {
+			return nil, fmt.Errorf("invalid value %q, must be an integer of at least %d", part, min)
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+// gomaxprocsSweep returns a point for each GOMAXPROCS value.
+func gomaxprocsSweep(values []int) []sweepPoint {
+	points := make([]sweepPoint, len(values))
+	for i, v := range values {
+		points[i] = sweepPoint{
+			params: []sweepParam{{"GOMAXPROCS", strconv.Itoa(v)}},
+			args:   []string{"-gomaxprocs=" + strconv.Itoa(v)},
+		}
+	}
+	return points
+}
+
+// sleepSweep returns a point for each sleep interval, which gives a curve of
+// how timer accuracy depends on the interval.
+func sleepSweep(intervals []time.Duration) []sweepPoint {
+	points := make([]sweepPoint, len(intervals))
+	for i, d := range intervals {
+		points[i] = sweepPoint{
+			params: []sweepParam{{"sleep", d.String()}},
+			args:   []string{"-sleep-interval=" + d.String()},
+		}
+	}
+	return points
+}
+
+// parseGODEBUGVariants parses a comma-separated list of GODEBUG values, where
+// values containing commas can be quoted, e.g. `"",asyncpreemptoff=1`.
+func parseGODEBUGVariants(s string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(s))
+	variants, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(variants) < 2 {
+		return nil, fmt.Errorf("expected at least 2 variants, got %q", s)
+	}
+	return variants, nil
+}
+
+// godebugSweep returns a point for each GODEBUG value, which can only be set
+// when the process starts.
+func godebugSweep(variants []string) []sweepPoint {
+	points := make([]sweepPoint, len(variants))
+	for i, v := range variants {
+		label := v
+		if label == "" {
+			label = "(default)"
+		}
+		points[i] = sweepPoint{
+			params: []sweepParam{{"GODEBUG", label}},
+			env:    []string{"GODEBUG=" + v},
+		}
+	}
+	return points
+}
+
+// workersSweep returns a point for each number of workers.
+func workersSweep(values []int) []sweepPoint {
+	points := make([]sweepPoint, len(values))
+	for i, v := range values {
+		points[i] = sweepPoint{
+			params: []sweepParam{{"workers", strconv.Itoa(v)}},
+			args:   []string{"-workers=" + strconv.Itoa(v)},
+		}
+	}
+	return points
+}
+
+// matrixSweep returns a point for every combination of the sweeps' points.
+func matrixSweep(sweeps [][]sweepPoint) []sweepPoint {
+	points := []sweepPoint{{}}
+	for _, sweep := range sweeps {
+		var combined []sweepPoint
+		for _, p := range points {
+			for _, q := range sweep {
+				combined = append(combined, sweepPoint{
+					params: append(append([]sweepParam(nil), p.params...), q.params...),
+					env:    append(append([]string(nil), p.env...), q.env...),
+					args:   append(append([]string(nil), p.args...), q.args...),
+				})
+			}
+		}
+		points = combined
+	}
+	return points
+}
+
+// runSweep runs this binary once per point, with the flags set in fs that
+// aren't specific to the sweep, and prints a table of each point's whole-run
+// percentiles. A SIGINT or SIGTERM stops the sweep after the current point,
+// and prints the points completed so far.
+func runSweep(fs *flag.FlagSet, cfg Config, points []sweepPoint) []sweepPointJSON {
+	exe, err := os.Executable()
+	if err != nil {
+		fatalf("failed to find executable for sweep: %v", err)
+	}
+	dir, err := os.MkdirTemp("", "sched-latency-sweep")
+	if err != nil {
+		fatalf("failed to create sweep directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var baseArgs []string
+	fs.Visit(func(f *flag.Flag) {
+		if !sweepFlags[f.Name] {
+			baseArgs = append(baseArgs, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	total := time.Duration(len(points))*cfg.Duration + time.Duration(len(points)-1)*cfg.SweepQuiesce
+	fmt.Printf("Sweep: %d points of %v, with %v between points, %v in total\n",
+		len(points), cfg.Duration, cfg.SweepQuiesce, total)
+
+	var results []sweepPointJSON
+	for i, point := range points {
+		if i > 0 {
+			// Let the machine settle, so one point's load doesn't bleed
+			// into the next.
+			select {
+			case <-time.After(cfg.SweepQuiesce):
+			case sig := <-sigs:
+				fmt.Printf("Sweep stopped by %v after %d of %d points\n", sig, i, len(points))
+				printSweep(cfg, results)
+				return results
+			}
+		}
+		fmt.Printf("Sweep point %d/%d: %v\n", i+1, len(points), point)
+
+		summaryPath := filepath.Join(dir, fmt.Sprintf("point-%d.json", i))
+		args := append(append([]string(nil), baseArgs...), point.args...)
+		args = append(args, "-"+config.SweepChildFlag, "-duration="+cfg.Duration.String(), "-summary-json="+summaryPath)
+		cmd := exec.Command(exe, args...)
+		cmd.Env = append(os.Environ(), point.env...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			fatalf("failed to start sweep point %v: %v", point, err)
+		}
+
+		exited := make(chan error, 1)
+		go func() {
+			exited <- cmd.Wait()
+		}()
+		var stopped os.Signal
+		select {
+		case err = <-exited:
+		case stopped = <-sigs:
+			// A SIGINT from the terminal is also delivered to the child,
+			// and a second one would stop it without a summary.
+			if stopped != os.Interrupt {
+				cmd.Process.Signal(stopped)
+			}
+			err = <-exited
+		}
+
+		// The child still writes its summary when it's stopped early, or
+		// when a -fail-if assertion fails.
+		if summary, readErr := readSummaryJSON(summaryPath); readErr != nil {
+			log.Printf("sweep point %v failed: %v (%v)", point, readErr, err)
+		} else {
+			results = append(results, sweepPointJSON{Params: point.params, Probes: summary.Probes})
+		}
+
+		if stopped != nil {
+			fmt.Printf("Sweep stopped by %v after %d of %d points\n", stopped, i+1, len(points))
+			break
+		}
+	}
+
+	printSweep(cfg, results)
+	return results
+}
+
+// printSweep prints the results of a sweep, side by side for a single
+// parameter with few values, or otherwise a row per point.
+func printSweep(cfg Config, results []sweepPointJSON) {
+	if cfg.SweepSideBySide {
+		printSideBySide(cfg, results)
+	} else {
+		printSweepRows(cfg, results)
+	}
+}
+
+// sweepMain runs the configured sweep instead of measuring in this process.
+func sweepMain(fs *flag.FlagSet, cfg Config) {
+	results := runSweep(fs, cfg, cfg.Sweep)
+	if cfg.SummaryJSON != "" {
+		summary := runSummary.JSON(cfg)
+		summary.Sweep = results
+		if err := writeSummaryJSON(cfg.SummaryJSON, summary); err != nil {
+			log.Printf("failed to write -summary-json: %v", err)
+		}
+	}
+}
+
+func (p sweepPoint) String() string {
+	parts := make([]string, len(p.params))
+	for i, param := range p.params {
+		parts[i] = param.Name + "=" + param.Value
+	}
+	return strings.Join(parts, " ")
+}
+
+// printSweepRows prints each point's percentiles for each probe.
+func printSweepRows(cfg Config, results []sweepPointJSON) {
+	fmt.Println("Sweep:")
+	if len(results) == 0 {
+		fmt.Println("  no points completed")
+		return
+	}
+
+	header := make([]string, 0, len(results[0].Params))
+	for _, param := range results[0].Params {
+		header = append(header, fmt.Sprintf("%-12s", param.Name))
+	}
+	for _, p := range cfg.Percentiles {
+		header = append(header, fmt.Sprintf("%-10s", stats.PercentileName(p)))
+	}
+	fmt.Printf("%20s  %s\n", "probe", strings.Join(header, "  "))
+
+	for _, r := range results {
+		for _, probe := range r.Probes {
+			row := make([]string, 0, len(header))
+			for _, param := range r.Params {
+				row = append(row, fmt.Sprintf("%-12s", param.Value))
+			}
+			for _, p := range cfg.Percentiles {
+				v, ok := probe.PercentilesNs[stats.PercentileName(p)]
+				cell := "-"
+				if ok {
+					cell = stats.Truncate(time.Duration(v)).String()
+				}
+				row = append(row, fmt.Sprintf("%-10s", cell))
+			}
+			fmt.Printf("%20s  %s\n", probe.Name, strings.Join(row, "  "))
+		}
+	}
+}
+
+// printSideBySide prints a column per point for each probe's percentiles,
+// with the ratio of each point to the first.
+func printSideBySide(cfg Config, results []sweepPointJSON) {
+	fmt.Println("Comparison:")
+	if len(results) == 0 {
+		fmt.Println("  no points completed")
+		return
+	}
+
+	width := 20
+	for _, r := range results {
+		if n := len(sweepPoint{params: r.Params}.String()); n > width {
+			width = n
+		}
+	}
+	header := []string{fmt.Sprintf("%20s  %-4s", "probe", "")}
+	for _, r := range results {
+		header = append(header, fmt.Sprintf("%-*s", width, sweepPoint{params: r.Params}))
+	}
+	fmt.Println(strings.Join(header, "  "))
+
+	for _, probe := range results[0].Probes {
+		for _, p := range cfg.Percentiles {
+			name := stats.PercentileName(p)
+			row := []string{fmt.Sprintf("%20s  %-4s", probe.Name, name)}
+			first := probe.PercentilesNs[name]
+			for i, r := range results {
+				cell := "-"
+				for _, other := range r.Probes {
+					if other.Name != probe.Name {
+						continue
+					}
+					v := other.PercentilesNs[name]
+					cell = stats.Truncate(time.Duration(v)).String()
+					if i > 0 && first > 0 {
+						cell += fmt.Sprintf(" (%.2fx)", float64(v)/float64(first))
+					}
+				}
+				row = append(row, fmt.Sprintf("%-*s", width, cell))
+			}
+			fmt.Println(strings.Join(row, "  "))
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sched-latency/stats"
+)
+
+// tail returns the number of values in the histogram over each
+// -tail-thresholds, interpolated within the buckets they fall in, or nil if
+// there are none.
+func (c Config) tail(h stats.HistSnapshot) []uint64 {
+	if c.TailThresholds == nil {
+		return nil
+	}
+	return h.CountAbove(c.TailThresholds)
+}
+
+// tail returns the number of the probe's samples in the phase over each
+// -tail-thresholds, or nil if there are none. They're counted from the same
+// buckets as the phase's percentiles, so they're the totals of the
+// intervals after the warmup, interpolated within the buckets.
+func (p *phaseSummary) tail(cfg Config, probe string) []uint64 {
+	if cfg.TailThresholds == nil {
+		return nil
+	}
+	if h, ok := p.samples[probe]; ok {
+		return cfg.tail(h.Snapshot())
+	}
+	return cfg.tail(p.hists[probe])
+}
+
+// tailJSON returns the probe's tail counts in the phase keyed by threshold,
+// e.g., "10ms", or nil if there are none.
+func (p *phaseSummary) tailJSON(cfg Config, probe string) map[string]uint64 {
+	counts := p.tail(cfg, probe)
+	if counts == nil {
+		return nil
+	}
+	byThreshold := make(map[string]uint64, len(counts))
+	for i, n := range counts {
+		byThreshold[cfg.TailThresholds[i].String()] = n
+	}
+	return byThreshold
+}
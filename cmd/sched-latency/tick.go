@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"sched-latency/config"
+	"sched-latency/probe"
+)
+
+// intervalSource is a probe's accumulated interval, which is handed over on
+// every report tick. Its methods are called from the tick's goroutine.
+type intervalSource interface {
+	// Next reports the interval ending at now, and starts the next one.
+	Next(now time.Time)
+
+	// Partial reports the interval so far, leaving it running.
+	Partial()
+}
+
+// reportTick is the tick every probe's intervals are reported on, so each
+// burst of reports covers the same window for every probe.
+var reportTick = &intervalTicker{}
+
+// intervalTicker reports its sources' intervals together, in the order the
+// sources were added. Probes only collect samples; the ticker decides when
+// they're reported.
+type intervalTicker struct {
+	mu      sync.Mutex
+	sources []intervalSource
+}
+
+// Add adds a source to report on each tick.
+func (t *intervalTicker) Add(s intervalSource) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sources = append(t.sources, s)
+}
+
+// Remove stops reporting the source, e.g., once its probe has stopped.
+func (t *intervalTicker) Remove(s intervalSource) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, source := range t.sources {
+		if source == s {
+			t.sources = append(t.sources[:i:i], t.sources[i+1:]...)
+			return
+		}
+	}
+}
+
+// each calls f for every source, without holding the lock so sources can
+// be removed while they report.
+func (t *intervalTicker) each(f func(s intervalSource)) {
+	t.mu.Lock()
+	sources := append([]intervalSource(nil), t.sources...)
+	t.mu.Unlock()
+	for _, s := range sources {
+		f(s)
+	}
+}
+
+// Run reports every source's interval every -report-interval, and their
+// intervals so far on each out-of-cycle request, until ctx is done. It
+// runs on the -probe-cpus, since it reads /sched/latencies.
+//
+// The CPU breakdown, if any, is taken first on each tick, so every probe's
+// report for the interval includes it.
+//
+// Each tick's lateness from its ideal schedule is reported too, as the
+// "report tick delay" probe, since it's how far the reports drift from
+// evenly spaced points, and a probe of ticker delivery to a mostly idle
+// goroutine.
+func (t *intervalTicker) Run(ctx context.Context, cfg Config) {
+	pinThread(cfg.ProbeCPUs)
+
+	var delay *tickDelay
+	if cfg.ProbeEnabled("tick") {
+		delay = newTickDelay(cfg)
+		t.Add(delay)
+		defer t.Remove(delay)
+	}
+
+	start := time.Now()
+	tick := time.NewTicker(cfg.ReportInterval)
+	defer tick.Stop()
+	reportReq := reportNowC()
+	for {
+		select {
+		case now := <-tick.C:
+			if delay != nil {
+				delay.Add(start, time.Now())
+			}
+			if cpuBreakdown != nil {
+				cpuBreakdown.Next(now)
+			}
+			t.each(func(s intervalSource) { s.Next(now) })
+		case <-reportReq:
+			reportReq = reportNowC()
+			t.each(intervalSource.Partial)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tickDelayProbe is the name the report tick's lateness is reported under.
+const tickDelayProbe = config.TickDelayProbe
+
+// tickDelay is the source for the report tick's lateness, with one sample
+// per tick.
+type tickDelay struct {
+	cfg     Config
+	samples *probe.Interval
+}
+
+func newTickDelay(cfg Config) *tickDelay {
+	d := &tickDelay{cfg: cfg, samples: probe.NewInterval(tickDelayProbe, cfg.Percentiles)}
+	if cfg.TailThresholds != nil {
+		d.samples.SetThresholds(cfg.TailThresholds)
+	}
+	return d
+}
+
+// Add records the lateness of the tick received at now, from the ideal
+// schedule of one tick every report interval since start. The ticker never
+// fires early, so the tick is for the last ideal time before now; ticks it
+// dropped while starved are skipped rather than counted as late.
+func (d *tickDelay) Add(start, now time.Time) {
+	d.samples.Add(now.Sub(start)%d.cfg.ReportInterval, now)
+}
+
+// Next implements intervalSource.
+func (d *tickDelay) Next(now time.Time) {
+	snap, samples := d.samples.Next(now)
+	if snap.Interval.Count == 0 {
+		return
+	}
+	if !now.Before(warmupEnd) {
+		runSummary.AddSamples(tickDelayProbe, samples)
+	}
+	d.cfg.Report(tickDelayProbe, snap.Interval.Start, now, snap.Interval.Values, snap.Interval.Count, snap.Interval.Negative, snap.Interval.Tail)
+}
+
+// Partial implements intervalSource.
+func (d *tickDelay) Partial() {
+	snap := d.samples.Snapshot()
+	d.cfg.ReportPartial(tickDelayProbe, snap.Interval.Start, snap.Interval.Values, snap.Interval.Count, snap.Interval.Negative, snap.Interval.Tail)
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// setupTimerRes returns "", as the timer resolution is only set on Windows.
+func setupTimerRes(Config) string {
+	return ""
+}
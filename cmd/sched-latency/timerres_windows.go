@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	winmm               = syscall.NewLazyDLL("winmm.dll")
+	procTimeBeginPeriod = winmm.NewProc("timeBeginPeriod")
+	procTimeEndPeriod   = winmm.NewProc("timeEndPeriod")
+	ntdll               = syscall.NewLazyDLL("ntdll.dll")
+	procNtQueryTimerRes = ntdll.NewProc("NtQueryTimerResolution")
+)
+
+// timerPeriod is the timer resolution requested with timeBeginPeriod, in
+// milliseconds.
+const timerPeriod = 1
+
+// setupTimerRes raises the system timer resolution to timerPeriod,
+// unless -keep-timer-resolution, and describes the effective resolution.
+func setupTimerRes(cfg Config) string {
+	var result string
+	if !cfg.KeepTimerRes {
+		if err := beginTimerPeriod(); err != nil {
+			result = fmt.Sprintf("failed to raise to %dms: %v, ", timerPeriod, err)
+		} else {
+			addExitHook(endTimerPeriod)
+			result = fmt.Sprintf("raised to %dms, ", timerPeriod)
+		}
+	}
+	coarsest, finest, current, err := timerResolution()
+	if err != nil {
+		return result + err.Error()
+	}
+	return fmt.Sprintf("%scurrently %v (supports %v to %v)", result, current, finest, coarsest)
+}
+
+// beginTimerPeriod raises the system timer resolution to timerPeriod. It
+// affects the whole system until endTimerPeriod.
+func beginTimerPeriod() error {
+	if r, _, _ := procTimeBeginPeriod.Call(timerPeriod); r != 0 {
+		return fmt.Errorf("timeBeginPeriod(%d) failed with %d", timerPeriod, r)
+	}
+	return nil
+}
+
+// endTimerPeriod undoes beginTimerPeriod.
+func endTimerPeriod() {
+	procTimeEndPeriod.Call(timerPeriod)
+}
+
+// timerResolution returns the system timer resolution: the coarsest and
+// finest the system supports, and the current one.
+func timerResolution() (coarsest, finest, current time.Duration, err error) {
+	// The resolutions are in 100ns units.
+	var min, max, cur uint32
+	status, _, _ := procNtQueryTimerRes.Call(uintptr(unsafe.Pointer(&min)), uintptr(unsafe.Pointer(&max)), uintptr(unsafe.Pointer(&cur)))
+	if status != 0 {
+		return 0, 0, 0, fmt.Errorf("NtQueryTimerResolution failed with status %#x", status)
+	}
+	return time.Duration(min) * 100, time.Duration(max) * 100, time.Duration(cur) * 100, nil
+}
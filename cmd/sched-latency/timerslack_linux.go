@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	prSetTimerSlack = 29
+	prGetTimerSlack = 30
+)
+
+var errTimerSlackUnsupported error
+
+// getTimerSlack returns the timer slack of the calling thread.
+func getTimerSlack() (time.Duration, error) {
+	slack, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prGetTimerSlack, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return time.Duration(slack), nil
+}
+
+// setThreadTimerSlack sets the timer slack of the calling thread.
+func setThreadTimerSlack(slack time.Duration) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetTimerSlack, uintptr(slack), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setTimerSlack sets the timer slack of the calling thread, and of every
+// other thread in the process where permitted. Threads created later
+// inherit the slack of the thread that creates them.
+func setTimerSlack(slack time.Duration) error {
+	if err := setThreadTimerSlack(slack); err != nil {
+		return err
+	}
+
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return nil
+	}
+	value := []byte(strconv.FormatInt(int64(slack), 10))
+	for _, task := range tasks {
+		// Best-effort, as writing another thread's slack may need CAP_SYS_NICE.
+		os.WriteFile("/proc/self/task/"+task.Name()+"/timerslack_ns", value, 0)
+	}
+	return nil
+}
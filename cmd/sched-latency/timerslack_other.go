@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var errTimerSlackUnsupported = errors.New("timer slack is only supported on Linux")
+
+func getTimerSlack() (time.Duration, error) {
+	return 0, errTimerSlackUnsupported
+}
+
+func setThreadTimerSlack(time.Duration) error {
+	return errTimerSlackUnsupported
+}
+
+func setTimerSlack(time.Duration) error {
+	return errTimerSlackUnsupported
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"sched-latency/config"
+)
+
+// workloadTypes maps each workload name to a constructor for a worker's
+// unit of work. Each worker calls the constructor once, and then calls the
+// returned function repeatedly.
+var workloadTypes = map[string]func() func(){
+	"json":  jsonWork,
+	"spin":  spinWork,
+	"alloc": allocWork,
+}
+
+// jsonWork marshals runtime.MemStats, which both burns CPU and allocates.
+func jsonWork() func() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return func() {
+		json.Marshal(m)
+	}
+}
+
+// spinSink prevents the compiler from optimizing away spinWork.
+var spinSink atomic.Uint64
+
+// spinWork burns CPU without allocating.
+func spinWork() func() {
+	return func() {
+		x := uint64(1)
+		for i := 0; i < 10000; i++ {
+			x = x*6364136223846793005 + 1442695040888963407
+		}
+		spinSink.Store(x)
+	}
+}
+
+// allocWork allocates and retains a rotating window of 4KiB buffers, so it
+// mostly exercises the allocator and the GC.
+func allocWork() func() {
+	var (
+		live [64][]byte
+		next int
+	)
+	return func() {
+		for i := 0; i < 16; i++ {
+			live[next] = make([]byte, 4096)
+			next = (next + 1) % len(live)
+		}
+	}
+}
+
+// workload is a kind of work run by some of the workers, along with the
+// number of units of work completed by those workers.
+type workload struct {
+	name  string
+	newOp func() func()
+	ops   atomic.Uint64
+}
+
+// mixWorkloads returns the workload of each worker in the mix, interleaved
+// so that running only the first n workers keeps roughly the same
+// proportions.
+func mixWorkloads(m config.WorkloadMix) []*workload {
+	remaining := make([]int, len(m))
+	types := make([]*workload, len(m))
+	for i, e := range m {
+		remaining[i] = e.Count
+		types[i] = &workload{name: e.Name, newOp: workloadTypes[e.Name]}
+	}
+
+	var all []*workload
+	for len(all) < m.Workers() {
+		for i := range m {
+			if remaining[i] > 0 {
+				remaining[i]--
+				all = append(all, types[i])
+			}
+		}
+	}
+	return all
+}
+
+// measureWorkerOps reports the rate of work completed by each workload type.
+func measureWorkerOps(ctx context.Context, cfg Config, pool *workerPool) {
+	types := pool.workloadTypes()
+
+	t := time.NewTicker(cfg.ReportInterval)
+	defer t.Stop()
+
+	last := time.Now()
+	for {
+		var now time.Time
+		select {
+		case now = <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		elapsed := now.Sub(last).Seconds()
+		last = now
+
+		var sb strings.Builder
+		for _, w := range types {
+			fmt.Fprintf(&sb, " %s %.0f/s", w.name, float64(w.ops.Swap(0))/elapsed)
+		}
+		out.Printf("%20s:%s\n", "worker ops", sb.String())
+		if role != nil {
+			role.SendOps(strings.TrimSpace(sb.String()))
+		}
+	}
+}
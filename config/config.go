@@ -0,0 +1,416 @@
+// Package config holds the settings of a sched-latency run, with their
+// defaults, flags and validation, so a program embedding the probes, or
+// another command, builds and checks its config the same way the CLI does.
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"sched-latency/report"
+)
+
+// DefaultPercentiles are the percentiles reported unless others are set.
+var DefaultPercentiles = []float64{0, 0.5, 0.99, 1.0}
+
+// Config is the settings of a run. Most fields are set by the flag of the
+// same name; see NewConfigFromFlags. Durations are time.Durations, and
+// percentiles and fractions are between 0 and 1.
+type Config struct {
+	// ReportInterval is how often each probe's results are reported
+	// (-report-interval), and SleepInterval how long the sleep and timer
+	// style probes wait for (-sleep-interval).
+	ReportInterval time.Duration
+	SleepInterval  time.Duration
+
+	// Percentiles are the percentiles reported for every probe. It has no
+	// flag; see WithPercentiles.
+	Percentiles []float64
+
+	// Workers is the number of CPU-bound workers (-workers), which are busy
+	// for WorkerDuty, a fraction, of every WorkerPeriod (-worker-duty as a
+	// percentage, and -worker-period). WorkloadMix, if set, is the
+	// workloads they run (-workload-mix).
+	Workers      int
+	WorkerDuty   float64
+	WorkerPeriod time.Duration
+	WorkloadMix  WorkloadMix
+
+	// Ramp is a schedule of worker counts to step through (-ramp), and
+	// LoadAfter how long to measure before starting the workers
+	// (-load-after).
+	Ramp      Ramp
+	LoadAfter time.Duration
+
+	// Experiment runs idle baseline, loaded and idle recovery phases
+	// (-experiment) for the ExperimentDurations
+	// (-experiment-durations).
+	Experiment          bool
+	ExperimentDurations ExperimentDurations
+
+	// BurstWorkers extra workers run for BurstDuration of every
+	// BurstPeriod (-burst-workers, -burst-duration and -burst-period).
+	BurstPeriod   time.Duration
+	BurstDuration time.Duration
+	BurstWorkers  int
+
+	// LoadCmd is a command run as external load (-load-cmd), and
+	// LoadProcess runs each worker in its own process (-load-process).
+	LoadCmd     string
+	LoadProcess bool
+
+	// Ballast is the size of a heap ballast in bytes (-ballast), GOGC and
+	// GOMEMLIMIT override the runtime's (-gogc and -gomemlimit, in bytes),
+	// and ForceGCEvery forces a GC that often, if positive
+	// (-force-gc-every).
+	Ballast      ByteSize
+	GOGC         GCPercent
+	GOMEMLIMIT   ByteSize
+	ForceGCEvery time.Duration
+
+	// GOMAXPROCS is set at startup (-gomaxprocs), or capped to the cgroup
+	// CPU quota with AutoMaxProcs (-auto-maxprocs).
+	GOMAXPROCS   int
+	AutoMaxProcs bool
+
+	// WorkerCPUs and ProbeCPUs are the CPUs the workers' and probes'
+	// threads are pinned to, if set (-worker-cpus and -probe-cpus).
+	WorkerCPUs CPUSet
+	ProbeCPUs  CPUSet
+
+	// Nice is the process's nice value (-nice), TimerSlack its timer slack
+	// (-timer-slack), and ProbeRTPrio and ProbeRTPolicy the real-time
+	// priority and policy of extra sleep and timer probes
+	// (-probe-rt-priority and -probe-rt-policy).
+	Nice          int
+	TimerSlack    time.Duration
+	ProbeRTPrio   int
+	ProbeRTPolicy string
+
+	// IdleTimers, IdleGoroutines and IdleConns are idle timers, parked
+	// goroutines and loopback connections created before measuring
+	// (-idle-timers, -idle-goroutines and -idle-conns), of which
+	// ActiveConns are written to every ActiveInterval (-active-conns and
+	// -active-conn-interval).
+	IdleTimers     int
+	IdleGoroutines int
+	IdleConns      int
+	ActiveConns    int
+	ActiveInterval time.Duration
+
+	// Probes are the -probes names of the probes to run, and ProbeOpts
+	// override their parameters (-probe-opt).
+	Probes    ProbeList
+	ProbeOpts ProbeOpts
+
+	// WakeupBurst runs the burst wakeup probe (-wakeup-burst) with
+	// WakeupBurstSize goroutines (-wakeup-burst-size).
+	WakeupBurst     bool
+	WakeupBurstSize int
+
+	// Duration is how long to run, or 0 for no limit (-duration), Warmup
+	// how long before samples count towards the summary (-warmup), and
+	// Samples how many samples to stop after, if positive (-samples).
+	// ReportWarmup reports the warmup's intervals (-report-warmup).
+	Duration     time.Duration
+	Warmup       time.Duration
+	Samples      int
+	ReportWarmup bool
+
+	// Accumulate is how the sleep and timer style probes keep their samples,
+	// "samples", "buckets" or "auto" (-accumulate), and BucketResolution
+	// how much wider each bucket is than the one before, as a fraction
+	// (-bucket-resolution).
+	Accumulate       string
+	BucketResolution float64
+
+	// SchedTotal also reports /sched/latencies since the start of the run
+	// (-sched-total), and StallThreshold is the heartbeat gap reported as a
+	// stall, or 0 to disable it (-stall-threshold).
+	SchedTotal     bool
+	StallThreshold time.Duration
+
+	// Label is attached to every result (-label), Instance tags them
+	// (-instance), Relative also reports percentiles as a percentage of
+	// the interval waited for (-relative), CPUBreakdown reports the
+	// process's CPU time by class (-cpu-breakdown), TailThresholds are the
+	// delays to count samples over (-tail-thresholds), and RawBuckets adds
+	// histogram buckets to JSON and CSV results (-raw-buckets).
+	Label          string
+	Instance       string
+	Relative       bool
+	CPUBreakdown   bool
+	TailThresholds DurationList
+	RawBuckets     bool
+
+	// AnomalyK flags intervals whose p99 is that many standard deviations
+	// above the recent mean, or 0 to disable it (-anomaly-k), over about
+	// AnomalyWindow (-anomaly-window). Smooth is the number of intervals
+	// of the moving averages reported, or 0 for none (-smooth).
+	AnomalyK      float64
+	AnomalyWindow time.Duration
+	Smooth        int
+
+	// Sink is the comma-separated format[:file] sinks each interval is
+	// reported to (-sink).
+	Sink string
+
+	// FailIf are assertions on the whole-run percentiles that fail the run
+	// (-fail-if). SummaryJSON is the file the summary is written to
+	// (-summary-json), Baseline a previous summary to compare against
+	// (-baseline), and FailOnRegress, as a fraction, how much worse than
+	// it fails the run (-fail-on-regression as a percentage).
+	FailIf        FailIf
+	SummaryJSON   string
+	Baseline      string
+	FailOnRegress Percent
+
+	// Record is the file every sample is streamed to (-record), and Seed
+	// seeds randomized behavior (-seed).
+	Record string
+	Seed   int64
+
+	// Pprof and Prometheus are the addresses net/http/pprof and Prometheus
+	// metrics are served on, if set (-pprof and -prometheus).
+	Pprof      string
+	Prometheus string
+
+	// TraceOnSpike and DumpOnSpike are the delays above which an execution
+	// trace or goroutine dump is captured, or 0 to disable them
+	// (-trace-on-spike and -dump-on-spike), written to TraceDir and
+	// DumpDir (-trace-dir and -dump-dir) at most once per TraceCooldown and
+	// DumpCooldown (-trace-cooldown and -dump-cooldown). Each trace runs
+	// for TraceDuration (-trace-duration).
+	TraceOnSpike  time.Duration
+	TraceDir      string
+	TraceDuration time.Duration
+	TraceCooldown time.Duration
+	DumpOnSpike   time.Duration
+	DumpDir       string
+	DumpCooldown  time.Duration
+
+	// OutlierThreshold is the delay above which samples are outliers
+	// (-outlier-threshold), which LogOutliers logs (-log-outliers).
+	OutlierThreshold time.Duration
+	LogOutliers      bool
+
+	// HeapProfileEvery is how often a heap profile is written to
+	// ProfileDir, or 0 to disable them (-heap-profile-every and
+	// -profile-dir), keeping the last HeapProfileKeep, or all of them if 0
+	// (-heap-profile-keep).
+	HeapProfileEvery time.Duration
+	HeapProfileKeep  int
+	ProfileDir       string
+
+	// OnBreachCmd is run when an interval's BreachPercentile is above
+	// BreachThreshold, at most once per BreachCooldown (-on-breach-cmd,
+	// -breach-percentile, -breach-threshold and -breach-cooldown).
+	OnBreachCmd      string
+	BreachThreshold  time.Duration
+	BreachPercentile float64
+	BreachCooldown   time.Duration
+
+	// Trace, CPUProfile, BlockProfile and MutexProfile are the files whole
+	// run profiles are written to, if set (-trace, -cpuprofile,
+	// -blockprofile and -mutexprofile), with BlockProfileRate and
+	// MutexProfileFraction as in the runtime package (-blockprofilerate
+	// and -mutexprofilefraction). Force allows a -trace without a short
+	// Duration (-force).
+	Trace                string
+	CPUProfile           string
+	BlockProfile         string
+	BlockProfileRate     int
+	MutexProfile         string
+	MutexProfileFraction int
+	Force                bool
+
+	// SkipEnvCheck skips the startup environment check (-skip-env-check),
+	// and KeepTimerRes leaves the Windows timer resolution unchanged
+	// (-keep-timer-resolution).
+	SkipEnvCheck bool
+	KeepTimerRes bool
+
+	// MonitorURL is another process's exported /sched/latencies to report
+	// (-monitor-url).
+	MonitorURL string
+
+	// Role is "measure" or "load" for one of a pair of processes
+	// (-role), which coordinate over RoleSocket (-role-socket).
+	Role       string
+	RoleSocket string
+
+	// SweepGOMAXPROCS, SweepSleep and SweepWorkers are the comma-separated
+	// values to sweep over (-sweep-gomaxprocs, -sweep-sleep and
+	// -sweep-workers), and ABGODEBUG the GODEBUG values to compare
+	// (-ab-godebug). Matrix runs every combination of them (-matrix), each
+	// for PointDuration if it's positive (-point-duration), with
+	// SweepQuiesce between points (-quiesce).
+	SweepGOMAXPROCS string
+	SweepSleep      string
+	SweepWorkers    string
+	ABGODEBUG       string
+	Matrix          bool
+	PointDuration   time.Duration
+	SweepQuiesce    time.Duration
+
+	// ConfigFile is a JSON file of flag values (-config), and Version
+	// prints the build's version and exits (-version).
+	ConfigFile string
+	Version    bool
+
+	// WorkerOnly and SweepChild are set by the hidden flags passed to the
+	// processes the CLI starts for -load-process and sweeps.
+	WorkerOnly bool
+	SweepChild bool
+
+	// Reporter, if set, is reported every interval's results, along with
+	// the sinks selected by -sink.
+	Reporter report.Sink
+}
+
+// Default returns the config used when nothing is set, which is also where
+// the flags' defaults come from.
+func Default() Config {
+	return Config{
+		Percentiles:          DefaultPercentiles,
+		WorkerDuty:           1,
+		Accumulate:           "auto",
+		BucketResolution:     0.01,
+		ReportInterval:       time.Second,
+		SleepInterval:        15 * time.Millisecond,
+		Workers:              runtime.GOMAXPROCS(0),
+		WorkerPeriod:         10 * time.Millisecond,
+		BurstPeriod:          10 * time.Second,
+		BurstDuration:        500 * time.Millisecond,
+		GOMAXPROCS:           runtime.GOMAXPROCS(0),
+		ProbeRTPolicy:        "fifo",
+		ActiveInterval:       100 * time.Millisecond,
+		WakeupBurstSize:      4 * runtime.GOMAXPROCS(0),
+		ReportWarmup:         true,
+		TraceDir:             ".",
+		TraceDuration:        5 * time.Second,
+		TraceCooldown:        time.Minute,
+		DumpDir:              ".",
+		DumpCooldown:         time.Minute,
+		OutlierThreshold:     time.Millisecond,
+		HeapProfileKeep:      10,
+		ProfileDir:           ".",
+		BreachThreshold:      5 * time.Millisecond,
+		BreachPercentile:     0.99,
+		BreachCooldown:       time.Minute,
+		SweepQuiesce:         2 * time.Second,
+		StallThreshold:       time.Second,
+		BlockProfileRate:     1,
+		MutexProfileFraction: 1,
+		Probes:               DefaultProbes(),
+		AnomalyK:             3,
+		AnomalyWindow:        5 * time.Minute,
+		CPUBreakdown:         true,
+		RoleSocket:           defaultRoleSocket(),
+		ExperimentDurations:  ExperimentDurations{30 * time.Second, 60 * time.Second, 30 * time.Second},
+		Sink:                 "text",
+	}
+}
+
+// defaultRoleSocket returns the unix socket -role processes coordinate on
+// by default.
+func defaultRoleSocket() string {
+	return filepath.Join(os.TempDir(), "sched-latency.sock")
+}
+
+// HeartbeatInterval is how often the stall watchdog's heartbeat ticks.
+const HeartbeatInterval = 100 * time.Millisecond
+
+// MaxRunTrace is the longest -duration a -trace is allowed for without
+// -force, since traces grow by megabytes per second.
+const MaxRunTrace = 10 * time.Minute
+
+// Option sets part of a Config built by NewConfig.
+type Option func(c *Config)
+
+// NewConfig returns the default config with the options applied, and an
+// error if the result is inconsistent, e.g., if the warmup is longer than
+// the duration. The warnings are for valid, but likely mistaken, configs.
+//
+// The CLI builds its config the same way, from the flags, so a config built
+// in code is defaulted and validated exactly as a run from the flags is.
+func NewConfig(opts ...Option) (Config, []string, error) {
+	c := Default()
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if err := c.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return c, c.Warnings(), nil
+}
+
+// WithFlags sets the config to one parsed from the flags registered by
+// NewConfigFromFlags, which start out with the default config's values.
+func WithFlags(parsed Config) Option {
+	return func(c *Config) { *c = parsed }
+}
+
+// WithReportInterval sets how often each probe's interval is reported.
+func WithReportInterval(d time.Duration) Option {
+	return func(c *Config) { c.ReportInterval = d }
+}
+
+// WithSleepInterval sets how long the sleep and timer probes wait for.
+func WithSleepInterval(d time.Duration) Option {
+	return func(c *Config) { c.SleepInterval = d }
+}
+
+// WithPercentiles sets the percentiles, between 0 and 1, that are reported.
+func WithPercentiles(percentiles ...float64) Option {
+	return func(c *Config) { c.Percentiles = percentiles }
+}
+
+// WithProbes sets the probes to run, by their names in ProbeNames, or
+// "all" for every probe available on this platform.
+func WithProbes(names ...string) Option {
+	return func(c *Config) { c.Probes = ProbeList(names) }
+}
+
+// WithReporter reports every interval's results to sink, as well as to the
+// -sink sinks.
+func WithReporter(sink report.Sink) Option {
+	return func(c *Config) { c.Reporter = sink }
+}
+
+// WithWakeupBurst enables the burst wakeup probe, releasing size goroutines
+// at a time.
+func WithWakeupBurst(size int) Option {
+	return func(c *Config) {
+		c.WakeupBurst = true
+		c.WakeupBurstSize = size
+	}
+}
+
+// WithWorkers sets the number of CPU-bound workers loading the process.
+func WithWorkers(n int) Option {
+	return func(c *Config) { c.Workers = n }
+}
+
+// WithDuration sets how long the run lasts, or 0 to run until stopped.
+func WithDuration(d time.Duration) Option {
+	return func(c *Config) { c.Duration = d }
+}
+
+// WithWarmup sets how long samples are excluded from the summary for at the
+// start of the run.
+func WithWarmup(d time.Duration) Option {
+	return func(c *Config) { c.Warmup = d }
+}
+
+// WithBreachCmd runs the command whenever an interval's percentile p is
+// above threshold.
+func WithBreachCmd(cmd string, p float64, threshold time.Duration) Option {
+	return func(c *Config) {
+		c.OnBreachCmd = cmd
+		c.BreachPercentile = p
+		c.BreachThreshold = threshold
+	}
+}
@@ -0,0 +1,243 @@
+package config
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"sched-latency/report"
+)
+
+func TestNewConfigDefaults(t *testing.T) {
+	c, warnings, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() failed: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("NewConfig() warnings = %v, want none", warnings)
+	}
+
+	if c.ReportInterval != time.Second {
+		t.Errorf("ReportInterval = %v, want 1s", c.ReportInterval)
+	}
+	if c.SleepInterval != 15*time.Millisecond {
+		t.Errorf("SleepInterval = %v, want 15ms", c.SleepInterval)
+	}
+	if c.Workers != runtime.GOMAXPROCS(0) {
+		t.Errorf("Workers = %v, want GOMAXPROCS %v", c.Workers, runtime.GOMAXPROCS(0))
+	}
+	if got, want := c.Probes.String(), DefaultProbes().String(); got != want {
+		t.Errorf("Probes = %v, want %v", got, want)
+	}
+	if len(c.Percentiles) != len(DefaultPercentiles) {
+		t.Errorf("Percentiles = %v, want %v", c.Percentiles, DefaultPercentiles)
+	}
+	if c.Accumulate != "auto" || c.BucketResolution != 0.01 {
+		t.Errorf("Accumulate = %q with resolution %v, want auto with 0.01", c.Accumulate, c.BucketResolution)
+	}
+	if c.Reporter != nil {
+		t.Errorf("Reporter = %v, want none", c.Reporter)
+	}
+}
+
+// discard is a sink that drops every result.
+type discard struct{}
+
+func (discard) Report(report.Result) error { return nil }
+
+func TestNewConfigOptions(t *testing.T) {
+	c, _, err := NewConfig(
+		WithReportInterval(5*time.Second),
+		WithSleepInterval(time.Millisecond),
+		WithPercentiles(0.5, 0.999),
+		WithProbes("sleep", "sched"),
+		WithReporter(discard{}),
+		WithWakeupBurst(8),
+		WithWorkers(2),
+		WithDuration(time.Minute),
+		WithWarmup(10*time.Second),
+		WithBreachCmd("true", 0.999, 3*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewConfig failed: %v", err)
+	}
+
+	if c.ReportInterval != 5*time.Second || c.SleepInterval != time.Millisecond {
+		t.Errorf("intervals = %v, %v, want 5s, 1ms", c.ReportInterval, c.SleepInterval)
+	}
+	if len(c.Percentiles) != 2 || c.Percentiles[1] != 0.999 {
+		t.Errorf("Percentiles = %v, want [0.5 0.999]", c.Percentiles)
+	}
+	if got := c.Probes.String(); got != "sleep,sched" {
+		t.Errorf("Probes = %v, want sleep,sched", got)
+	}
+	if c.Reporter == nil {
+		t.Errorf("Reporter wasn't set")
+	}
+	if !c.WakeupBurst || c.WakeupBurstSize != 8 || !c.ProbeEnabled("wakeup-burst") {
+		t.Errorf("wakeup burst = %v with size %v, want enabled with size 8", c.WakeupBurst, c.WakeupBurstSize)
+	}
+	if c.ProbeEnabled("timer") {
+		t.Errorf("timer probe is enabled, want only sleep and sched")
+	}
+	if c.Workers != 2 || c.Duration != time.Minute || c.Warmup != 10*time.Second {
+		t.Errorf("workers, duration, warmup = %v, %v, %v, want 2, 1m, 10s", c.Workers, c.Duration, c.Warmup)
+	}
+	if c.OnBreachCmd != "true" || c.BreachPercentile != 0.999 || c.BreachThreshold != 3*time.Millisecond {
+		t.Errorf("breach = %q at p%v over %v, want true at p0.999 over 3ms", c.OnBreachCmd, c.BreachPercentile, c.BreachThreshold)
+	}
+}
+
+func TestNewConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr string
+	}{
+		{
+			name:    "warmup longer than duration",
+			opts:    []Option{WithDuration(time.Second), WithWarmup(2 * time.Second)},
+			wantErr: "-warmup (2s) must be shorter than -duration (1s)",
+		},
+		{
+			name:    "warmup as long as duration",
+			opts:    []Option{WithDuration(time.Second), WithWarmup(time.Second)},
+			wantErr: "-warmup (1s) must be shorter",
+		},
+		{
+			name:    "zero report interval",
+			opts:    []Option{WithReportInterval(0)},
+			wantErr: "-report-interval must be positive",
+		},
+		{
+			name:    "negative sleep interval",
+			opts:    []Option{WithSleepInterval(-time.Millisecond)},
+			wantErr: "-sleep-interval must be positive",
+		},
+		{
+			name:    "zero GOMAXPROCS",
+			opts:    []Option{func(c *Config) { c.GOMAXPROCS = 0 }},
+			wantErr: "-gomaxprocs must be positive, got 0",
+		},
+		{
+			name:    "negative workers",
+			opts:    []Option{WithWorkers(-1)},
+			wantErr: "-workers must not be negative",
+		},
+		{
+			name:    "negative duration",
+			opts:    []Option{WithDuration(-time.Second)},
+			wantErr: "-duration must not be negative",
+		},
+		{
+			name:    "percentile above 1",
+			opts:    []Option{WithPercentiles(0.5, 99)},
+			wantErr: "percentiles must be between 0 and 1, got 99",
+		},
+		{
+			name:    "no probes",
+			opts:    []Option{WithProbes()},
+			wantErr: "-probes must select at least one probe",
+		},
+		{
+			name:    "empty wakeup burst",
+			opts:    []Option{WithWakeupBurst(0)},
+			wantErr: "-wakeup-burst-size must be positive",
+		},
+		{
+			name:    "unreported breach percentile",
+			opts:    []Option{WithBreachCmd("true", 0.9, time.Millisecond)},
+			wantErr: "-breach-percentile must be one of the reported percentiles",
+		},
+		{
+			name:    "bad role",
+			opts:    []Option{func(c *Config) { c.Role = "both" }},
+			wantErr: `-role must be "measure" or "load"`,
+		},
+		{
+			name:    "bad monitor URL",
+			opts:    []Option{func(c *Config) { c.MonitorURL = "localhost:6060" }},
+			wantErr: "-monitor-url must be an http or https URL",
+		},
+		{
+			name:    "short stall threshold",
+			opts:    []Option{func(c *Config) { c.StallThreshold = HeartbeatInterval }},
+			wantErr: "-stall-threshold must be 0 or at least",
+		},
+		{
+			name:    "long trace",
+			opts:    []Option{func(c *Config) { c.Trace = "trace.out" }},
+			wantErr: "-trace needs a -duration of at most",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := NewConfig(tt.opts...)
+			if err == nil {
+				t.Fatalf("NewConfig succeeded, want error %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewConfig error = %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewConfigForcedTrace(t *testing.T) {
+	_, _, err := NewConfig(func(c *Config) {
+		c.Trace = "trace.out"
+		c.Force = true
+	})
+	if err != nil {
+		t.Errorf("NewConfig with -trace and -force failed: %v", err)
+	}
+}
+
+func TestNewConfigUnavailableProbe(t *testing.T) {
+	for _, name := range ProbeNames {
+		unavailable := ProbeUnavailable(name)
+		_, _, err := NewConfig(WithProbes(name))
+		if unavailable == nil && err != nil {
+			t.Errorf("NewConfig(WithProbes(%q)) failed: %v", name, err)
+		}
+		if unavailable != nil && err == nil {
+			t.Errorf("NewConfig(WithProbes(%q)) succeeded, but it's unavailable: %v", name, unavailable)
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{
+			name: "sleep interval not shorter than report interval",
+			opts: []Option{WithSleepInterval(time.Second)},
+			want: "-sleep-interval (1s) is not shorter than -report-interval (1s)",
+		},
+		{
+			name: "spin probe without workers",
+			opts: []Option{WithProbes("spin"), WithWorkers(0)},
+			want: "the spin wait probe keeps a CPU busy",
+		},
+		{
+			name: "alloc probe without allocation load",
+			opts: []Option{WithProbes("alloc")},
+			want: "the alloc latency probe mostly sees GC assist stalls",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, warnings, err := NewConfig(tt.opts...)
+			if err != nil {
+				t.Fatalf("NewConfig failed: %v", err)
+			}
+			if len(warnings) != 1 || !strings.Contains(warnings[0], tt.want) {
+				t.Errorf("warnings = %q, want one containing %q", warnings, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbeAliases are the short probe names accepted by -fail-if, by the full
+// names the probes are reported as.
+var ProbeAliases = probeAliases()
+
+// probeAliases returns the -probes name of each probe available on this
+// platform as its alias, with the sleep and timer probes' -rt aliases for
+// their copies run by -probe-rt-priority.
+func probeAliases() map[string]string {
+	// wakeup predates the probe's -probes name.
+	aliases := map[string]string{"wakeup": ReportedName("wakeup-burst")}
+	for _, name := range ProbeNames {
+		if full := ReportedName(name); full != "" {
+			aliases[name] = full
+		}
+	}
+	for _, name := range []string{"sleep", "timer"} {
+		aliases[name+"-rt"] = aliases[name] + " (rt)"
+	}
+	return aliases
+}
+
+// FailIf is a flag.Value for a comma-separated list of assertions on the
+// whole-run percentiles, such as "sleep.p99>2ms,sched.max>10ms".
+type FailIf []FailAssertion
+
+// FailAssertion fails the run if the probe's percentile is above Limit.
+type FailAssertion struct {
+	Spec       string
+	Probe      string
+	Percentile float64
+	Limit      time.Duration
+}
+
+func (f FailIf) String() string {
+	specs := make([]string, len(f))
+	for i, a := range f {
+		specs[i] = a.Spec
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f *FailIf) Set(v string) error {
+	var assertions FailIf
+	for _, spec := range strings.Split(v, ",") {
+		a, err := parseFailAssertion(strings.TrimSpace(spec))
+		if err != nil {
+			return err
+		}
+		assertions = append(assertions, a)
+	}
+	*f = assertions
+	return nil
+}
+
+// parseFailAssertion parses a single "probe.percentile>limit" assertion,
+// where probe is an alias or full probe name, and percentile is min, max or
+// pN for the Nth percentile (e.g., p99.9).
+func parseFailAssertion(spec string) (FailAssertion, error) {
+	lhs, limitStr, ok := strings.Cut(spec, ">")
+	if !ok {
+		return FailAssertion{}, fmt.Errorf("assertion %q must be probe.percentile>duration", spec)
+	}
+	// Both probe names and percentiles may contain dots, so split on the
+	// first dot that ends a known probe.
+	var probe, pStr string
+	for i := 0; i < len(lhs); i++ {
+		if lhs[i] != '.' {
+			continue
+		}
+		if full, ok := ProbeAliases[lhs[:i]]; ok {
+			probe, pStr = full, lhs[i+1:]
+			break
+		}
+		if isProbeName(lhs[:i]) {
+			probe, pStr = lhs[:i], lhs[i+1:]
+			break
+		}
+	}
+	if probe == "" {
+		return FailAssertion{}, fmt.Errorf("unknown probe in %q, expected one of %v", spec, probeAliasNames())
+	}
+
+	var p float64
+	switch {
+	case pStr == "min":
+		p = 0
+	case pStr == "max":
+		p = 1
+	case strings.HasPrefix(pStr, "p"):
+		v, err := strconv.ParseFloat(pStr[1:], 64)
+		if err != nil || v < 0 || v > 100 {
+			return FailAssertion{}, fmt.Errorf("invalid percentile %q in %q", pStr, spec)
+		}
+		p = v / 100
+	default:
+		return FailAssertion{}, fmt.Errorf("invalid percentile %q in %q, expected min, max or pN", pStr, spec)
+	}
+
+	limit, err := time.ParseDuration(limitStr)
+	if err != nil {
+		return FailAssertion{}, fmt.Errorf("invalid limit in %q: %v", spec, err)
+	}
+	return FailAssertion{Spec: spec, Probe: probe, Percentile: p, Limit: limit}, nil
+}
+
+func isProbeName(name string) bool {
+	for _, full := range ProbeAliases {
+		if full == name {
+			return true
+		}
+	}
+	return false
+}
+
+func probeAliasNames() []string {
+	names := make([]string, 0, len(ProbeAliases))
+	for alias := range ProbeAliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"flag"
+	"strings"
+)
+
+// Flags that aren't part of a run's config: the config file, which can't
+// itself be set in one, and the internal flags the CLI passes to the
+// processes it starts.
+const (
+	ConfigFileFlag = "config"
+	WorkerOnlyFlag = "worker-only"
+	SweepChildFlag = "sweep-child"
+)
+
+// NewConfigFromFlags registers every flag of a run on fs, each setting a
+// field of the returned config and defaulting to the default config's
+// value. The config holds the flags' values once fs is parsed; NewConfig
+// validates it.
+func NewConfigFromFlags(fs *flag.FlagSet) *Config {
+	cfg := Default()
+	fs.DurationVar(&cfg.ReportInterval, "report-interval", cfg.ReportInterval, "How often to report delay measurements")
+	fs.DurationVar(&cfg.SleepInterval, "sleep-interval", cfg.SleepInterval, "How long to sleep to measure delay")
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "Number of CPU-bound workers (defaults to GOMAXPROCS")
+	fs.Var((*Percent)(&cfg.WorkerDuty), "worker-duty", "Percentage of each worker period spent burning CPU")
+	fs.DurationVar(&cfg.WorkerPeriod, "worker-period", cfg.WorkerPeriod, "Period over which -worker-duty is applied")
+	fs.DurationVar(&cfg.BurstPeriod, "burst-period", cfg.BurstPeriod, "How often to run a burst of -burst-workers")
+	fs.DurationVar(&cfg.BurstDuration, "burst-duration", cfg.BurstDuration, "How long each burst of -burst-workers lasts")
+	fs.IntVar(&cfg.BurstWorkers, "burst-workers", cfg.BurstWorkers, "Number of extra CPU-bound workers to run during each burst")
+	fs.DurationVar(&cfg.LoadAfter, "load-after", cfg.LoadAfter, "How long to measure without load before starting the workers")
+	fs.StringVar(&cfg.LoadCmd, "load-cmd", cfg.LoadCmd, "Command to run as external load alongside the workers, split on whitespace (use -workers=0 to only run the command)")
+	fs.BoolVar(&cfg.LoadProcess, "load-process", cfg.LoadProcess, "Run each worker in a separate process rather than in this process")
+	fs.Var(&cfg.Ballast, "ballast", "Size of a heap ballast to allocate before measuring, e.g. 4GiB")
+	fs.Var(&cfg.GOGC, "gogc", `GC target percentage to set at startup, or "off" (defaults to GOGC)`)
+	fs.Var(&cfg.GOMEMLIMIT, "gomemlimit", "Soft memory limit to set at startup, e.g. 2GiB (defaults to GOMEMLIMIT)")
+	fs.DurationVar(&cfg.ForceGCEvery, "force-gc-every", cfg.ForceGCEvery, "How often to force a GC with runtime.GC (0 disables forced GCs)")
+	fs.IntVar(&cfg.GOMAXPROCS, "gomaxprocs", cfg.GOMAXPROCS, "GOMAXPROCS to set at startup (defaults to GOMAXPROCS)")
+	fs.BoolVar(&cfg.AutoMaxProcs, "auto-maxprocs", cfg.AutoMaxProcs, "Cap GOMAXPROCS to the cgroup CPU quota")
+	fs.Var(&cfg.WorkerCPUs, "worker-cpus", "CPUs to pin worker threads to, e.g. 2-7 (Linux only)")
+	fs.Var(&cfg.ProbeCPUs, "probe-cpus", "CPUs to pin probe threads to, e.g. 0-1 (Linux only)")
+	fs.IntVar(&cfg.Nice, "nice", cfg.Nice, "Nice value to set for the process at startup (lowering it requires privileges)")
+	fs.IntVar(&cfg.ProbeRTPrio, "probe-rt-priority", cfg.ProbeRTPrio, "Also run the sleep and timer probes on real-time threads with this priority, 1-99 (Linux only)")
+	fs.StringVar(&cfg.ProbeRTPolicy, "probe-rt-policy", cfg.ProbeRTPolicy, `Real-time scheduling policy for -probe-rt-priority, "fifo" or "rr"`)
+	fs.Var(&cfg.WorkloadMix, "workload-mix", "Mix of workloads to run as type:count pairs, e.g. json:2,spin:4,alloc:2 (types: json, spin, alloc)")
+	fs.DurationVar(&cfg.TimerSlack, "timer-slack", cfg.TimerSlack, "Timer slack to set for the process, 0 for the minimum of 1ns (Linux only, defaults to unchanged)")
+	fs.IntVar(&cfg.IdleTimers, "idle-timers", cfg.IdleTimers, "Number of idle long-duration timers to create before measuring")
+	fs.IntVar(&cfg.IdleGoroutines, "idle-goroutines", cfg.IdleGoroutines, "Number of parked goroutines to create before measuring")
+	fs.IntVar(&cfg.IdleConns, "idle-conns", cfg.IdleConns, "Number of idle loopback TCP connections to hold open in the netpoller")
+	fs.IntVar(&cfg.ActiveConns, "active-conns", cfg.ActiveConns, "Number of the -idle-conns to write a byte to every -active-conn-interval")
+	fs.DurationVar(&cfg.ActiveInterval, "active-conn-interval", cfg.ActiveInterval, "How often to write to each of the -active-conns")
+	fs.Var(&cfg.Probes, "probes", "Comma-separated probes to run, or all for every probe available on this platform: "+strings.Join(ProbeNames, ", "))
+	fs.Var(&cfg.ProbeOpts, "probe-opt", "Override a probe's parameter as probe.key=value, e.g. sleep.interval=1ms (repeatable; keys: interval, size for wakeup-burst and alloc, and guard for sleep-spin)")
+	fs.BoolVar(&cfg.WakeupBurst, "wakeup-burst", cfg.WakeupBurst, "Run the probe measuring how long a burst of runnable goroutines takes to all run")
+	fs.IntVar(&cfg.WakeupBurstSize, "wakeup-burst-size", cfg.WakeupBurstSize, "Number of goroutines released together by -wakeup-burst (defaults to 4*GOMAXPROCS)")
+	fs.DurationVar(&cfg.Warmup, "warmup", cfg.Warmup, "How long to run before samples count towards the summary")
+	fs.BoolVar(&cfg.ReportWarmup, "report-warmup", cfg.ReportWarmup, "Print interval reports during the warmup, marked (warmup)")
+	fs.Var(&cfg.FailIf, "fail-if", `Comma-separated assertions on the whole-run percentiles that fail the run with exit code 1, e.g. "sleep.p99>2ms,sched.max>10ms"`)
+	fs.StringVar(&cfg.SummaryJSON, "summary-json", cfg.SummaryJSON, "File to write the end-of-run summary to as JSON")
+	fs.StringVar(&cfg.Pprof, "pprof", cfg.Pprof, "Address to serve net/http/pprof on, e.g. :6060 (disabled by default)")
+	fs.StringVar(&cfg.Prometheus, "prometheus", cfg.Prometheus, "Address to serve Prometheus metrics on at /metrics, e.g. :9090, with /sched/latencies as a histogram and the other probes' percentiles as gauges (disabled by default)")
+	fs.DurationVar(&cfg.TraceOnSpike, "trace-on-spike", cfg.TraceOnSpike, "Capture an execution trace when any probe's sample is above this delay (0 disables traces)")
+	fs.StringVar(&cfg.TraceDir, "trace-dir", cfg.TraceDir, "Directory to write -trace-on-spike traces to")
+	fs.DurationVar(&cfg.TraceDuration, "trace-duration", cfg.TraceDuration, "How long each -trace-on-spike trace runs for after the spike")
+	fs.DurationVar(&cfg.TraceCooldown, "trace-cooldown", cfg.TraceCooldown, "Minimum time between the starts of -trace-on-spike traces")
+	fs.DurationVar(&cfg.DumpOnSpike, "dump-on-spike", cfg.DumpOnSpike, "Write a dump of every goroutine's stack when any probe's sample is above this delay (0 disables dumps)")
+	fs.StringVar(&cfg.DumpDir, "dump-dir", cfg.DumpDir, "Directory to write -dump-on-spike goroutine dumps to")
+	fs.DurationVar(&cfg.DumpCooldown, "dump-cooldown", cfg.DumpCooldown, "Minimum time between -dump-on-spike goroutine dumps")
+	fs.DurationVar(&cfg.OutlierThreshold, "outlier-threshold", cfg.OutlierThreshold, "Delay above which samples are outliers, which are logged to execution traces")
+	fs.BoolVar(&cfg.LogOutliers, "log-outliers", cfg.LogOutliers, "Log a line to stderr for each sample above -outlier-threshold, with the goroutine, GC, heap and thread counts")
+	fs.DurationVar(&cfg.HeapProfileEvery, "heap-profile-every", cfg.HeapProfileEvery, "How often to write a heap profile to -profile-dir, also writing one at the end of the run (0 disables heap profiles)")
+	fs.IntVar(&cfg.HeapProfileKeep, "heap-profile-keep", cfg.HeapProfileKeep, "Number of the most recent -heap-profile-every profiles to keep (0 keeps all of them)")
+	fs.StringVar(&cfg.ProfileDir, "profile-dir", cfg.ProfileDir, "Directory to write -heap-profile-every profiles to")
+	fs.StringVar(&cfg.OnBreachCmd, "on-breach-cmd", cfg.OnBreachCmd, "Command to run when an interval's -breach-percentile is above -breach-threshold, split on whitespace, with the interval's report as JSON on stdin")
+	fs.DurationVar(&cfg.BreachThreshold, "breach-threshold", cfg.BreachThreshold, "Delay above which an interval runs the -on-breach-cmd")
+	fs.Float64Var(&cfg.BreachPercentile, "breach-percentile", cfg.BreachPercentile, "Percentile compared against -breach-threshold, one of the reported percentiles")
+	fs.DurationVar(&cfg.BreachCooldown, "breach-cooldown", cfg.BreachCooldown, "Minimum time between runs of the -on-breach-cmd")
+	fs.Int64Var(&cfg.Seed, "seed", cfg.Seed, "Seed for randomized behavior, to reproduce a run (defaults to one derived from the time)")
+	fs.StringVar(&cfg.Record, "record", cfg.Record, "File to stream every sleep and timer style sample to, for offline analysis")
+	fs.StringVar(&cfg.Baseline, "baseline", cfg.Baseline, "Summary JSON from a previous run to compare against at the end of the run")
+	fs.Var(&cfg.FailOnRegress, "fail-on-regression", "Fail the run with exit code 1 if any percentile is worse than the -baseline by more than this percentage, e.g. 20%")
+	fs.IntVar(&cfg.Samples, "samples", cfg.Samples, "Stop once every sleep and timer style probe has this many samples after the warmup (0 disables)")
+	fs.DurationVar(&cfg.Duration, "duration", cfg.Duration, "How long to run before printing a summary and exiting (0 runs until the load schedule ends, or forever)")
+	fs.DurationVar(&cfg.SweepQuiesce, "quiesce", cfg.SweepQuiesce, "How long to wait between sweep points")
+	fs.StringVar(&cfg.Accumulate, "accumulate", cfg.Accumulate, `How the sleep and timer style probes accumulate each interval's samples: "samples" keeps every sample, "buckets" counts them in log-spaced buckets, and "auto" uses buckets when an interval could have more than 100000 samples`)
+	fs.Var((*Percent)(&cfg.BucketResolution), "bucket-resolution", "How much wider each of the -accumulate buckets is than the one before, bounding the error of their percentiles")
+	fs.BoolVar(&cfg.SchedTotal, "sched-total", cfg.SchedTotal, "Also report the /sched/latencies percentiles since the start of the run, or the end of the warmup, on each interval")
+	fs.DurationVar(&cfg.StallThreshold, "stall-threshold", cfg.StallThreshold, "Gap in a 100ms heartbeat above which the whole process is reported as stalled, e.g., by a VM pause (0 disables)")
+	fs.BoolVar(&cfg.SkipEnvCheck, "skip-env-check", cfg.SkipEnvCheck, "Skip the startup check for virtualization, a powersave cpufreq governor, a battery and a noisy CPU")
+	fs.BoolVar(&cfg.KeepTimerRes, "keep-timer-resolution", cfg.KeepTimerRes, "On Windows, don't raise the system timer resolution to 1ms for the run, since it affects the whole system")
+	fs.StringVar(&cfg.Trace, "trace", cfg.Trace, "File to write an execution trace of the whole run to, from the end of the warmup")
+	fs.BoolVar(&cfg.Force, "force", cfg.Force, "Allow combinations that are refused for producing huge output, e.g., -trace without a short -duration")
+	fs.StringVar(&cfg.CPUProfile, "cpuprofile", cfg.CPUProfile, "File to write a CPU profile of the whole run to, from the end of the warmup")
+	fs.StringVar(&cfg.BlockProfile, "blockprofile", cfg.BlockProfile, "File to write a goroutine blocking profile of the whole run to at exit")
+	fs.IntVar(&cfg.BlockProfileRate, "blockprofilerate", cfg.BlockProfileRate, "Rate for -blockprofile, as in runtime.SetBlockProfileRate: one sample per this many nanoseconds blocked")
+	fs.StringVar(&cfg.MutexProfile, "mutexprofile", cfg.MutexProfile, "File to write a mutex contention profile of the whole run to at exit")
+	fs.IntVar(&cfg.MutexProfileFraction, "mutexprofilefraction", cfg.MutexProfileFraction, "Fraction for -mutexprofile, as in runtime.SetMutexProfileFraction: sample 1 in this many contention events")
+	fs.Float64Var(&cfg.AnomalyK, "anomaly-k", cfg.AnomalyK, "Flag intervals whose p99 is more than this many standard deviations above the probe's recent mean (0 disables)")
+	fs.DurationVar(&cfg.AnomalyWindow, "anomaly-window", cfg.AnomalyWindow, "Roughly how far back the recent mean and standard deviation for -anomaly-k go")
+	fs.IntVar(&cfg.Smooth, "smooth", cfg.Smooth, "Also report an exponentially weighted moving average of each percentile over about this many intervals, restarting each phase (0 disables)")
+	fs.StringVar(&cfg.Label, "label", cfg.Label, "Label for the run, e.g. \"go1.22-8workers\", attached to every result, the summary, the recording and the names of captured files")
+	fs.StringVar(&cfg.Instance, "instance", cfg.Instance, "Identifier for this instance, e.g. a pod name or region, tagged on every result along with the hostname and PID")
+	fs.BoolVar(&cfg.Relative, "relative", cfg.Relative, "Also report each percentile of the sleep and timer style probes as a percentage of the interval they wait for")
+	fs.StringVar(&cfg.MonitorURL, "monitor-url", cfg.MonitorURL, "URL of another Go process's /sched/latencies histogram, as served by the sched-latency/export package, to report alongside or, by default, instead of the local probes and workers")
+	fs.StringVar(&cfg.Role, "role", cfg.Role, `Run as one of a pair of processes coordinated over -role-socket: "measure" runs only the probes, and "load" only the workers, starting when the measure process does`)
+	fs.StringVar(&cfg.RoleSocket, "role-socket", cfg.RoleSocket, "Unix socket the -role processes coordinate on")
+	fs.Var(&cfg.TailThresholds, "tail-thresholds", "Comma-separated thresholds to count each probe's samples over per interval and for the run, e.g. 1ms,10ms,100ms")
+	fs.BoolVar(&cfg.RawBuckets, "raw-buckets", cfg.RawBuckets, "In JSON and CSV output, also report the non-empty buckets of each interval of the probes reading a histogram, such as /sched/latencies, e.g., for plotting the whole distribution")
+	fs.BoolVar(&cfg.CPUBreakdown, "cpu-breakdown", cfg.CPUBreakdown, "Report how the process's CPU time was spent each interval (user code, GC, scavenger, idle), from the runtime's /cpu/classes metrics")
+	fs.Var(&cfg.Ramp, "ramp", "Schedule of workers:duration steps to run, e.g. 0:30s,2:30s (overrides -workers)")
+	fs.BoolVar(&cfg.Experiment, "experiment", cfg.Experiment, "Run idle baseline, loaded and idle recovery phases, then print a comparison and exit")
+	fs.Var(&cfg.ExperimentDurations, "experiment-durations", "Durations of the -experiment baseline, loaded and recovery phases")
+	fs.StringVar(&cfg.Sink, "sink", cfg.Sink, "Comma-separated sinks to report each interval to as format[:file], e.g. text,json:intervals.jsonl (formats: text, json, csv; no file writes to stdout)")
+	fs.StringVar(&cfg.SweepGOMAXPROCS, "sweep-gomaxprocs", cfg.SweepGOMAXPROCS, "Comma-separated GOMAXPROCS values to run the measurement with for -duration each, then print a table comparing them")
+	fs.StringVar(&cfg.SweepSleep, "sweep-sleep", cfg.SweepSleep, "Comma-separated sleep intervals to run the measurement with for -duration each, then print a table comparing them")
+	fs.StringVar(&cfg.SweepWorkers, "sweep-workers", cfg.SweepWorkers, "Comma-separated numbers of workers to run the measurement with for -duration each, then print a table comparing them")
+	fs.BoolVar(&cfg.Matrix, "matrix", cfg.Matrix, "Run every combination of the -sweep-* values, rather than allowing only one sweep")
+	fs.DurationVar(&cfg.PointDuration, "point-duration", cfg.PointDuration, "How long to run each sweep point for (defaults to -duration)")
+	fs.StringVar(&cfg.ABGODEBUG, "ab-godebug", cfg.ABGODEBUG, `Comma-separated GODEBUG values to run the measurement with for -duration each, then print side by side, e.g. '"",asyncpreemptoff=1'`)
+	fs.StringVar(&cfg.ConfigFile, ConfigFileFlag, cfg.ConfigFile, `JSON file of flag values to run with, e.g. {"sleep-interval": "1ms"}, overridden by flags on the command line`)
+	fs.BoolVar(&cfg.Version, "version", cfg.Version, "Print the build's version, VCS revision and Go version, then exit")
+	fs.BoolVar(&cfg.WorkerOnly, WorkerOnlyFlag, cfg.WorkerOnly, "")
+	fs.BoolVar(&cfg.SweepChild, SweepChildFlag, cfg.SweepChild, "")
+	return &cfg
+}
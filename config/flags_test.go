@@ -0,0 +1,208 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// parseFlags parses args with a fresh flag set from NewConfigFromFlags.
+func parseFlags(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg := NewConfigFromFlags(fs)
+	return cfg, fs.Parse(args)
+}
+
+func TestNewConfigFromFlagsDefaults(t *testing.T) {
+	cfg, err := parseFlags(t)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if got, want := *cfg, Default(); !reflect.DeepEqual(got, want) {
+		t.Errorf("config with no flags = %+v, want defaults %+v", got, want)
+	}
+}
+
+func TestNewConfigFromFlagsRegistered(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	NewConfigFromFlags(fs)
+
+	// The flags the CLI relies on being part of the config, rather than
+	// registering itself.
+	for _, name := range []string{
+		"ramp", "sink", "experiment", "experiment-durations", ConfigFileFlag, "version",
+		"sweep-gomaxprocs", "sweep-sleep", "sweep-workers", "matrix", "point-duration",
+		"ab-godebug", WorkerOnlyFlag, SweepChildFlag,
+	} {
+		if fs.Lookup(name) == nil {
+			t.Errorf("flag -%v is not registered", name)
+		}
+	}
+}
+
+func TestNewConfigFromFlags(t *testing.T) {
+	cfg, err := parseFlags(t,
+		"-report-interval=5s",
+		"-sleep-interval=1ms",
+		"-workers=3",
+		"-worker-duty=25",
+		"-ballast=4GiB",
+		"-gogc=off",
+		"-gomemlimit=512MiB",
+		"-worker-cpus=0,2-4",
+		"-workload-mix=json:1,spin:2",
+		"-probes=sleep,sched",
+		"-probe-opt=sleep.interval=2ms",
+		"-probe-opt=alloc.size=64",
+		"-fail-if=sleep.p99>2ms",
+		"-tail-thresholds=1ms,10ms",
+		"-ramp=0:30s,2:1m",
+		"-experiment",
+		"-experiment-durations=1s,2s,3s",
+		"-sink=json",
+		"-sweep-workers=1,2",
+		"-matrix",
+		"-point-duration=10s",
+		"-ab-godebug=,asyncpreemptoff=1",
+		"-config=run.json",
+		"-version",
+		"-"+WorkerOnlyFlag,
+		"-"+SweepChildFlag,
+	)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	if cfg.ReportInterval != 5*time.Second || cfg.SleepInterval != time.Millisecond {
+		t.Errorf("intervals = %v, %v, want 5s, 1ms", cfg.ReportInterval, cfg.SleepInterval)
+	}
+	if cfg.Workers != 3 || cfg.WorkerDuty != 0.25 {
+		t.Errorf("Workers = %v at duty %v, want 3 at 0.25", cfg.Workers, cfg.WorkerDuty)
+	}
+	if cfg.Ballast != 4<<30 || cfg.GOMEMLIMIT != 512<<20 {
+		t.Errorf("Ballast = %v, GOMEMLIMIT = %v, want 4GiB, 512MiB", cfg.Ballast, cfg.GOMEMLIMIT)
+	}
+	if percent, ok := cfg.GOGC.Percent(); !ok || percent != -1 {
+		t.Errorf("GOGC = %v, want off", cfg.GOGC)
+	}
+	if want := (CPUSet{0, 2, 3, 4}); !reflect.DeepEqual(cfg.WorkerCPUs, want) {
+		t.Errorf("WorkerCPUs = %v, want %v", cfg.WorkerCPUs, want)
+	}
+	if cfg.WorkloadMix.Workers() != 3 || !cfg.WorkloadMix.Has("spin") {
+		t.Errorf("WorkloadMix = %v, want json:1,spin:2", cfg.WorkloadMix)
+	}
+	if got := cfg.Probes.String(); got != "sleep,sched" {
+		t.Errorf("Probes = %v, want sleep,sched", got)
+	}
+	if got := cfg.ProbeInterval("sleep"); got != 2*time.Millisecond {
+		t.Errorf("sleep interval = %v, want 2ms", got)
+	}
+	if got := cfg.AllocBurst(); got != 64 {
+		t.Errorf("alloc size = %v, want 64", got)
+	}
+	if want := (FailIf{{Spec: "sleep.p99>2ms", Probe: ProbeAliases["sleep"], Percentile: 0.99, Limit: 2 * time.Millisecond}}); !reflect.DeepEqual(cfg.FailIf, want) {
+		t.Errorf("FailIf = %+v, want %+v", cfg.FailIf, want)
+	}
+	if want := (DurationList{time.Millisecond, 10 * time.Millisecond}); !reflect.DeepEqual(cfg.TailThresholds, want) {
+		t.Errorf("TailThresholds = %v, want %v", cfg.TailThresholds, want)
+	}
+	if want := (Ramp{{0, 30 * time.Second}, {2, time.Minute}}); !reflect.DeepEqual(cfg.Ramp, want) {
+		t.Errorf("Ramp = %v, want %v", cfg.Ramp, want)
+	}
+	if want := (ExperimentDurations{time.Second, 2 * time.Second, 3 * time.Second}); !cfg.Experiment || !reflect.DeepEqual(cfg.ExperimentDurations, want) {
+		t.Errorf("Experiment = %v with %v, want true with %v", cfg.Experiment, cfg.ExperimentDurations, want)
+	}
+	if cfg.Sink != "json" {
+		t.Errorf("Sink = %q, want json", cfg.Sink)
+	}
+	if cfg.SweepWorkers != "1,2" || !cfg.Matrix || cfg.PointDuration != 10*time.Second || cfg.ABGODEBUG != ",asyncpreemptoff=1" {
+		t.Errorf("sweep = %q, matrix %v, point %v, GODEBUG %q", cfg.SweepWorkers, cfg.Matrix, cfg.PointDuration, cfg.ABGODEBUG)
+	}
+	if cfg.ConfigFile != "run.json" || !cfg.Version || !cfg.WorkerOnly || !cfg.SweepChild {
+		t.Errorf("ConfigFile = %q, Version = %v, WorkerOnly = %v, SweepChild = %v", cfg.ConfigFile, cfg.Version, cfg.WorkerOnly, cfg.SweepChild)
+	}
+}
+
+func TestNewConfigFromFlagsProbesAll(t *testing.T) {
+	cfg, err := parseFlags(t, "-probes=all")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	for _, name := range ProbeNames {
+		if ProbeUnavailable(name) != nil {
+			continue
+		}
+		if !cfg.ProbeEnabled(name) {
+			t.Errorf("-probes=all doesn't enable %v", name)
+		}
+	}
+}
+
+func TestNewConfigFromFlagsFailIfProbes(t *testing.T) {
+	cfg, err := parseFlags(t, "-fail-if=spin.p99>1ms,spin wait delay.max>5ms")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	want := FailIf{
+		{Spec: "spin.p99>1ms", Probe: "spin wait delay", Percentile: 0.99, Limit: time.Millisecond},
+		{Spec: "spin wait delay.max>5ms", Probe: "spin wait delay", Percentile: 1, Limit: 5 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(cfg.FailIf, want) {
+		t.Errorf("FailIf = %+v, want %+v", cfg.FailIf, want)
+	}
+
+	// Every probe available on this platform has an alias.
+	for _, name := range ProbeNames {
+		if ProbeUnavailable(name) != nil {
+			continue
+		}
+		if _, err := parseFlags(t, "-fail-if="+name+".p99>1ms"); err != nil {
+			t.Errorf("-fail-if with probe %v failed: %v", name, err)
+		}
+	}
+}
+
+func TestNewConfigFromFlagsInvalid(t *testing.T) {
+	tests := []string{
+		"-worker-duty=0",
+		"-worker-duty=101",
+		"-ballast=-1GiB",
+		"-ballast=lots",
+		"-gogc=-5",
+		"-worker-cpus=a",
+		"-worker-cpus=4-2",
+		"-workload-mix=json",
+		"-workload-mix=unknown:1",
+		"-workload-mix=json:1,json:2",
+		"-probes=unknown",
+		"-probe-opt=sleep",
+		"-fail-if=sleep.p99",
+		"-tail-thresholds=1ms,soon",
+		"-ramp=2",
+		"-ramp=x:1s",
+		"-ramp=1:never",
+		"-experiment-durations=1s,2s",
+		"-experiment-durations=1s,0s,3s",
+		"-sleep-interval=fast",
+		"-unknown-flag",
+	}
+	for _, arg := range tests {
+		if _, err := parseFlags(t, arg); err == nil {
+			t.Errorf("Parse(%v) succeeded, want error", arg)
+		}
+	}
+}
+
+func TestNewConfigFromFlagsValidate(t *testing.T) {
+	cfg, err := parseFlags(t, "-experiment", "-ramp=1:1s")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if _, _, err := NewConfig(WithFlags(*cfg)); err == nil {
+		t.Errorf("NewConfig with -experiment and -ramp succeeded, want error")
+	}
+}
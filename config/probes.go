@@ -0,0 +1,282 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sched-latency/probe"
+)
+
+// ProbeNames are the names -probes selects the probes by, in the order
+// they're reported.
+var ProbeNames = []string{"sleep", "sleep-spin", "timer", "spin", "mach", "epoll", "futex", "wakeup-burst", "alloc", "http", "sched", "tick"}
+
+// The names the probes that don't implement probe.Probe are reported as.
+const (
+	SchedProbe     = "/sched/latencies"
+	TickDelayProbe = "report tick delay"
+)
+
+// ReportedName returns the name the probe selected by name in ProbeNames is
+// reported as, or "" if it isn't available on this platform.
+func ReportedName(name string) string {
+	var p probe.Probe
+	switch name {
+	case "sleep":
+		p = &probe.Sleep{}
+	case "sleep-spin":
+		p = &probe.SleepSpin{}
+	case "timer":
+		p = &probe.Timer{}
+	case "spin":
+		p = &probe.SpinWait{}
+	case "mach":
+		p = machProbe
+	case "epoll":
+		p = epollProbe
+	case "futex":
+		p = futexProbe
+	case "wakeup-burst":
+		p = &probe.BurstWakeup{}
+	case "alloc":
+		p = &probe.AllocBurst{}
+	case "http":
+		p = &probe.HTTPLoopback{}
+	case "sched":
+		return SchedProbe
+	case "tick":
+		return TickDelayProbe
+	}
+	if p == nil {
+		return ""
+	}
+	return p.Name()
+}
+
+// ProbeUnavailable returns why the named probe can't run on this platform,
+// or nil if it can.
+func ProbeUnavailable(name string) error {
+	switch name {
+	case "mach":
+		return errMachUnsupported
+	case "epoll":
+		return errEpollUnsupported
+	case "futex":
+		return errFutexUnsupported
+	}
+	return nil
+}
+
+// ProbeList is a flag.Value for the probes to run, such as
+// "sleep,timer,sched", or "all" for every probe available on this
+// platform.
+type ProbeList []string
+
+// DefaultProbes returns the probes run by default: the sleep, timer and
+// /sched/latencies probes, the report tick's delay, and the mach_wait_until
+// probe in builds that have it. The spin wait and sleep+spin probes keep a
+// CPU busy, the alloc latency probe is only interesting under allocation
+// load, the http loopback probe runs a server, and the epoll_wait and futex
+// wake probes each take an OS thread of their own, so they only run if
+// they're selected.
+func DefaultProbes() ProbeList {
+	probes := ProbeList{"sleep", "timer", "sched", "tick"}
+	if ProbeUnavailable("mach") == nil {
+		probes = append(probes, "mach")
+	}
+	return probes
+}
+
+func (l ProbeList) String() string {
+	return strings.Join(l, ",")
+}
+
+func (l *ProbeList) Set(s string) error {
+	var list ProbeList
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name != "all" && !containsString(ProbeNames, name) {
+			return fmt.Errorf("unknown probe %q, must be all or one of: %v", name, strings.Join(ProbeNames, ", "))
+		}
+		list = append(list, name)
+	}
+	*l = list
+	return nil
+}
+
+// Resolve returns the probes selected by the list, in ProbeNames order,
+// and the probes that "all" skipped since they aren't available.
+func (l ProbeList) Resolve() (enabled, skipped []string) {
+	all := containsString(l, "all")
+	for _, name := range ProbeNames {
+		if !all && !containsString(l, name) {
+			continue
+		}
+		if err := ProbeUnavailable(name); err != nil {
+			skipped = append(skipped, fmt.Sprintf("%v (%v)", name, err))
+			continue
+		}
+		enabled = append(enabled, name)
+	}
+	return enabled, skipped
+}
+
+// ProbeEnabled returns whether the named probe runs, as selected by
+// -probes, or -wakeup-burst for the burst wakeup probe.
+func (c Config) ProbeEnabled(name string) bool {
+	if name == "wakeup-burst" && c.WakeupBurst {
+		return true
+	}
+	enabled, _ := c.Probes.Resolve()
+	return containsString(enabled, name)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// probeParams are the parameters -probe-opt can set for each probe.
+var probeParams = map[string][]string{
+	"sleep":        {"interval"},
+	"sleep-spin":   {"interval", "guard"},
+	"timer":        {"interval"},
+	"spin":         {"interval"},
+	"mach":         {"interval"},
+	"epoll":        {"interval"},
+	"futex":        {"interval"},
+	"wakeup-burst": {"interval", "size"},
+	"alloc":        {"interval", "size"},
+	"http":         {"interval"},
+}
+
+// ProbeOpt is a parameter override for a probe, from -probe-opt.
+type ProbeOpt struct {
+	Probe string
+	Key   string
+	Value string
+}
+
+// ProbeOpts is a repeatable flag.Value for probe parameter overrides of the
+// form probe.key=value, such as "sleep.interval=1ms". Later overrides of the
+// same parameter win.
+type ProbeOpts []ProbeOpt
+
+func (o ProbeOpts) String() string {
+	parts := make([]string, len(o))
+	for i, opt := range o {
+		parts[i] = fmt.Sprintf("%v.%v=%v", opt.Probe, opt.Key, opt.Value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (o *ProbeOpts) Set(s string) error {
+	// A comma-separated list is accepted too, as written by String, e.g.,
+	// in a summary's flags.
+	for _, part := range strings.Split(s, ",") {
+		opt, err := parseProbeOpt(strings.TrimSpace(part))
+		if err != nil {
+			return err
+		}
+		*o = append(*o, opt)
+	}
+	return nil
+}
+
+func parseProbeOpt(s string) (ProbeOpt, error) {
+	param, value, ok := strings.Cut(s, "=")
+	name, key, ok2 := strings.Cut(param, ".")
+	if !ok || !ok2 {
+		return ProbeOpt{}, fmt.Errorf("%q is not probe.key=value", s)
+	}
+	keys, ok := probeParams[name]
+	if !ok {
+		var names []string
+		for _, n := range ProbeNames {
+			if _, ok := probeParams[n]; ok {
+				names = append(names, n)
+			}
+		}
+		return ProbeOpt{}, fmt.Errorf("unknown probe %q, must be one of: %v", name, strings.Join(names, ", "))
+	}
+	if !containsString(keys, key) {
+		return ProbeOpt{}, fmt.Errorf("unknown parameter %q for probe %v, must be one of: %v", key, name, strings.Join(keys, ", "))
+	}
+	switch key {
+	case "interval":
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return ProbeOpt{}, fmt.Errorf("%v.interval must be a positive duration, got %q", name, value)
+		}
+	case "guard":
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			return ProbeOpt{}, fmt.Errorf("%v.guard must be a non-negative duration, got %q", name, value)
+		}
+	case "size":
+		if n, err := strconv.Atoi(value); err != nil || n < 1 {
+			return ProbeOpt{}, fmt.Errorf("%v.size must be a positive integer, got %q", name, value)
+		}
+	}
+	return ProbeOpt{name, key, value}, nil
+}
+
+// lookup returns the last override of the probe's parameter, if any.
+func (o ProbeOpts) lookup(name, key string) (string, bool) {
+	for i := len(o) - 1; i >= 0; i-- {
+		if o[i].Probe == name && o[i].Key == key {
+			return o[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// ProbeInterval returns the named probe's interval, which is
+// -sleep-interval unless it's overridden by -probe-opt.
+func (c Config) ProbeInterval(name string) time.Duration {
+	if v, ok := c.ProbeOpts.lookup(name, "interval"); ok {
+		d, _ := time.ParseDuration(v)
+		return d
+	}
+	return c.SleepInterval
+}
+
+// defaultSpinGuard is how long before its deadline the sleep+spin probe
+// stops sleeping and starts spinning, unless it's overridden by -probe-opt.
+const defaultSpinGuard = time.Millisecond
+
+// SpinGuard returns the sleep+spin probe's guard.
+func (c Config) SpinGuard() time.Duration {
+	if v, ok := c.ProbeOpts.lookup("sleep-spin", "guard"); ok {
+		d, _ := time.ParseDuration(v)
+		return d
+	}
+	return defaultSpinGuard
+}
+
+// BurstSize returns the burst wakeup probe's burst size, which is
+// -wakeup-burst-size unless it's overridden by -probe-opt.
+func (c Config) BurstSize() int {
+	if v, ok := c.ProbeOpts.lookup("wakeup-burst", "size"); ok {
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return c.WakeupBurstSize
+}
+
+// defaultAllocBurst is the number of allocations the alloc latency probe
+// times in each burst, unless it's overridden by -probe-opt.
+const defaultAllocBurst = 100
+
+// AllocBurst returns the alloc latency probe's burst size.
+func (c Config) AllocBurst() int {
+	if v, ok := c.ProbeOpts.lookup("alloc", "size"); ok {
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return defaultAllocBurst
+}
@@ -0,0 +1,16 @@
+//go:build linux
+
+package config
+
+import "sched-latency/probe"
+
+var (
+	errEpollUnsupported error
+	errFutexUnsupported error
+)
+
+// The probes that are only available on Linux, for their names.
+var (
+	epollProbe probe.Probe = &probe.EpollWait{}
+	futexProbe probe.Probe = &probe.FutexWake{}
+)
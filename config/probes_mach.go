@@ -0,0 +1,10 @@
+//go:build darwin && cgo && machwait
+
+package config
+
+import "sched-latency/probe"
+
+var errMachUnsupported error
+
+// machProbe is the mach_wait_until probe, for its name.
+var machProbe probe.Probe = &probe.MachWait{}
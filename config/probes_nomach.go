@@ -0,0 +1,14 @@
+//go:build !darwin || !cgo || !machwait
+
+package config
+
+import (
+	"errors"
+
+	"sched-latency/probe"
+)
+
+var errMachUnsupported = errors.New("mach_wait_until is only available on macOS with cgo, in builds with -tags machwait")
+
+// machProbe is nil, as the mach_wait_until probe isn't in this build.
+var machProbe probe.Probe
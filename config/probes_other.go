@@ -0,0 +1,18 @@
+//go:build !linux
+
+package config
+
+import (
+	"errors"
+
+	"sched-latency/probe"
+)
+
+var (
+	errEpollUnsupported = errors.New("epoll_wait is only available on Linux")
+	errFutexUnsupported = errors.New("futexes are only available on Linux")
+)
+
+// epollProbe and futexProbe are nil, as the probes are only available on
+// Linux.
+var epollProbe, futexProbe probe.Probe
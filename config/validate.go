@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+
+	"sched-latency/export"
+)
+
+// Validate checks that the config's values make sense on their own,
+// returning an error naming the flag to fix. It's used for configs from
+// both the flags and NewConfig.
+func (c *Config) Validate() error {
+	positive := []struct {
+		flag    string
+		invalid bool
+		v       interface{}
+	}{
+		{"report-interval", c.ReportInterval <= 0, c.ReportInterval},
+		{"sleep-interval", c.SleepInterval <= 0, c.SleepInterval},
+		{"worker-period", c.WorkerPeriod <= 0, c.WorkerPeriod},
+		{"gomaxprocs", c.GOMAXPROCS < 1, c.GOMAXPROCS},
+	}
+	for _, p := range positive {
+		if p.invalid {
+			return fmt.Errorf("-%v must be positive, got %v", p.flag, p.v)
+		}
+	}
+
+	nonNegative := []struct {
+		flag    string
+		invalid bool
+		v       interface{}
+	}{
+		{"workers", c.Workers < 0, c.Workers},
+		{"burst-workers", c.BurstWorkers < 0, c.BurstWorkers},
+		{"idle-timers", c.IdleTimers < 0, c.IdleTimers},
+		{"idle-goroutines", c.IdleGoroutines < 0, c.IdleGoroutines},
+		{"idle-conns", c.IdleConns < 0, c.IdleConns},
+		{"samples", c.Samples < 0, c.Samples},
+		{"duration", c.Duration < 0, c.Duration},
+		{"warmup", c.Warmup < 0, c.Warmup},
+		{"load-after", c.LoadAfter < 0, c.LoadAfter},
+		{"force-gc-every", c.ForceGCEvery < 0, c.ForceGCEvery},
+		{"timer-slack", c.TimerSlack < 0, c.TimerSlack},
+		{"trace-on-spike", c.TraceOnSpike < 0, c.TraceOnSpike},
+		{"trace-cooldown", c.TraceCooldown < 0, c.TraceCooldown},
+		{"dump-on-spike", c.DumpOnSpike < 0, c.DumpOnSpike},
+		{"dump-cooldown", c.DumpCooldown < 0, c.DumpCooldown},
+		{"heap-profile-every", c.HeapProfileEvery < 0, c.HeapProfileEvery},
+		{"heap-profile-keep", c.HeapProfileKeep < 0, c.HeapProfileKeep},
+		{"outlier-threshold", c.OutlierThreshold < 0, c.OutlierThreshold},
+		{"breach-cooldown", c.BreachCooldown < 0, c.BreachCooldown},
+		{"stall-threshold", c.StallThreshold < 0, c.StallThreshold},
+		{"anomaly-k", c.AnomalyK < 0, c.AnomalyK},
+		{"smooth", c.Smooth < 0, c.Smooth},
+		{"point-duration", c.PointDuration < 0, c.PointDuration},
+	}
+	for _, n := range nonNegative {
+		if n.invalid {
+			return fmt.Errorf("-%v must not be negative, got %v", n.flag, n.v)
+		}
+	}
+
+	for _, p := range c.Percentiles {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("percentiles must be between 0 and 1, got %v", p)
+		}
+	}
+	if c.ActiveConns < 0 || c.ActiveConns > c.IdleConns {
+		return fmt.Errorf("-active-conns (%v) must be between 0 and -idle-conns (%v)", c.ActiveConns, c.IdleConns)
+	}
+	if c.ActiveConns > 0 && c.ActiveInterval <= 0 {
+		return fmt.Errorf("-active-conn-interval must be positive, got %v", c.ActiveInterval)
+	}
+	if c.MonitorURL != "" {
+		if err := validateMonitorURL(c.MonitorURL); err != nil {
+			return err
+		}
+	}
+	if len(c.Probes) == 0 && !c.WakeupBurst && c.MonitorURL == "" && c.Role != "load" {
+		return fmt.Errorf("-probes must select at least one probe")
+	}
+	for _, name := range c.Probes {
+		if err := ProbeUnavailable(name); err != nil {
+			return fmt.Errorf("-probes: %v isn't available: %v", name, err)
+		}
+	}
+	if c.ProbeEnabled("wakeup-burst") && c.WakeupBurstSize < 1 {
+		return fmt.Errorf("-wakeup-burst-size must be positive, got %v", c.WakeupBurstSize)
+	}
+	if c.StallThreshold > 0 && c.StallThreshold < 2*HeartbeatInterval {
+		return fmt.Errorf("-stall-threshold must be 0 or at least %v, twice the heartbeat interval, got %v", 2*HeartbeatInterval, c.StallThreshold)
+	}
+	if c.Duration > 0 && c.Warmup >= c.Duration {
+		return fmt.Errorf("-warmup (%v) must be shorter than -duration (%v)", c.Warmup, c.Duration)
+	}
+	if c.Experiment && (c.Ramp != nil || c.LoadAfter > 0) {
+		return fmt.Errorf("-experiment cannot be combined with -ramp or -load-after")
+	}
+	if c.BurstWorkers > 0 && (c.BurstDuration <= 0 || c.BurstDuration >= c.BurstPeriod) {
+		return fmt.Errorf("-burst-duration must be positive and shorter than -burst-period, got %v and %v", c.BurstDuration, c.BurstPeriod)
+	}
+	if c.AnomalyK > 0 && c.AnomalyWindow < c.ReportInterval {
+		return fmt.Errorf("-anomaly-window (%v) must be at least -report-interval (%v)", c.AnomalyWindow, c.ReportInterval)
+	}
+	if c.BlockProfile != "" && c.BlockProfileRate <= 0 {
+		return fmt.Errorf("-blockprofilerate must be positive with -blockprofile, got %v", c.BlockProfileRate)
+	}
+	if c.MutexProfile != "" && c.MutexProfileFraction <= 0 {
+		return fmt.Errorf("-mutexprofilefraction must be positive with -mutexprofile, got %v", c.MutexProfileFraction)
+	}
+	if c.Trace != "" && c.TraceOnSpike > 0 {
+		return fmt.Errorf("-trace can't be combined with -trace-on-spike, since only one execution trace can run at a time")
+	}
+	if c.Trace != "" && !c.Force && (c.Duration <= 0 || c.Duration > MaxRunTrace) {
+		return fmt.Errorf("-trace needs a -duration of at most %v, since traces grow by megabytes per second, got %v (use -force to trace anyway)", MaxRunTrace, c.Duration)
+	}
+	if c.TraceOnSpike > 0 && c.TraceDuration <= 0 {
+		return fmt.Errorf("-trace-duration must be positive, got %v", c.TraceDuration)
+	}
+	if c.OnBreachCmd != "" {
+		var reported bool
+		for _, p := range c.Percentiles {
+			reported = reported || p == c.BreachPercentile
+		}
+		if !reported {
+			return fmt.Errorf("-breach-percentile must be one of the reported percentiles %v, got %v", c.Percentiles, c.BreachPercentile)
+		}
+	}
+	if c.Role != "" && c.Role != "measure" && c.Role != "load" {
+		return fmt.Errorf(`-role must be "measure" or "load", got %q`, c.Role)
+	}
+	if c.Accumulate != "auto" && c.Accumulate != "samples" && c.Accumulate != "buckets" {
+		return fmt.Errorf(`-accumulate must be "auto", "samples" or "buckets", got %q`, c.Accumulate)
+	}
+	if c.ProbeRTPrio != 0 {
+		if c.ProbeRTPrio < 1 || c.ProbeRTPrio > 99 {
+			return fmt.Errorf("-probe-rt-priority must be between 1 and 99, got %v", c.ProbeRTPrio)
+		}
+		if c.ProbeRTPolicy != "fifo" && c.ProbeRTPolicy != "rr" {
+			return fmt.Errorf(`-probe-rt-policy must be "fifo" or "rr", got %q`, c.ProbeRTPolicy)
+		}
+	}
+	return nil
+}
+
+// Warnings returns the problems with a valid config that are likely to be
+// mistakes, but don't stop it from running.
+func (c *Config) Warnings() []string {
+	var warnings []string
+	if c.SleepInterval >= c.ReportInterval {
+		warnings = append(warnings, fmt.Sprintf(
+			"-sleep-interval (%v) is not shorter than -report-interval (%v), so each report has at most one sample",
+			c.SleepInterval, c.ReportInterval))
+	}
+	if c.ProbeEnabled("spin") && c.Workers == 0 {
+		warnings = append(warnings, "the spin wait probe keeps a CPU busy, which is load on top of -workers=0")
+	}
+	if c.ProbeEnabled("alloc") && !c.WorkloadMix.Has("alloc") {
+		warnings = append(warnings, "the alloc latency probe mostly sees GC assist stalls under allocation load, e.g., -workload-mix=alloc:4")
+	}
+	if c.Trace != "" && c.CPUProfile != "" {
+		warnings = append(warnings, "-trace and -cpuprofile are both on, so the trace includes the profiler's SIGPROF interruptions")
+	}
+	return warnings
+}
+
+// validateMonitorURL returns an error if the -monitor-url isn't an http or
+// https URL with a host.
+func validateMonitorURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid -monitor-url: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("-monitor-url must be an http or https URL, e.g. http://localhost:6060%v, got %q", export.Path, rawURL)
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Percent is a flag.Value for a fraction in (0, 1], specified as a
+// percentage such as "70%" or "70".
+type Percent float64
+
+func (p *Percent) String() string {
+	return strconv.FormatFloat(float64(*p)*100, 'g', -1, 64) + "%"
+}
+
+func (p *Percent) Set(s string) error {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return err
+	}
+	if v <= 0 || v > 100 {
+		return fmt.Errorf("percentage must be in (0, 100], got %v", s)
+	}
+	*p = Percent(v / 100)
+	return nil
+}
+
+// ByteSize is a flag.Value for a size in bytes, with an optional unit suffix
+// such as "512MiB" or "4GB".
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	// Longer suffixes go first so "GiB" isn't parsed as "B".
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"B", 1},
+}
+
+func (b ByteSize) String() string {
+	for i := 3; i >= 0; i-- {
+		if u := byteSizeUnits[i]; int64(b) >= u.size && int64(b)%u.size == 0 {
+			return strconv.FormatInt(int64(b)/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
+
+func (b *ByteSize) Set(s string) error {
+	multiplier := int64(1)
+	num := s
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			multiplier = u.size
+			num = strings.TrimSuffix(s, u.suffix)
+			break
+		}
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	if v < 0 {
+		return fmt.Errorf("size must not be negative, got %q", s)
+	}
+	*b = ByteSize(v * float64(multiplier))
+	return nil
+}
+
+// GCPercent is a flag.Value for a GOGC percentage, or "off" to disable the GC.
+type GCPercent struct {
+	set     bool
+	percent int
+}
+
+// Percent returns the percentage, -1 for "off", and whether it was set.
+func (g GCPercent) Percent() (percent int, ok bool) {
+	return g.percent, g.set
+}
+
+func (g GCPercent) String() string {
+	switch {
+	case !g.set:
+		return ""
+	case g.percent < 0:
+		return "off"
+	}
+	return strconv.Itoa(g.percent)
+}
+
+func (g *GCPercent) Set(s string) error {
+	if s == "off" {
+		*g = GCPercent{set: true, percent: -1}
+		return nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return fmt.Errorf(`GOGC must be a non-negative percentage or "off", got %q`, s)
+	}
+	*g = GCPercent{set: true, percent: v}
+	return nil
+}
+
+// CPUSet is a flag.Value for a set of CPUs in the kernel's cpuset list
+// format, such as "0-3,6".
+type CPUSet []int
+
+func (s CPUSet) String() string {
+	var parts []string
+	for i := 0; i < len(s); {
+		j := i
+		for j+1 < len(s) && s[j+1] == s[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(s[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", s[i], s[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *CPUSet) Set(v string) error {
+	set, err := ParseCPUList(v)
+	if err != nil {
+		return err
+	}
+	*s = set
+	return nil
+}
+
+// ParseCPUList parses a cpuset list, returning the CPUs in ascending order.
+func ParseCPUList(v string) (CPUSet, error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(strings.TrimSpace(v), ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid CPU %q in %q", lo, v)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPU range %q in %q", part, v)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			seen[cpu] = true
+		}
+	}
+
+	var set CPUSet
+	for cpu := 0; len(set) < len(seen); cpu++ {
+		if seen[cpu] {
+			set = append(set, cpu)
+		}
+	}
+	return set, nil
+}
+
+// DurationList is a flag.Value for a comma-separated list of positive
+// durations, such as "1ms,10ms,100ms", kept in ascending order.
+type DurationList []time.Duration
+
+func (l DurationList) String() string {
+	parts := make([]string, len(l))
+	for i, d := range l {
+		parts[i] = d.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *DurationList) Set(s string) error {
+	ds, err := ParseDurationList(s)
+	if err != nil {
+		return err
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	*l = ds
+	return nil
+}
+
+// ParseDurationList parses a comma-separated list of positive durations, in
+// the order they're listed.
+func ParseDurationList(s string) ([]time.Duration, error) {
+	var durations []time.Duration
+	for _, part := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q", part)
+		}
+		durations = append(durations, d)
+	}
+	return durations, nil
+}
+
+// RampStep runs a fixed number of workers for a duration.
+type RampStep struct {
+	Workers  int
+	Duration time.Duration
+}
+
+func (s RampStep) String() string {
+	return fmt.Sprintf("%d workers for %v", s.Workers, s.Duration)
+}
+
+// Ramp is a flag.Value for a schedule of ramp steps, such as
+// "0:30s,2:30s,4:30s".
+type Ramp []RampStep
+
+func (r Ramp) String() string {
+	parts := make([]string, len(r))
+	for i, s := range r {
+		parts[i] = fmt.Sprintf("%d:%v", s.Workers, s.Duration)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *Ramp) Set(s string) error {
+	steps, err := ParseRamp(s)
+	if err != nil {
+		return err
+	}
+	*r = steps
+	return nil
+}
+
+// ParseRamp parses a comma-separated list of workers:duration pairs,
+// such as "0:30s,2:30s,4:30s".
+func ParseRamp(s string) ([]RampStep, error) {
+	var steps []RampStep
+	for _, part := range strings.Split(s, ",") {
+		workersStr, durationStr, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("ramp step %q is not workers:duration", part)
+		}
+
+		workers, err := strconv.Atoi(workersStr)
+		if err != nil || workers < 0 {
+			return nil, fmt.Errorf("ramp step %q has invalid worker count", part)
+		}
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("ramp step %q has invalid duration", part)
+		}
+
+		steps = append(steps, RampStep{Workers: workers, Duration: duration})
+	}
+	return steps, nil
+}
+
+// ExperimentDurations is a flag.Value for the durations of an experiment's
+// baseline, loaded and recovery phases, such as "30s,60s,30s".
+type ExperimentDurations []time.Duration
+
+func (e ExperimentDurations) String() string {
+	return DurationList(e).String()
+}
+
+func (e *ExperimentDurations) Set(s string) error {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return fmt.Errorf("expected 3 durations, got %q", s)
+	}
+
+	durations := make(ExperimentDurations, len(parts))
+	for i, part := range parts {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q", part)
+		}
+		durations[i] = d
+	}
+	*e = durations
+	return nil
+}
+
+// WorkloadNames are the workload types a WorkloadMix can run.
+var WorkloadNames = []string{"alloc", "json", "spin"}
+
+// WorkloadMixEntry is a number of workers to run of a single workload type.
+type WorkloadMixEntry struct {
+	Name  string
+	Count int
+}
+
+// WorkloadMix is a flag.Value for the mix of workload types to run,
+// such as "json:2,spin:4,alloc:2".
+type WorkloadMix []WorkloadMixEntry
+
+func (m WorkloadMix) String() string {
+	parts := make([]string, len(m))
+	for i, e := range m {
+		parts[i] = fmt.Sprintf("%s:%d", e.Name, e.Count)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *WorkloadMix) Set(s string) error {
+	var mix WorkloadMix
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		name, countStr, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return fmt.Errorf("workload %q is not name:count", part)
+		}
+		if !containsString(WorkloadNames, name) {
+			return fmt.Errorf("unknown workload %q, must be one of: %v", name, strings.Join(WorkloadNames, ", "))
+		}
+		if seen[name] {
+			return fmt.Errorf("workload %q specified more than once", name)
+		}
+		seen[name] = true
+
+		count, err := strconv.Atoi(countStr)
+		if err != nil || count < 0 {
+			return fmt.Errorf("workload %q has invalid count %q", name, countStr)
+		}
+		mix = append(mix, WorkloadMixEntry{Name: name, Count: count})
+	}
+	*m = mix
+	return nil
+}
+
+// Workers returns the total number of workers in the mix.
+func (m WorkloadMix) Workers() int {
+	var n int
+	for _, e := range m {
+		n += e.Count
+	}
+	return n
+}
+
+// Has returns whether the mix runs any workers of the named workload.
+func (m WorkloadMix) Has(name string) bool {
+	for _, e := range m {
+		if e.Name == name && e.Count > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+// Package export serves a process's /sched/latencies histogram as JSON, so
+// sched-latency -monitor-url can report the process's scheduling latencies
+// from outside it. It only depends on the standard library, to be cheap to
+// import into any Go service:
+//
+//	http.Handle(export.Path, export.Handler())
+//
+// The histogram can also be published with expvar, which sched-latency
+// finds in /debug/vars under ExpvarName:
+//
+//	expvar.Publish(export.ExpvarName, expvar.Func(func() any { return export.Read() }))
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime/metrics"
+)
+
+// Metric is the runtime/metrics histogram that's exported.
+const Metric = "/sched/latencies:seconds"
+
+// Path is the path Handler is conventionally served on.
+const Path = "/debug/sched-latencies"
+
+// ExpvarName is the name to publish the histogram under with expvar.
+const ExpvarName = "sched_latencies"
+
+// Histogram is a reading of the cumulative /sched/latencies histogram. As
+// in metrics.Float64Histogram, Counts[i] is the number of latencies between
+// Buckets[i] and Buckets[i+1] seconds.
+type Histogram struct {
+	Counts  []uint64
+	Buckets []float64
+}
+
+// Read reads the process's histogram.
+func Read() Histogram {
+	sample := []metrics.Sample{{Name: Metric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindFloat64Histogram {
+		return Histogram{}
+	}
+	h := sample[0].Value.Float64Histogram()
+	return Histogram{Counts: h.Counts, Buckets: h.Buckets}
+}
+
+// Handler returns a handler that writes the process's histogram as JSON.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Read())
+	})
+}
+
+// histogramJSON is the JSON form of a Histogram. JSON has no infinities, so
+// bucket bounds are numbers or the strings "-Inf" and "+Inf".
+type histogramJSON struct {
+	Metric  string   `json:"metric"`
+	Counts  []uint64 `json:"counts"`
+	Buckets []bound  `json:"buckets"`
+}
+
+type bound float64
+
+func (b bound) MarshalJSON() ([]byte, error) {
+	switch {
+	case math.IsInf(float64(b), 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(float64(b), -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return json.Marshal(float64(b))
+}
+
+func (b *bound) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case `"+Inf"`:
+		*b = bound(math.Inf(1))
+		return nil
+	case `"-Inf"`:
+		*b = bound(math.Inf(-1))
+		return nil
+	}
+	return json.Unmarshal(data, (*float64)(b))
+}
+
+// MarshalJSON encodes the histogram with its metric's name.
+func (h Histogram) MarshalJSON() ([]byte, error) {
+	buckets := make([]bound, len(h.Buckets))
+	for i, b := range h.Buckets {
+		buckets[i] = bound(b)
+	}
+	return json.Marshal(histogramJSON{Metric: Metric, Counts: h.Counts, Buckets: buckets})
+}
+
+// UnmarshalJSON decodes a histogram encoded by MarshalJSON, rejecting one
+// whose buckets don't match its counts.
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var v histogramJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v.Buckets) != len(v.Counts)+1 {
+		return fmt.Errorf("histogram has %d buckets for %d counts", len(v.Buckets)-1, len(v.Counts))
+	}
+	h.Counts = v.Counts
+	h.Buckets = make([]float64, len(v.Buckets))
+	for i, b := range v.Buckets {
+		h.Buckets[i] = float64(b)
+	}
+	return nil
+}
+
+// Decode reads a histogram written by Handler, or an expvar page with one
+// published under ExpvarName.
+func Decode(r io.Reader) (Histogram, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Histogram{}, err
+	}
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(data, &vars); err != nil {
+		return Histogram{}, err
+	}
+	if _, ok := vars["buckets"]; !ok {
+		if data, ok = vars[ExpvarName]; !ok {
+			return Histogram{}, errors.New("no histogram, nor an expvar named " + ExpvarName)
+		}
+	}
+	var h Histogram
+	err = json.Unmarshal(data, &h)
+	return h, err
+}
@@ -0,0 +1,362 @@
+package probe
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"sched-latency/report"
+	"sched-latency/stats"
+)
+
+// Totals are a probe's cumulative stats since it started.
+type Totals struct {
+	Count    uint64        `json:"count"`
+	Negative uint64        `json:"negative"`
+	Min      time.Duration `json:"min_ns"`
+	Max      time.Duration `json:"max_ns"`
+	Mean     time.Duration `json:"mean_ns"`
+
+	// Values are the cumulative Percentiles, interpolated from buckets, if
+	// the interval keeps them; see SetTotalResolution.
+	Percentiles []float64       `json:"percentiles,omitempty"`
+	Values      []time.Duration `json:"values_ns,omitempty"`
+}
+
+// Snapshot is a probe's results at a point in time.
+type Snapshot struct {
+	// Interval has the percentiles of the current interval so far.
+	Interval report.Result `json:"interval"`
+
+	// Total has the stats of every sample since the probe started.
+	Total Totals `json:"total"`
+}
+
+// Interval is a Recorder that accumulates a probe's samples over an
+// interval, and keeps cumulative totals across intervals. Its methods are
+// safe to call from any goroutine.
+type Interval struct {
+	name        string
+	percentiles []float64
+
+	// thresholds are those the interval counts samples over, if any.
+	thresholds []time.Duration
+
+	// nextMu serializes Next, which sorts the samples it hands over with
+	// nextSorter outside mu.
+	nextMu     sync.Mutex
+	nextSorter stats.Sorter
+
+	mu      sync.Mutex
+	start   time.Time
+	samples []time.Duration
+	// spare is the slice of samples the last Next handed over, which the
+	// next interval reuses, and sorter sorts them for Snapshot.
+	spare  []time.Duration
+	sorter stats.Sorter
+	// hist is set for a bucketed interval, which counts samples in it
+	// rather than keeping them.
+	hist *stats.LogHist
+	// totalHist, if set, counts every sample since the interval started,
+	// for cumulative percentiles.
+	totalHist *stats.LogHist
+	count     uint64
+	sum       time.Duration
+	min       time.Duration
+	max       time.Duration
+
+	// negative counts the interval's samples with a negative delay, and
+	// negativeTotal every such sample.
+	negative      uint64
+	negativeTotal uint64
+}
+
+// NewInterval returns an Interval for the named probe, starting now, which
+// computes the given percentiles.
+func NewInterval(name string, percentiles []float64) *Interval {
+	return &Interval{name: name, percentiles: percentiles, start: time.Now()}
+}
+
+// NewBucketedInterval returns an Interval like NewInterval, but which
+// counts samples in a stats.LogHist with the given resolution, so its
+// memory doesn't grow with the number of samples. Its percentiles are
+// interpolated from the buckets.
+func NewBucketedInterval(name string, percentiles []float64, resolution float64) *Interval {
+	i := NewInterval(name, percentiles)
+	i.hist = stats.NewLogHist(resolution)
+	return i
+}
+
+// SetThresholds makes the interval count the samples over each threshold,
+// which its results report as their Tail. It must be called before the
+// first sample is added.
+func (i *Interval) SetThresholds(thresholds []time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.thresholds = thresholds
+}
+
+// SetTotalResolution makes the interval also count every sample across
+// intervals in a stats.LogHist with the given resolution, so its snapshots'
+// Totals have cumulative percentiles. It must be called before the first
+// sample is added.
+func (i *Interval) SetTotalResolution(resolution float64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.totalHist = stats.NewLogHist(resolution)
+}
+
+// Reserve sizes the interval's sample buffers for n samples, such as the
+// most a probe can take in an interval, so Add doesn't allocate as they
+// grow. It does nothing for a bucketed interval.
+func (i *Interval) Reserve(n int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.hist != nil || cap(i.samples) >= n {
+		return
+	}
+	i.samples = append(make([]time.Duration, 0, n), i.samples...)
+}
+
+// Start implements Recorder.
+func (i *Interval) Start() {}
+
+// Add implements Recorder, adding the sample to the interval.
+func (i *Interval) Add(delay time.Duration, at time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.hist != nil {
+		i.hist.Add(delay)
+	} else {
+		i.samples = append(i.samples, delay)
+	}
+	if i.totalHist != nil {
+		i.totalHist.Add(delay)
+	}
+	if delay < 0 {
+		i.negative++
+		i.negativeTotal++
+	}
+	if i.count == 0 || delay < i.min {
+		i.min = delay
+	}
+	if i.count == 0 || delay > i.max {
+		i.max = delay
+	}
+	i.count++
+	i.sum += delay
+}
+
+// Snapshot returns the results so far, leaving the interval running.
+func (i *Interval) Snapshot() Snapshot {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	s := i.snapshot()
+	if i.hist != nil {
+		s.Interval.Values = i.hist.Percentiles(i.percentiles)
+		s.Interval.Tail = i.histTail()
+	} else {
+		s.Interval.Values = i.sorter.Percentiles(i.samples, i.percentiles)
+		s.Interval.Tail = i.sampleTail(i.samples)
+	}
+	return s
+}
+
+// Next ends the interval, starting the next one at now. It returns the
+// results of the interval that ended, and its samples in the order they
+// were added, which are only valid until the following call to Next, since
+// their slice is reused. A bucketed interval returns no samples; use
+// NextBuckets.
+func (i *Interval) Next(now time.Time) (Snapshot, []time.Duration) {
+	i.nextMu.Lock()
+	defer i.nextMu.Unlock()
+
+	i.mu.Lock()
+	samples := i.samples
+	s := i.snapshot()
+	s.Interval.Duration = now.Sub(i.start)
+	if i.hist != nil {
+		s.Interval.Values = i.hist.Percentiles(i.percentiles)
+		s.Interval.Tail = i.histTail()
+		i.hist.Reset()
+	}
+	i.negative = 0
+	// The samples are handed over, so the next interval takes the ones
+	// handed over last time, growing them to as many samples as this
+	// interval had, to avoid growing them while the probe takes samples.
+	next := i.spare[:0]
+	if cap(next) < cap(samples) {
+		next = make([]time.Duration, 0, cap(samples))
+	}
+	i.samples, i.spare = next, samples
+	i.start = now
+	i.mu.Unlock()
+
+	if s.Interval.Values == nil {
+		s.Interval.Values = i.nextSorter.Percentiles(samples, i.percentiles)
+		s.Interval.Tail = i.sampleTail(samples)
+	}
+	return s, samples
+}
+
+// NextBuckets is Next for a bucketed interval, returning the buckets of
+// the interval that ended rather than its samples.
+func (i *Interval) NextBuckets(now time.Time) (Snapshot, stats.HistSnapshot) {
+	i.mu.Lock()
+	s := i.snapshot()
+	s.Interval.Duration = now.Sub(i.start)
+	s.Interval.Values = i.hist.Percentiles(i.percentiles)
+	buckets := i.hist.Snapshot()
+	if i.thresholds != nil {
+		s.Interval.Tail = buckets.CountAbove(i.thresholds)
+	}
+	i.hist.Reset()
+	i.negative = 0
+	i.start = now
+	i.mu.Unlock()
+	return s, buckets
+}
+
+// Bucketed returns whether the interval counts samples in buckets.
+func (i *Interval) Bucketed() bool {
+	return i.hist != nil
+}
+
+// sampleTail returns the number of samples over each threshold, or nil if
+// the interval has none.
+func (i *Interval) sampleTail(samples []time.Duration) []uint64 {
+	if i.thresholds == nil {
+		return nil
+	}
+	return stats.CountAbove(samples, i.thresholds)
+}
+
+// histTail is sampleTail for a bucketed interval, interpolating within the
+// buckets. The lock must be held.
+func (i *Interval) histTail() []uint64 {
+	if i.thresholds == nil {
+		return nil
+	}
+	return i.hist.Snapshot().CountAbove(i.thresholds)
+}
+
+// snapshot returns the results without the interval's percentiles. The
+// lock must be held.
+func (i *Interval) snapshot() Snapshot {
+	s := Snapshot{
+		Interval: report.Result{
+			Probe:       i.name,
+			Start:       i.start,
+			Duration:    time.Since(i.start),
+			Percentiles: i.percentiles,
+			Thresholds:  i.thresholds,
+			Count:       uint64(len(i.samples)),
+			Negative:    i.negative,
+		},
+		Total: Totals{Count: i.count, Negative: i.negativeTotal, Min: i.min, Max: i.max},
+	}
+	if i.hist != nil {
+		s.Interval.Count = i.hist.Count()
+	}
+	if i.count > 0 {
+		s.Total.Mean = i.sum / time.Duration(i.count)
+	}
+	if i.totalHist != nil {
+		s.Total.Percentiles = i.percentiles
+		s.Total.Values = i.totalHist.Percentiles(i.percentiles)
+	}
+	return s
+}
+
+// collectorResolution is the resolution of the buckets a Collector's
+// cumulative percentiles are interpolated from.
+const collectorResolution = 0.01
+
+// Collector runs probes, keeping each one's results so they can be read at
+// any time. Each probe's snapshot has the percentiles of its current
+// interval, and cumulative ones since it started. This is for embedding the
+// probes in another program, e.g., as a latency canary whose results are
+// served over HTTP:
+//
+//	c := probe.NewCollector(time.Minute, []float64{0.5, 0.99, 1},
+//		&probe.Sleep{Interval: 10 * time.Millisecond},
+//		&probe.Timer{Interval: 10 * time.Millisecond})
+//	c.Start()
+//	defer c.Stop()
+//
+//	http.HandleFunc("/latency", func(w http.ResponseWriter, r *http.Request) {
+//		json.NewEncoder(w).Encode(c.Snapshot())
+//	})
+type Collector struct {
+	interval time.Duration
+	probes   []Probe
+
+	intervals []*Interval
+	runners   []runner
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewCollector returns a Collector for the probes, which starts a new
+// interval every interval and computes the given percentiles.
+func NewCollector(interval time.Duration, percentiles []float64, probes ...Probe) *Collector {
+	c := &Collector{interval: interval, probes: probes}
+	for _, p := range probes {
+		in := NewInterval(p.Name(), percentiles)
+		in.SetTotalResolution(collectorResolution)
+		c.intervals = append(c.intervals, in)
+	}
+	return c
+}
+
+// Start runs the probes in the background until Stop.
+func (c *Collector) Start() {
+	c.runners = make([]runner, len(c.probes))
+	for idx, p := range c.probes {
+		p, in := p, c.intervals[idx]
+		c.runners[idx].start(func(ctx context.Context) { p.Run(ctx, in) })
+	}
+
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		t := time.NewTicker(c.interval)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				for _, in := range c.intervals {
+					in.Next(now)
+				}
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the probes, waiting for them to exit. It does nothing if the
+// collector wasn't started.
+func (c *Collector) Stop() {
+	if c.stop == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+	for i := range c.runners {
+		c.runners[i].Stop()
+	}
+}
+
+// Snapshot returns every probe's results so far, in the order the probes
+// were passed to NewCollector.
+func (c *Collector) Snapshot() []Snapshot {
+	snapshots := make([]Snapshot, len(c.intervals))
+	for i, in := range c.intervals {
+		snapshots[i] = in.Snapshot()
+	}
+	return snapshots
+}
@@ -0,0 +1,115 @@
+package probe
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIntervalTotalPercentiles(t *testing.T) {
+	in := NewInterval("test", []float64{0, 0.5, 1})
+	in.SetTotalResolution(0.01)
+
+	now := time.Now()
+	for d := time.Duration(1); d <= 100; d++ {
+		in.Add(d*time.Millisecond, now)
+	}
+	in.Next(now)
+	in.Add(time.Second, now)
+
+	s := in.Snapshot()
+	if s.Interval.Count != 1 {
+		t.Errorf("interval count = %d, want 1", s.Interval.Count)
+	}
+	if s.Total.Count != 101 {
+		t.Errorf("total count = %d, want 101", s.Total.Count)
+	}
+	if len(s.Total.Values) != 3 {
+		t.Fatalf("total values = %v, want 3", s.Total.Values)
+	}
+	if got := s.Total.Values[0]; got != time.Millisecond {
+		t.Errorf("total min = %v, want 1ms", got)
+	}
+	if got := s.Total.Values[1]; got < 50*time.Millisecond || got > 52*time.Millisecond {
+		t.Errorf("total p50 = %v, want about 51ms", got)
+	}
+	if got := s.Total.Values[2]; got != time.Second {
+		t.Errorf("total max = %v, want 1s", got)
+	}
+}
+
+func TestIntervalWithoutTotal(t *testing.T) {
+	in := NewInterval("test", []float64{0.5})
+	in.Add(time.Millisecond, time.Now())
+	if s := in.Snapshot(); s.Total.Values != nil {
+		t.Errorf("total values = %v, want none without SetTotalResolution", s.Total.Values)
+	}
+}
+
+func TestCollectorStopWithoutStart(t *testing.T) {
+	c := NewCollector(time.Minute, []float64{0.5}, &Sleep{Interval: time.Millisecond})
+	c.Stop()
+}
+
+func BenchmarkIntervalAdd(b *testing.B) {
+	tests := []struct {
+		name string
+		in   func(n int) *Interval
+	}{
+		{"samples", func(n int) *Interval {
+			in := NewInterval("bench", []float64{0, 0.5, 0.99, 1})
+			in.Reserve(n)
+			return in
+		}},
+		{"bucketed", func(n int) *Interval {
+			return NewBucketedInterval("bench", []float64{0, 0.5, 0.99, 1}, 0.01)
+		}},
+		{"total", func(n int) *Interval {
+			in := NewInterval("bench", []float64{0, 0.5, 0.99, 1})
+			in.SetTotalResolution(0.01)
+			in.Reserve(n)
+			return in
+		}},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			in := tt.in(b.N)
+			now := time.Now()
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				in.Add(time.Duration(n%1000)*time.Microsecond, now)
+			}
+		})
+	}
+}
+
+// TestIntervalAllocs checks the allocation budget of the sample loop: none
+// per sample once the interval is reserved, and one per report, for the
+// percentiles' values, once its buffers have grown to an interval's
+// samples.
+func TestIntervalAllocs(t *testing.T) {
+	const samples = 1000
+	in := NewInterval("allocs", []float64{0, 0.5, 0.99, 1})
+	in.Reserve(samples)
+	now := time.Now()
+
+	add := testing.AllocsPerRun(samples, func() {
+		in.Add(time.Millisecond, now)
+	})
+	if add != 0 {
+		t.Errorf("Add allocated %v times per sample, want 0", add)
+	}
+
+	// Grow the spare buffer the next interval takes.
+	in.Next(now)
+	next := testing.AllocsPerRun(10, func() {
+		for n := 0; n < samples; n++ {
+			in.Add(time.Duration(n), now)
+		}
+		in.Next(now)
+	})
+	if next > 1 {
+		t.Errorf("an interval of %v samples allocated %v times, want at most 1", samples, next)
+	}
+}
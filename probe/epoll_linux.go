@@ -0,0 +1,78 @@
+//go:build linux
+
+package probe
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// EpollWait measures how much later than its timeout an epoll_wait with no
+// ready file descriptors returns, from a locked OS thread. Go's timers are
+// mostly serviced by the netpoller's epoll_wait, so it's a reference for
+// the Go timer probes on Linux: lateness it shares with them is from the
+// kernel, and the rest is from the Go runtime.
+//
+// epoll_wait's timeout is in milliseconds, so the Interval is rounded up to
+// a whole number of them.
+//
+// Each Run creates its own epoll instance, and closes it when it returns.
+// If it can't be created, Run counts a failed attempt and returns.
+type EpollWait struct {
+	Interval time.Duration
+
+	failed atomic.Uint64
+	runner
+}
+
+// Name returns "epoll_wait delay".
+func (p *EpollWait) Name() string { return "epoll_wait delay" }
+
+// Failed returns the number of epoll instances Run failed to create.
+func (p *EpollWait) Failed() uint64 { return p.failed.Load() }
+
+// Target returns the Interval, rounded up to a whole millisecond.
+func (p *EpollWait) Target() time.Duration {
+	return (p.Interval + time.Millisecond - 1).Truncate(time.Millisecond)
+}
+
+// Run takes samples until ctx is done.
+func (p *EpollWait) Run(ctx context.Context, r Recorder) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		p.failed.Add(1)
+		return
+	}
+	defer syscall.Close(epfd)
+
+	// Nothing is ever added to the epoll instance, so the events are never
+	// filled in, but epoll_wait needs room for one.
+	events := make([]syscall.EpollEvent, 1)
+	timeout := p.Target()
+	for ctx.Err() == nil {
+		r.Start()
+		start := time.Now()
+		deadline := start.Add(timeout)
+		// A signal, such as the runtime's preemption signal, ends the wait
+		// early, so it's resumed for the rest of the timeout.
+		for wait := timeout; wait > 0; wait = time.Until(deadline) {
+			ms := int((wait + time.Millisecond - 1) / time.Millisecond)
+			if _, err := syscall.EpollWait(epfd, events, ms); err != syscall.EINTR {
+				break
+			}
+		}
+		stop := time.Now()
+		r.Add(stop.Sub(deadline), stop)
+	}
+}
+
+// Start runs the probe in the background until Stop.
+func (p *EpollWait) Start(r Recorder) {
+	p.start(func(ctx context.Context) { p.Run(ctx, r) })
+}
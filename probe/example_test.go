@@ -0,0 +1,72 @@
+package probe_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"sched-latency/probe"
+)
+
+func ExampleCollector() {
+	c := probe.NewCollector(time.Minute, []float64{0.5, 0.99, 1},
+		&probe.Sleep{Interval: time.Millisecond},
+		&probe.Timer{Interval: time.Millisecond})
+	c.Start()
+	time.Sleep(100 * time.Millisecond)
+	c.Stop()
+
+	for _, s := range c.Snapshot() {
+		fmt.Printf("%v: sampled %v, cumulative %v\n",
+			s.Interval.Probe, s.Total.Count > 0, s.Total.Percentiles)
+	}
+	// Output:
+	// time.Sleep delay: sampled true, cumulative [0.5 0.99 1]
+	// timer delay: sampled true, cumulative [0.5 0.99 1]
+}
+
+// This example serves a collector's snapshots from another program's HTTP
+// handler, as a latency canary would.
+func ExampleCollector_handler() {
+	c := probe.NewCollector(time.Minute, []float64{0.5, 0.99, 1},
+		&probe.Sleep{Interval: time.Millisecond})
+	c.Start()
+	defer c.Stop()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latency", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Snapshot())
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	resp, err := http.Get(server.URL + "/latency")
+	if err != nil {
+		fmt.Println("request failed:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var snapshots []struct {
+		Interval struct {
+			Probe string `json:"probe"`
+		} `json:"interval"`
+		Total struct {
+			Count       uint64    `json:"count"`
+			Percentiles []float64 `json:"percentiles"`
+		} `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&snapshots); err != nil {
+		fmt.Println("invalid response:", err)
+		return
+	}
+	for _, s := range snapshots {
+		fmt.Printf("%v: sampled %v, cumulative %v\n", s.Interval.Probe, s.Total.Count > 0, s.Total.Percentiles)
+	}
+	// Output:
+	// time.Sleep delay: sampled true, cumulative [0.5 0.99 1]
+}
@@ -0,0 +1,105 @@
+//go:build linux
+
+package probe
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// futex operations, from linux/futex.h, on a word private to the process.
+const (
+	futexWaitPrivate = 0 | 128
+	futexWakePrivate = 1 | 128
+)
+
+// FutexWake measures how long a thread blocked in FUTEX_WAIT takes to
+// resume after another thread's FUTEX_WAKE, every Interval. Goroutines park
+// and unpark through futexes on Linux, so it's a reference for the Go
+// wakeup probes: lateness it shares with them is from the kernel, and the
+// rest is from the Go runtime. Both the waiter and the waker run on locked
+// OS threads, which the probe releases once it stops.
+//
+// The waiter only resumes from a wake if it's parked by then, which it is
+// unless handling the last wake took longer than the Interval.
+type FutexWake struct {
+	Interval time.Duration
+
+	runner
+}
+
+// Name returns "futex wake".
+func (p *FutexWake) Name() string { return "futex wake" }
+
+// futexWait blocks while the word is val, or until a signal or spurious
+// wakeup, since the caller checks the word again.
+func futexWait(addr *uint32, val uint32) {
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWaitPrivate, uintptr(val), 0, 0, 0)
+}
+
+// futexWake wakes a thread waiting on the word.
+func futexWake(addr *uint32) {
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWakePrivate, 1, 0, 0, 0)
+}
+
+// Run takes samples until ctx is done.
+func (p *FutexWake) Run(ctx context.Context, r Recorder) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// word is set to 1 to wake the waiter, which sets it back to 0 before
+	// waiting again. woken is when the waker last woke it, since start.
+	var (
+		word    uint32
+		woken   atomic.Int64
+		stopped atomic.Bool
+	)
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		for {
+			r.Start()
+			for atomic.LoadUint32(&word) == 0 {
+				futexWait(&word, 0)
+			}
+			resumed := time.Since(start)
+			if stopped.Load() {
+				return
+			}
+			r.Add(resumed-time.Duration(woken.Load()), start.Add(resumed))
+			atomic.StoreUint32(&word, 0)
+		}
+	}()
+
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+	wake := func() {
+		woken.Store(int64(time.Since(start)))
+		atomic.StoreUint32(&word, 1)
+		futexWake(&word)
+	}
+	for {
+		select {
+		case <-t.C:
+			wake()
+		case <-ctx.Done():
+			stopped.Store(true)
+			wake()
+			<-done
+			return
+		}
+	}
+}
+
+// Start runs the probe in the background until Stop.
+func (p *FutexWake) Start(r Recorder) {
+	p.start(func(ctx context.Context) { p.Run(ctx, r) })
+}
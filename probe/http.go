@@ -0,0 +1,96 @@
+package probe
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPLoopback measures the latency of HTTP requests to a trivial handler
+// on a server in the process, which is what users of a Go server feel. It
+// adds the netpoller's wakeups, the scheduling of the server's and the
+// client's goroutines, and parsing the request and response, to the
+// scheduler latency the lower-level probes see.
+//
+// Every Interval, it sends a request over a kept-alive loopback
+// connection, and the delay is from sending it until the whole response
+// has been read.
+type HTTPLoopback struct {
+	Interval time.Duration
+
+	ln     net.Listener
+	url    string
+	failed atomic.Uint64
+
+	runner
+}
+
+// NewHTTPLoopback returns the probe, with its server listening on a
+// loopback port. The server runs, and is closed, by Run.
+func NewHTTPLoopback(interval time.Duration) (*HTTPLoopback, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPLoopback{Interval: interval, ln: ln, url: "http://" + ln.Addr().String() + "/"}, nil
+}
+
+// Name returns "http loopback".
+func (p *HTTPLoopback) Name() string { return "http loopback" }
+
+// Failed returns the number of requests that failed, which have no sample.
+func (p *HTTPLoopback) Failed() uint64 { return p.failed.Load() }
+
+// Run takes samples until ctx is done.
+func (p *HTTPLoopback) Run(ctx context.Context, r Recorder) {
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "ok\n")
+	})}
+	go server.Serve(p.ln)
+	defer server.Close()
+
+	// The transport is the probe's own, without a proxy, so every request
+	// reuses the same loopback connection.
+	transport := &http.Transport{DisableCompression: true, MaxIdleConnsPerHost: 1}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	wait := time.NewTimer(p.Interval)
+	defer wait.Stop()
+	for {
+		select {
+		case <-wait.C:
+		case <-ctx.Done():
+			return
+		}
+
+		r.Start()
+		start := time.Now()
+		if err := p.get(client); err != nil {
+			p.failed.Add(1)
+		} else {
+			now := time.Now()
+			r.Add(now.Sub(start), now)
+		}
+		wait.Reset(p.Interval)
+	}
+}
+
+// get sends a request and reads the whole response.
+func (p *HTTPLoopback) get(client *http.Client) error {
+	resp, err := client.Get(p.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// Start runs the probe in the background until Stop.
+func (p *HTTPLoopback) Start(r Recorder) {
+	p.start(func(ctx context.Context) { p.Run(ctx, r) })
+}
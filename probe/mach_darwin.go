@@ -0,0 +1,58 @@
+//go:build darwin && cgo && machwait
+
+package probe
+
+// #include <mach/mach_time.h>
+import "C"
+
+import (
+	"context"
+	"runtime"
+	"time"
+)
+
+// MachWait measures how much later than Interval a mach_wait_until wakes,
+// waiting at the Mach layer from a locked OS thread. It's a reference for
+// the Go timer probes on macOS: lateness it shares with them is from the
+// XNU scheduler, and the rest is from the Go runtime.
+//
+// It needs cgo, so it's only built with the machwait build tag, e.g.,
+// go build -tags machwait.
+type MachWait struct {
+	Interval time.Duration
+
+	runner
+}
+
+// Name returns "mach_wait_until delay".
+func (p *MachWait) Name() string { return "mach_wait_until delay" }
+
+// Target returns the Interval.
+func (p *MachWait) Target() time.Duration { return p.Interval }
+
+// Run takes samples until ctx is done.
+func (p *MachWait) Run(ctx context.Context, r Recorder) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// Mach absolute time is in ticks of numer/denom nanoseconds.
+	var timebase C.mach_timebase_info_data_t
+	C.mach_timebase_info(&timebase)
+	numer, denom := uint64(timebase.numer), uint64(timebase.denom)
+	interval := uint64(p.Interval) * denom / numer
+
+	for ctx.Err() == nil {
+		r.Start()
+		deadline := uint64(C.mach_absolute_time()) + interval
+		C.mach_wait_until(C.uint64_t(deadline))
+		stop := uint64(C.mach_absolute_time())
+		at := time.Now()
+		// The wakeup can't be early, but keep the sign in case it is.
+		r.Add(time.Duration(int64(stop-deadline)*int64(numer)/int64(denom)), at)
+	}
+}
+
+// Start runs the probe in the background until Stop.
+func (p *MachWait) Start(r Recorder) {
+	p.start(func(ctx context.Context) { p.Run(ctx, r) })
+}
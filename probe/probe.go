@@ -0,0 +1,414 @@
+// Package probe measures how late goroutines run compared to when they
+// should, with probes that each take a stream of delay samples.
+package probe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Probe takes delay samples until its context is done.
+type Probe interface {
+	// Name identifies the probe in reports, e.g., "timer delay".
+	Name() string
+
+	// Run takes samples, passing each one to r, until ctx is done. It
+	// should return within about one sample of ctx being done.
+	Run(ctx context.Context, r Recorder)
+}
+
+var registry = struct {
+	sync.Mutex
+	probes []Probe
+}{}
+
+// Register adds a probe to the ones run by the sched-latency command, or by
+// any other user of Registered. It panics if a probe with the same name is
+// already registered.
+func Register(p Probe) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, r := range registry.probes {
+		if r.Name() == p.Name() {
+			panic(fmt.Sprintf("probe: %q is already registered", p.Name()))
+		}
+	}
+	registry.probes = append(registry.probes, p)
+}
+
+// Registered returns the registered probes in the order they were
+// registered.
+func Registered() []Probe {
+	registry.Lock()
+	defer registry.Unlock()
+	return append([]Probe(nil), registry.probes...)
+}
+
+// Recorder receives the samples taken by a probe. Its methods are called
+// from the probe's goroutine, so they should be quick.
+type Recorder interface {
+	// Start is called just before each sample is taken.
+	Start()
+
+	// Add is called with each sample's delay and when it was taken. The
+	// delay can be negative, e.g., if a timer fires early or the wall clock
+	// is stepped back. Recorders keep such samples rather than clamping or
+	// dropping them, and count them so they can be reported.
+	Add(delay time.Duration, at time.Time)
+}
+
+// Targeted is implemented by probes whose samples are how late a wait for
+// a requested interval ends, so they can be compared to the interval.
+type Targeted interface {
+	// Target returns the interval the probe waits for.
+	Target() time.Duration
+}
+
+// runner runs a probe on its own goroutine between Start and Stop.
+type runner struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (r *runner) start(run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		run(ctx)
+	}()
+}
+
+// Stop stops the probe started by Start, waiting for the sample in progress
+// to finish. It does nothing if the probe wasn't started.
+func (r *runner) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+// Sleep measures how much longer than Interval a sleep takes. It sleeps on
+// a timer, as time.Sleep does, but selects on it with ctx so it stops as
+// soon as ctx is done rather than after the rest of the Interval.
+type Sleep struct {
+	Interval time.Duration
+
+	runner
+}
+
+// Name returns "time.Sleep delay".
+func (p *Sleep) Name() string { return "time.Sleep delay" }
+
+// Target returns the Interval.
+func (p *Sleep) Target() time.Duration { return p.Interval }
+
+// Run takes samples until ctx is done.
+func (p *Sleep) Run(ctx context.Context, r Recorder) {
+	t := time.NewTimer(time.Second)
+	if !t.Stop() {
+		<-t.C
+	}
+
+	for ctx.Err() == nil {
+		r.Start()
+		start := time.Now()
+		if !sleep(ctx, t, p.Interval) {
+			return
+		}
+		stop := time.Now()
+		r.Add(stop.Sub(start)-p.Interval, stop)
+	}
+}
+
+// sleep waits on the stopped timer t for d, returning false, with t
+// stopped, if ctx is done first.
+func sleep(ctx context.Context, t *time.Timer, d time.Duration) bool {
+	t.Reset(d)
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		if !t.Stop() {
+			<-t.C
+		}
+		return false
+	}
+}
+
+// Start runs the probe in the background until Stop.
+func (p *Sleep) Start(r Recorder) {
+	p.start(func(ctx context.Context) { p.Run(ctx, r) })
+}
+
+// Timer measures how much later than Interval a timer fires.
+type Timer struct {
+	Interval time.Duration
+
+	runner
+}
+
+// Name returns "timer delay".
+func (p *Timer) Name() string { return "timer delay" }
+
+// Target returns the Interval.
+func (p *Timer) Target() time.Duration { return p.Interval }
+
+// Run takes samples until ctx is done.
+func (p *Timer) Run(ctx context.Context, r Recorder) {
+	// Create a timer to reuse.
+	t := time.NewTimer(time.Second)
+	if !t.Stop() {
+		<-t.C
+	}
+
+	for ctx.Err() == nil {
+		r.Start()
+		start := time.Now()
+		t.Reset(p.Interval)
+		var stop time.Time
+		select {
+		case stop = <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+		r.Add(stop.Sub(start)-p.Interval, stop)
+	}
+}
+
+// Start runs the probe in the background until Stop.
+func (p *Timer) Start(r Recorder) {
+	p.start(func(ctx context.Context) { p.Run(ctx, r) })
+}
+
+// SpinWait measures how much later than Interval a busy wait on time.Now
+// ends. It never parks or uses timers, so its lateness is only from reading
+// the clock and being preempted, which is a floor for the Sleep and Timer
+// probes at the same interval. It keeps a CPU busy while it runs.
+type SpinWait struct {
+	Interval time.Duration
+
+	runner
+}
+
+// Name returns "spin wait delay".
+func (p *SpinWait) Name() string { return "spin wait delay" }
+
+// Target returns the Interval.
+func (p *SpinWait) Target() time.Duration { return p.Interval }
+
+// Run takes samples until ctx is done.
+func (p *SpinWait) Run(ctx context.Context, r Recorder) {
+	for ctx.Err() == nil {
+		r.Start()
+		deadline := time.Now().Add(p.Interval)
+		now := time.Now()
+		for now.Before(deadline) {
+			now = time.Now()
+		}
+		r.Add(now.Sub(deadline), now)
+	}
+}
+
+// Start runs the probe in the background until Stop.
+func (p *SpinWait) Start(r Recorder) {
+	p.start(func(ctx context.Context) { p.Run(ctx, r) })
+}
+
+// SleepSpin measures how much later than Interval a wait ends when it
+// sleeps until Guard before the deadline and then spins on time.Now for the
+// rest, as latency-critical code sometimes does. The spinning keeps a CPU
+// busy for up to Guard of every Interval, which Spinning reports.
+type SleepSpin struct {
+	Interval time.Duration
+	Guard    time.Duration
+
+	spun   atomic.Int64
+	waited atomic.Int64
+
+	runner
+}
+
+// Name returns "sleep+spin delay".
+func (p *SleepSpin) Name() string { return "sleep+spin delay" }
+
+// Target returns the Interval.
+func (p *SleepSpin) Target() time.Duration { return p.Interval }
+
+// Spinning returns how long the probe has spent spinning, and waiting in
+// total, since it started.
+func (p *SleepSpin) Spinning() (spun, waited time.Duration) {
+	return time.Duration(p.spun.Load()), time.Duration(p.waited.Load())
+}
+
+// Run takes samples until ctx is done.
+func (p *SleepSpin) Run(ctx context.Context, r Recorder) {
+	t := time.NewTimer(time.Second)
+	if !t.Stop() {
+		<-t.C
+	}
+
+	for ctx.Err() == nil {
+		r.Start()
+		start := time.Now()
+		deadline := start.Add(p.Interval)
+		if d := p.Interval - p.Guard; d > 0 && !sleep(ctx, t, d) {
+			return
+		}
+		spinStart := time.Now()
+		now := spinStart
+		for now.Before(deadline) {
+			now = time.Now()
+		}
+		p.spun.Add(int64(now.Sub(spinStart)))
+		p.waited.Add(int64(now.Sub(start)))
+		r.Add(now.Sub(deadline), now)
+	}
+}
+
+// Start runs the probe in the background until Stop.
+func (p *SleepSpin) Start(r Recorder) {
+	p.start(func(ctx context.Context) { p.Run(ctx, r) })
+}
+
+// BurstWakeup measures how long it takes for a burst of goroutines that
+// become runnable at the same time to all get to run.
+//
+// Every Interval, Size parked goroutines are released by closing a shared
+// channel, and the delay is from the release until the last of them runs.
+type BurstWakeup struct {
+	Interval time.Duration
+	Size     int
+
+	runner
+}
+
+// Name returns "burst wakeup".
+func (p *BurstWakeup) Name() string { return "burst wakeup" }
+
+// Run takes samples until ctx is done.
+func (p *BurstWakeup) Run(ctx context.Context, r Recorder) {
+	n := p.Size
+	next := make([]chan chan struct{}, n)
+	ran := make([]time.Time, n)
+	var wg sync.WaitGroup
+	for i := range next {
+		i := i
+		next[i] = make(chan chan struct{}, 1)
+		defer close(next[i])
+		go func() {
+			for release := range next[i] {
+				<-release
+				ran[i] = time.Now()
+				wg.Done()
+			}
+		}()
+	}
+
+	// Reuse a timer for the waits between bursts, rather than allocating
+	// one for each with time.After.
+	wait := time.NewTimer(time.Second)
+	if !wait.Stop() {
+		<-wait.C
+	}
+
+	for ctx.Err() == nil {
+		// Hand out the release channel before sleeping, so every goroutine
+		// is parked on it by the time it's closed.
+		release := make(chan struct{})
+		wg.Add(n)
+		for _, c := range next {
+			c <- release
+		}
+		wait.Reset(p.Interval)
+		select {
+		case <-wait.C:
+		case <-ctx.Done():
+			wait.Stop()
+			// Release the parked goroutines so they can exit.
+			close(release)
+			wg.Wait()
+			return
+		}
+
+		r.Start()
+		start := time.Now()
+		close(release)
+		wg.Wait()
+
+		last := start
+		for _, t := range ran {
+			if t.After(last) {
+				last = t
+			}
+		}
+
+		r.Add(last.Sub(start), time.Now())
+	}
+}
+
+// Start runs the probe in the background until Stop.
+func (p *BurstWakeup) Start(r Recorder) {
+	p.start(func(ctx context.Context) { p.Run(ctx, r) })
+}
+
+// allocSize is the size of each of the AllocBurst's allocations.
+const allocSize = 4096
+
+// AllocBurst measures how long allocations stall, e.g., when the allocating
+// goroutine is drafted into GC assist work, which none of the waiting
+// probes see.
+//
+// Every Interval, it times each of Size allocations of 4KiB, which are kept
+// until the next burst, and the delay is the slowest of them. The burst is
+// kept small so it's not much load itself; assist stalls show up when
+// something else allocates heavily.
+type AllocBurst struct {
+	Interval time.Duration
+	Size     int
+
+	runner
+}
+
+// Name returns "alloc latency".
+func (p *AllocBurst) Name() string { return "alloc latency" }
+
+// Run takes samples until ctx is done.
+func (p *AllocBurst) Run(ctx context.Context, r Recorder) {
+	live := make([][]byte, p.Size)
+	wait := time.NewTimer(p.Interval)
+	defer wait.Stop()
+	for {
+		select {
+		case <-wait.C:
+		case <-ctx.Done():
+			return
+		}
+
+		r.Start()
+		var worst time.Duration
+		start := time.Now()
+		for i := range live {
+			live[i] = make([]byte, allocSize)
+			now := time.Now()
+			if d := now.Sub(start); d > worst {
+				worst = d
+			}
+			start = now
+		}
+		r.Add(worst, start)
+		wait.Reset(p.Interval)
+	}
+}
+
+// Start runs the probe in the background until Stop.
+func (p *AllocBurst) Start(r Recorder) {
+	p.start(func(ctx context.Context) { p.Run(ctx, r) })
+}
@@ -0,0 +1,49 @@
+package probe
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEpollWaitStartStop(t *testing.T) {
+	p := &EpollWait{Interval: time.Millisecond}
+	var r countRecorder
+	p.Start(&r)
+	waitSamples(t, &r, 3)
+	p.Stop()
+
+	// Each run has its own epoll instance, so the probe can run again.
+	p.Start(&r)
+	waitSamples(t, &r, 6)
+	p.Stop()
+	if n := p.Failed(); n != 0 {
+		t.Errorf("Failed() = %v, want 0", n)
+	}
+}
+
+func TestFutexWakeStartStop(t *testing.T) {
+	p := &FutexWake{Interval: time.Millisecond}
+	var r countRecorder
+	p.Start(&r)
+	waitSamples(t, &r, 3)
+	p.Stop()
+}
+
+func TestFutexWakeRunCancel(t *testing.T) {
+	p := &FutexWake{Interval: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx, &countRecorder{})
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run didn't return after ctx was canceled")
+	}
+}
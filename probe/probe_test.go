@@ -0,0 +1,233 @@
+package probe
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// namedProbe is a probe that takes no samples, for testing the registry.
+type namedProbe string
+
+func (p namedProbe) Name() string { return string(p) }
+
+func (p namedProbe) Run(ctx context.Context, r Recorder) { <-ctx.Done() }
+
+func TestRegister(t *testing.T) {
+	before := len(Registered())
+	Register(namedProbe("test register a"))
+	Register(namedProbe("test register b"))
+
+	got := Registered()
+	if len(got) != before+2 {
+		t.Fatalf("Registered() returned %d probes, want %d", len(got), before+2)
+	}
+	if a, b := got[before].Name(), got[before+1].Name(); a != "test register a" || b != "test register b" {
+		t.Errorf("Registered() ends with %q, %q, want them in the order they were registered", a, b)
+	}
+
+	// The returned slice is a copy.
+	got[before] = namedProbe("replaced")
+	if name := Registered()[before].Name(); name != "test register a" {
+		t.Errorf("modifying Registered()'s result changed the registry to %q", name)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	Register(namedProbe("test register duplicate"))
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Register with a duplicate name didn't panic")
+		}
+	}()
+	Register(namedProbe("test register duplicate"))
+}
+
+func TestTargets(t *testing.T) {
+	tests := []struct {
+		probe Targeted
+		want  time.Duration
+	}{
+		{&Sleep{Interval: time.Millisecond}, time.Millisecond},
+		{&Timer{Interval: 2 * time.Millisecond}, 2 * time.Millisecond},
+		{&SpinWait{Interval: 3 * time.Millisecond}, 3 * time.Millisecond},
+		{&SleepSpin{Interval: 4 * time.Millisecond, Guard: time.Millisecond}, 4 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := tt.probe.Target(); got != tt.want {
+			t.Errorf("%T.Target() = %v, want %v", tt.probe, got, tt.want)
+		}
+	}
+}
+
+// countRecorder counts the samples it's passed.
+type countRecorder struct {
+	mu      sync.Mutex
+	started int
+	added   int
+}
+
+func (r *countRecorder) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started++
+}
+
+func (r *countRecorder) Add(delay time.Duration, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.added++
+}
+
+func (r *countRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.added
+}
+
+// waitSamples waits for the recorder to have n samples.
+func waitSamples(t *testing.T, r *countRecorder, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for r.count() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d samples, want at least %d", r.count(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// startStopper is a probe run by its own Start and Stop.
+type startStopper interface {
+	Probe
+	Start(r Recorder)
+	Stop()
+}
+
+// testProbes returns one of each of the portable probes, with the given
+// interval.
+func testProbes(interval time.Duration) []startStopper {
+	return []startStopper{
+		&Sleep{Interval: interval},
+		&Timer{Interval: interval},
+		&SpinWait{Interval: interval},
+		&SleepSpin{Interval: interval, Guard: interval / 2},
+		&BurstWakeup{Interval: interval, Size: 4},
+		&AllocBurst{Interval: interval, Size: 4},
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	for _, p := range testProbes(time.Millisecond) {
+		t.Run(p.Name(), func(t *testing.T) {
+			var r countRecorder
+			p.Start(&r)
+			waitSamples(t, &r, 3)
+			p.Stop()
+
+			// No samples are taken once Stop returns.
+			n := r.count()
+			time.Sleep(10 * time.Millisecond)
+			if got := r.count(); got != n {
+				t.Errorf("got %d samples after Stop, had %d", got, n)
+			}
+		})
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	for _, p := range testProbes(time.Millisecond) {
+		p.Stop()
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	// The probes that wait between samples must stop as soon as ctx is
+	// done, rather than after the rest of a long interval.
+	probes := []Probe{
+		&Sleep{Interval: time.Hour},
+		&Timer{Interval: time.Hour},
+		&SleepSpin{Interval: time.Hour, Guard: time.Millisecond},
+		&BurstWakeup{Interval: time.Hour, Size: 4},
+		&AllocBurst{Interval: time.Hour, Size: 4},
+	}
+	for _, p := range probes {
+		t.Run(p.Name(), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				p.Run(ctx, &countRecorder{})
+			}()
+
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Run didn't return after ctx was canceled")
+			}
+		})
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, p := range testProbes(time.Hour) {
+		var r countRecorder
+		p.Run(ctx, &r)
+		if r.count() != 0 {
+			t.Errorf("%v took %d samples with ctx already done", p.Name(), r.count())
+		}
+	}
+}
+
+func TestHTTPLoopbackStartStop(t *testing.T) {
+	p, err := NewHTTPLoopback(time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewHTTPLoopback: %v", err)
+	}
+	var r countRecorder
+	p.Start(&r)
+	waitSamples(t, &r, 3)
+	p.Stop()
+	if p.Failed() != 0 {
+		t.Errorf("Failed() = %d, want 0", p.Failed())
+	}
+}
+
+// BenchmarkSleepBody is an iteration of the Sleep probe's loop without the
+// sleep itself: reading the clock and recording the sample.
+func BenchmarkSleepBody(b *testing.B) {
+	in := NewInterval("bench", []float64{0, 0.5, 0.99, 1})
+	in.Reserve(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		in.Start()
+		start := time.Now()
+		stop := time.Now()
+		in.Add(stop.Sub(start), stop)
+	}
+}
+
+// BenchmarkTimerReset is the timer reset and receive that the Sleep and
+// Timer probes wait on, with a timer that fires immediately.
+func BenchmarkTimerReset(b *testing.B) {
+	ctx := context.Background()
+	t := time.NewTimer(time.Second)
+	if !t.Stop() {
+		<-t.C
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		if !sleep(ctx, t, 0) {
+			b.Fatal("sleep returned early")
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package report
+
+import (
+	"fmt"
+	"time"
+)
+
+// CPUClasses is how the process's CPU time was spent over an interval, as
+// fractions of the total CPU time available to it, from the runtime's
+// /cpu/classes metrics.
+type CPUClasses struct {
+	User     float64 `json:"user"`
+	GC       float64 `json:"gc"`
+	Scavenge float64 `json:"scavenge"`
+	Idle     float64 `json:"idle"`
+
+	// Span is the wall time the fractions are over. The runtime only
+	// updates the metrics at each GC, so it can be longer than the
+	// interval.
+	Span time.Duration `json:"span_ns"`
+}
+
+// String formats the fractions as percentages, e.g.,
+// "user 86% gc 9% scavenge 0% idle 5%".
+func (c CPUClasses) String() string {
+	return fmt.Sprintf("user %.0f%% gc %.0f%% scavenge %.0f%% idle %.0f%%", 100*c.User, 100*c.GC, 100*c.Scavenge, 100*c.Idle)
+}
@@ -0,0 +1,265 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sched-latency/stats"
+)
+
+// Formatter maps a result to the bytes written for it, separately from
+// where they're written.
+type Formatter interface {
+	Format(r Result) ([]byte, error)
+}
+
+// AppendFormatter is a Formatter that can append a result's bytes to a
+// buffer, which sinks reuse rather than allocating one for every result.
+type AppendFormatter interface {
+	Formatter
+	AppendFormat(b []byte, r Result) ([]byte, error)
+}
+
+// TextFormatter formats results as aligned lines for people to read, with
+// the probe's name right-aligned, then the percentiles, the tail counts, the
+// number of negative delays, if any, and any extras.
+type TextFormatter struct{}
+
+func (f TextFormatter) Format(r Result) ([]byte, error) {
+	return f.AppendFormat(nil, r)
+}
+
+// AppendFormat implements AppendFormatter.
+func (TextFormatter) AppendFormat(b []byte, r Result) ([]byte, error) {
+	b = appendPadded(b, r.Probe, 20)
+	b = append(b, ": "...)
+	if notes := textNotes(r); notes != nil {
+		b = append(b, FormatAnnotated(r.Percentiles, r.Values, notes)...)
+	} else {
+		b = appendNamed(b, r.Percentiles, r.Values)
+	}
+	if r.Tail != nil {
+		b = append(b, ' ')
+		b = appendTail(b, r.Thresholds, r.Tail)
+	}
+	if r.Negative > 0 {
+		b = append(b, " negative "...)
+		b = strconv.AppendUint(b, r.Negative, 10)
+	}
+	for _, e := range r.Extras {
+		b = append(b, ' ')
+		b = append(b, e...)
+	}
+	if r.Label != "" {
+		b = append(b, " (label "...)
+		b = append(b, r.Label...)
+		b = append(b, ')')
+	}
+	return append(b, '\n'), nil
+}
+
+// textNotes returns the notes for each of the result's values, with its
+// moving average and its percentage of the target, or nil if it has
+// neither.
+func textNotes(r Result) []string {
+	ratios := r.Ratios()
+	if r.Smoothed == nil && ratios == nil {
+		return nil
+	}
+	notes := make([]string, len(r.Values))
+	for i := range notes {
+		var parts []string
+		if i < len(r.Smoothed) {
+			parts = append(parts, "~"+stats.Truncate(r.Smoothed[i]).String())
+		}
+		if i < len(ratios) {
+			parts = append(parts, fmt.Sprintf("%+.1f%%", ratios[i]*100))
+		}
+		notes[i] = strings.Join(parts, ", ")
+	}
+	return notes
+}
+
+// JSONFormatter formats each result as a line of JSON.
+type JSONFormatter struct{}
+
+func (JSONFormatter) Format(r Result) ([]byte, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// CSVFormatter formats results as CSV rows, with a header row before the
+// first result. The columns are from the first result's percentiles, tags,
+// tail thresholds, and whether it has a label, moving averages, relative
+// values and a CPU breakdown, so each output needs its own CSVFormatter.
+type CSVFormatter struct {
+	// buf and w are reused for every result.
+	buf bytes.Buffer
+	w   *csv.Writer
+
+	percentiles []float64
+	tags        []string
+	thresholds  []time.Duration
+	labeled     bool
+	smoothed    bool
+	relative    bool
+	cpu         bool
+	buckets     bool
+}
+
+func (c *CSVFormatter) Format(r Result) ([]byte, error) {
+	return c.AppendFormat(nil, r)
+}
+
+// AppendFormat implements AppendFormatter.
+func (c *CSVFormatter) AppendFormat(b []byte, r Result) ([]byte, error) {
+	if c.w == nil {
+		c.w = csv.NewWriter(&c.buf)
+	}
+	c.buf.Reset()
+	w := c.w
+	if c.percentiles == nil {
+		c.percentiles = r.Percentiles
+		c.tags = r.Tags.Keys()
+		c.labeled = r.Label != ""
+		c.smoothed = r.Smoothed != nil
+		c.relative = r.Relative
+		c.thresholds = r.Thresholds
+		c.cpu = r.CPU != nil
+		c.buckets = r.RawBuckets
+		var header []string
+		if c.labeled {
+			header = append(header, "label")
+		}
+		header = append(header, c.tags...)
+		header = append(header, "probe", "start", "end", "duration_ns", "count", "negative")
+		for _, p := range c.percentiles {
+			header = append(header, stats.PercentileName(p)+"_ns")
+		}
+		if c.smoothed {
+			for _, p := range c.percentiles {
+				header = append(header, stats.PercentileName(p)+"_smoothed_ns")
+			}
+		}
+		if c.relative {
+			header = append(header, "target_ns")
+			for _, p := range c.percentiles {
+				header = append(header, stats.PercentileName(p)+"_relative")
+			}
+		}
+		for _, t := range c.thresholds {
+			header = append(header, "over_"+t.String())
+		}
+		if c.cpu {
+			header = append(header, "cpu_user", "cpu_gc", "cpu_scavenge", "cpu_idle", "cpu_span_ns")
+		}
+		if c.buckets {
+			header = append(header, "buckets")
+		}
+		header = append(header, "partial", "warmup", "anomaly", "extras")
+		w.Write(header)
+	}
+
+	var row []string
+	if c.labeled {
+		row = append(row, r.Label)
+	}
+	for _, k := range c.tags {
+		row = append(row, r.Tags[k])
+	}
+	row = append(row,
+		r.Probe,
+		r.Start.Format(time.RFC3339Nano),
+		r.End().Format(time.RFC3339Nano),
+		strconv.FormatInt(int64(r.Duration), 10),
+		strconv.FormatUint(r.Count, 10),
+		strconv.FormatUint(r.Negative, 10),
+	)
+	for i := range c.percentiles {
+		var v string
+		if i < len(r.Values) {
+			v = strconv.FormatInt(int64(r.Values[i]), 10)
+		}
+		row = append(row, v)
+	}
+	if c.smoothed {
+		for i := range c.percentiles {
+			var v string
+			if i < len(r.Smoothed) {
+				v = strconv.FormatInt(int64(r.Smoothed[i]), 10)
+			}
+			row = append(row, v)
+		}
+	}
+	if c.relative {
+		// Probes without a target leave the columns empty.
+		ratios := r.Ratios()
+		var target string
+		if ratios != nil {
+			target = strconv.FormatInt(int64(r.Target), 10)
+		}
+		row = append(row, target)
+		for i := range c.percentiles {
+			var v string
+			if i < len(ratios) {
+				v = strconv.FormatFloat(ratios[i], 'f', -1, 64)
+			}
+			row = append(row, v)
+		}
+	}
+	for i := range c.thresholds {
+		var v string
+		if i < len(r.Tail) {
+			v = strconv.FormatUint(r.Tail[i], 10)
+		}
+		row = append(row, v)
+	}
+	if c.cpu {
+		// Partial intervals have no breakdown, leaving the columns empty.
+		cpu := make([]string, 5)
+		if r.CPU != nil {
+			for i, v := range []float64{r.CPU.User, r.CPU.GC, r.CPU.Scavenge, r.CPU.Idle} {
+				cpu[i] = strconv.FormatFloat(v, 'f', 4, 64)
+			}
+			cpu[4] = strconv.FormatInt(int64(r.CPU.Span), 10)
+		}
+		row = append(row, cpu...)
+	}
+	if c.buckets {
+		row = append(row, csvBuckets(r.Buckets))
+	}
+	row = append(row, strconv.FormatBool(r.Partial), strconv.FormatBool(r.Warmup), strconv.FormatBool(r.Anomaly), strings.Join(r.Extras, "; "))
+	w.Write(row)
+	w.Flush()
+	return append(b, c.buf.Bytes()...), w.Error()
+}
+
+// csvBuckets formats buckets for a CSV column as space-separated
+// "lower_ns:upper_ns:count" triples, with infinite bounds left empty, e.g.,
+// "0:64:12 64:128:3 1048576::1".
+func csvBuckets(buckets []Bucket) string {
+	var b []byte
+	for i, bucket := range buckets {
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		if ns, ok := boundNs(bucket.Lower); ok {
+			b = strconv.AppendInt(b, ns, 10)
+		}
+		b = append(b, ':')
+		if ns, ok := boundNs(bucket.Upper); ok {
+			b = strconv.AppendInt(b, ns, 10)
+		}
+		b = append(b, ':')
+		b = strconv.AppendUint(b, bucket.Count, 10)
+	}
+	return string(b)
+}
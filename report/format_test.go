@@ -0,0 +1,157 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata")
+
+func TestTextFormatterGolden(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []Result
+	}{
+		{
+			name: "default_percentiles",
+			results: []Result{
+				{Probe: "time.Sleep delay", Percentiles: defaultPercentiles, Values: []time.Duration{52 * time.Microsecond, 1100 * time.Microsecond, 2 * time.Millisecond, 3 * time.Millisecond}, Count: 64},
+				{Probe: "/sched/latencies", Percentiles: defaultPercentiles, Values: []time.Duration{64, 128, 8192, 65536}, Count: 1000},
+			},
+		},
+		{
+			name: "custom_percentiles",
+			results: []Result{
+				{Probe: "timer delay", Percentiles: []float64{0.5, 0.9, 0.999}, Values: []time.Duration{900, 1500, 4200}, Count: 1000},
+				{Probe: "timer delay", Percentiles: []float64{0.25, 0.75, 0.9999}, Values: []time.Duration{700, 1200, 9 * time.Millisecond}, Count: 10000},
+			},
+		},
+		{
+			name: "zero_samples",
+			results: []Result{
+				{Probe: "time.Sleep delay", Percentiles: defaultPercentiles, Values: make([]time.Duration, len(defaultPercentiles))},
+				{Probe: "epoll_wait delay", Percentiles: defaultPercentiles, Thresholds: []time.Duration{time.Millisecond}, Tail: []uint64{0}},
+			},
+		},
+		{
+			name: "truncation",
+			results: []Result{
+				{Probe: "time.Sleep delay", Percentiles: defaultPercentiles, Values: []time.Duration{1234, 56789, 1234567, 12345678901}, Count: 4},
+				{Probe: "a probe with a name over the width", Percentiles: []float64{0.999}, Values: []time.Duration{999999}, Count: 1},
+			},
+		},
+		{
+			name: "extras",
+			results: []Result{
+				{
+					Probe: "time.Sleep delay", Label: "baseline", Percentiles: defaultPercentiles,
+					Values:     []time.Duration{50 * time.Microsecond, time.Millisecond, 5 * time.Millisecond, 12 * time.Millisecond},
+					Thresholds: []time.Duration{time.Millisecond, 10 * time.Millisecond}, Tail: []uint64{12, 1},
+					Extras: []string{"workers 4", "[burst]"}, Count: 60, Negative: 2,
+				},
+				{
+					Probe: "time.Sleep delay", Percentiles: defaultPercentiles, Relative: true, Target: 15 * time.Millisecond,
+					Values:   []time.Duration{50 * time.Microsecond, time.Millisecond, 5 * time.Millisecond, 12 * time.Millisecond},
+					Smoothed: []time.Duration{40 * time.Microsecond, 900 * time.Microsecond, 4 * time.Millisecond, 10 * time.Millisecond},
+					Count:    60,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			for _, r := range tt.results {
+				var err error
+				if got, err = (TextFormatter{}).AppendFormat(got, r); err != nil {
+					t.Fatalf("AppendFormat(%v) failed: %v", r.Probe, err)
+				}
+			}
+
+			golden := filepath.Join("testdata", "text_"+tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatalf("failed to update golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file (run with -update to create it): %v", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("TextFormatter output differs from %v:\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestFormatNegative(t *testing.T) {
+	r := Result{
+		Probe: "time.Sleep delay", Percentiles: defaultPercentiles, Count: 64, Negative: 3,
+		Values: []time.Duration{52 * time.Microsecond, 1100 * time.Microsecond, 2 * time.Millisecond, 3 * time.Millisecond},
+	}
+
+	var jsonBuf bytes.Buffer
+	if err := NewJSON(&jsonBuf).Report(r); err != nil {
+		t.Fatalf("JSON Report() failed: %v", err)
+	}
+	var got struct {
+		Negative uint64 `json:"negative"`
+	}
+	if err := json.Unmarshal(jsonBuf.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode JSON result %q: %v", jsonBuf.String(), err)
+	}
+	if got.Negative != 3 {
+		t.Errorf("JSON negative = %v, want 3 in %s", got.Negative, jsonBuf.Bytes())
+	}
+
+	var csvBuf bytes.Buffer
+	if err := NewCSV(&csvBuf).Report(r); err != nil {
+		t.Fatalf("CSV Report() failed: %v", err)
+	}
+	records, err := csv.NewReader(&csvBuf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read CSV result: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("CSV has %v records, want header and one row", len(records))
+	}
+	for i, name := range records[0] {
+		if name == "negative" {
+			if got := records[1][i]; got != "3" {
+				t.Errorf("CSV negative = %q, want 3", got)
+			}
+			return
+		}
+	}
+	t.Errorf("CSV header %v has no negative column", records[0])
+}
+
+// TestTextSinkAllocs checks the allocation budget of reporting a result to
+// a text sink, which reuses its buffer.
+func TestTextSinkAllocs(t *testing.T) {
+	s := NewText(io.Discard)
+	r := Result{
+		Probe: "time.Sleep delay", Percentiles: defaultPercentiles, Count: 64,
+		Values:     []time.Duration{52 * time.Microsecond, 1100 * time.Microsecond, 2 * time.Millisecond, 3 * time.Millisecond},
+		Thresholds: []time.Duration{time.Millisecond}, Tail: []uint64{3},
+	}
+	s.Report(r)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := s.Report(r); err != nil {
+			t.Fatalf("Report() failed: %v", err)
+		}
+	})
+	if allocs > 4 {
+		t.Errorf("Report() allocated %v times, want at most 4", allocs)
+	}
+}
@@ -0,0 +1,117 @@
+// Package report formats the percentiles measured by probes.
+package report
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"sched-latency/stats"
+)
+
+// defaultPercentiles are the percentiles FormatPercentiles names.
+var defaultPercentiles = []float64{0, 0.5, 0.99, 1}
+
+// FormatPercentiles formats the min, p50, p99 and max, in that order, in
+// aligned columns.
+func FormatPercentiles(ps []time.Duration) string {
+	return FormatNamed(defaultPercentiles, ps)
+}
+
+// FormatNamed formats the values of the percentiles, each after its name
+// such as "p99", in aligned columns, truncated by stats.Truncate. Missing
+// values are formatted as 0.
+func FormatNamed(percentiles []float64, ps []time.Duration) string {
+	return string(appendNamed(nil, percentiles, ps))
+}
+
+// appendNamed appends the values as formatted by FormatNamed to b.
+func appendNamed(b []byte, percentiles []float64, ps []time.Duration) []byte {
+	for i, p := range percentiles {
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		var v time.Duration
+		if i < len(ps) {
+			v = ps[i]
+		}
+		b = append(b, stats.PercentileName(p)...)
+		b = append(b, ' ')
+		b = appendPadded(b, stats.Truncate(v).String(), -10)
+	}
+	return b
+}
+
+// appendPadded appends s to b padded with spaces to width runes, as fmt's
+// "%*s" does: on the left for a positive width, and on the right for a
+// negative one. It avoids fmt's allocations for its arguments.
+func appendPadded(b []byte, s string, width int) []byte {
+	pad := width
+	if pad < 0 {
+		pad = -pad
+	}
+	pad -= utf8.RuneCountInString(s)
+	if width > 0 {
+		b = appendSpaces(b, pad)
+	}
+	b = append(b, s...)
+	if width < 0 {
+		b = appendSpaces(b, pad)
+	}
+	return b
+}
+
+func appendSpaces(b []byte, n int) []byte {
+	for ; n > 0; n-- {
+		b = append(b, ' ')
+	}
+	return b
+}
+
+// FormatTail formats the number of samples over each threshold, e.g.,
+// ">1ms: 12  >10ms: 2  >100ms: 0".
+func FormatTail(thresholds []time.Duration, counts []uint64) string {
+	return string(appendTail(nil, thresholds, counts))
+}
+
+// appendTail appends the counts as formatted by FormatTail to b.
+func appendTail(b []byte, thresholds []time.Duration, counts []uint64) []byte {
+	for i, t := range thresholds {
+		if i > 0 {
+			b = append(b, "  "...)
+		}
+		var n uint64
+		if i < len(counts) {
+			n = counts[i]
+		}
+		b = append(b, '>')
+		b = append(b, t.String()...)
+		b = append(b, ": "...)
+		b = strconv.AppendUint(b, n, 10)
+	}
+	return b
+}
+
+// FormatAnnotated is FormatNamed with a note in parentheses after each
+// value, such as its moving average, e.g., "p99 1.2ms (~900µs)". Values
+// without a note are formatted as by FormatNamed.
+func FormatAnnotated(percentiles []float64, ps []time.Duration, notes []string) string {
+	var sb strings.Builder
+	for i, p := range percentiles {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		var v time.Duration
+		if i < len(ps) {
+			v = ps[i]
+		}
+		var note string
+		if i < len(notes) && notes[i] != "" {
+			note = "(" + notes[i] + ")"
+		}
+		fmt.Fprintf(&sb, "%v %-10v %-12s", stats.PercentileName(p), stats.Truncate(v), note)
+	}
+	return sb.String()
+}
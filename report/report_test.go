@@ -0,0 +1,87 @@
+package report
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFormatPercentiles(t *testing.T) {
+	got := FormatPercentiles([]time.Duration{1234, 56789, 1234567, 2 * time.Second})
+	want := "min 1.23µs     p50 56.78µs    p99 1.23ms     max 2s        "
+	if got != want {
+		t.Errorf("FormatPercentiles() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatNamedMissingValues(t *testing.T) {
+	got := FormatNamed([]float64{0.5, 0.9}, []time.Duration{time.Millisecond})
+	want := "p50 1ms        p90 0s        "
+	if got != want {
+		t.Errorf("FormatNamed() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatTail(t *testing.T) {
+	tests := []struct {
+		thresholds []time.Duration
+		counts     []uint64
+		want       string
+	}{
+		{nil, nil, ""},
+		{[]time.Duration{time.Millisecond}, []uint64{3}, ">1ms: 3"},
+		{[]time.Duration{time.Millisecond, 10 * time.Millisecond}, []uint64{12, 2}, ">1ms: 12  >10ms: 2"},
+		{[]time.Duration{time.Millisecond, 10 * time.Millisecond}, []uint64{12}, ">1ms: 12  >10ms: 0"},
+	}
+	for _, tt := range tests {
+		if got := FormatTail(tt.thresholds, tt.counts); got != tt.want {
+			t.Errorf("FormatTail(%v, %v) = %q, want %q", tt.thresholds, tt.counts, got, tt.want)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	tags := Tags{"pid": "12", "host": "a", "instance": "b"}
+	if got, want := tags.String(), "host=a instance=b pid=12"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	if got := NewTags(""); got["host"] == "" || got["pid"] == "" || len(got) != 2 {
+		t.Errorf("NewTags(\"\") = %v, want host and pid", got)
+	}
+	if got := NewTags("pod-1"); got["instance"] != "pod-1" {
+		t.Errorf("NewTags(\"pod-1\") = %v, want instance=pod-1", got)
+	}
+}
+
+func TestWriterDo(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	// Lines written by other goroutines during Do must not land between
+	// its writes.
+	var wg sync.WaitGroup
+	started := make(chan struct{})
+	w.Do(func(out io.Writer) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			close(started)
+			w.Printf("other\n")
+		}()
+		<-started
+		io.WriteString(out, "first\n")
+		io.WriteString(out, "second\n")
+	})
+	wg.Wait()
+
+	if got, want := buf.String(), "first\nsecond\nother\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if strings.Count(buf.String(), "\n") != 3 {
+		t.Errorf("got %q, want 3 lines", buf.String())
+	}
+}
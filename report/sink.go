@@ -0,0 +1,336 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"sched-latency/stats"
+)
+
+// Result is a probe's percentiles over a report interval.
+type Result struct {
+	// Label is the run's -label, if any.
+	Label string
+
+	// Tags identify the instance that measured the result, if set by
+	// Tagged.
+	Tags Tags
+
+	Probe    string
+	Start    time.Time
+	Duration time.Duration
+
+	// Percentiles are the percentiles between 0 and 1 that Values holds.
+	Percentiles []float64
+	Values      []time.Duration
+
+	// Relative is set when percentiles are also reported relative to the
+	// Target, if the probe has one: the interval it waits for, of which
+	// its delays are an overshoot.
+	Relative bool
+	Target   time.Duration
+
+	// Smoothed are moving averages of Values over recent intervals, if
+	// they're reported.
+	Smoothed []time.Duration
+
+	// Count is the number of samples in the interval.
+	Count uint64
+
+	// Tail counts the samples over each of the Thresholds, if they're
+	// reported. For a histogram, the counts are interpolated within the
+	// buckets the thresholds fall in.
+	Thresholds []time.Duration
+	Tail       []uint64
+
+	// Negative is the number of samples in Count with a negative delay,
+	// which are kept as is.
+	Negative uint64
+
+	// Partial is set for an interval that's still in progress.
+	Partial bool
+
+	// Warmup is set for an interval that started during the warmup.
+	Warmup bool
+
+	// Anomaly is set for an interval that's unusual for its probe.
+	Anomaly bool
+
+	// CPU is how the process's CPU time was spent over the interval, if
+	// it's measured.
+	CPU *CPUClasses
+
+	// RawBuckets is set when probes reading a runtime/metrics histogram
+	// report its Buckets, the interval's non-empty buckets, so every result
+	// of a run has the same CSV columns.
+	RawBuckets bool
+	Buckets    []Bucket
+
+	// Extras annotate the interval, e.g., "workers 4" or "[burst]".
+	Extras []string
+}
+
+type resultJSON struct {
+	Label         string             `json:"label,omitempty"`
+	Tags          Tags               `json:"tags,omitempty"`
+	Probe         string             `json:"probe"`
+	Start         time.Time          `json:"start"`
+	End           time.Time          `json:"end"`
+	DurationNs    int64              `json:"duration_ns"`
+	Count         uint64             `json:"count"`
+	Negative      uint64             `json:"negative,omitempty"`
+	PercentilesNs map[string]int64   `json:"percentiles_ns"`
+	SmoothedNs    map[string]int64   `json:"smoothed_ns,omitempty"`
+	TargetNs      int64              `json:"target_ns,omitempty"`
+	Relative      map[string]float64 `json:"relative,omitempty"`
+	Tail          map[string]uint64  `json:"tail,omitempty"`
+	Partial       bool               `json:"partial,omitempty"`
+	Warmup        bool               `json:"warmup,omitempty"`
+	Anomaly       bool               `json:"anomaly"`
+	CPU           *CPUClasses        `json:"cpu,omitempty"`
+	Buckets       []bucketJSON       `json:"buckets,omitempty"`
+	Extras        []string           `json:"extras,omitempty"`
+}
+
+// Bucket is a histogram bucket's count of the values between its Lower
+// and Upper bounds, in seconds as in runtime/metrics. The bounds of the
+// first and last buckets may be infinite.
+type Bucket struct {
+	Lower, Upper float64
+	Count        uint64
+}
+
+// NonEmptyBuckets returns the snapshot's buckets with any values in them.
+func NonEmptyBuckets(h stats.HistSnapshot) []Bucket {
+	buckets := []Bucket{}
+	for i, c := range h.Counts {
+		if c > 0 {
+			buckets = append(buckets, Bucket{Lower: h.Buckets[i], Upper: h.Buckets[i+1], Count: c})
+		}
+	}
+	return buckets
+}
+
+// boundNs returns a bucket bound in nanoseconds, and false if it's
+// infinite.
+func boundNs(b float64) (int64, bool) {
+	if math.IsInf(b, 0) {
+		return 0, false
+	}
+	return int64(math.Round(b * 1e9)), true
+}
+
+// bucketJSON is a Bucket in JSON, whose infinite bounds are left out.
+type bucketJSON struct {
+	LowerNs *int64 `json:"lower_ns,omitempty"`
+	UpperNs *int64 `json:"upper_ns,omitempty"`
+	Count   uint64 `json:"count"`
+}
+
+func (b Bucket) json() bucketJSON {
+	j := bucketJSON{Count: b.Count}
+	if ns, ok := boundNs(b.Lower); ok {
+		j.LowerNs = &ns
+	}
+	if ns, ok := boundNs(b.Upper); ok {
+		j.UpperNs = &ns
+	}
+	return j
+}
+
+// End returns the end of the result's interval.
+func (r Result) End() time.Time {
+	return r.Start.Add(r.Duration)
+}
+
+// Ratios returns the values as ratios of the Target, or nil if they aren't
+// reported relative to one.
+func (r Result) Ratios() []float64 {
+	if !r.Relative || r.Target <= 0 {
+		return nil
+	}
+	ratios := make([]float64, len(r.Values))
+	for i, v := range r.Values {
+		ratios[i] = float64(v) / float64(r.Target)
+	}
+	return ratios
+}
+
+// MarshalJSON encodes the result with its values in nanoseconds, keyed by
+// percentile names such as "p99".
+func (r Result) MarshalJSON() ([]byte, error) {
+	byName := make(map[string]int64, len(r.Values))
+	for i, v := range r.Values {
+		byName[stats.PercentileName(r.Percentiles[i])] = int64(v)
+	}
+	var smoothed map[string]int64
+	if r.Smoothed != nil {
+		smoothed = make(map[string]int64, len(r.Smoothed))
+		for i, v := range r.Smoothed {
+			smoothed[stats.PercentileName(r.Percentiles[i])] = int64(v)
+		}
+	}
+	var relative map[string]float64
+	if ratios := r.Ratios(); ratios != nil {
+		relative = make(map[string]float64, len(ratios))
+		for i, v := range ratios {
+			relative[stats.PercentileName(r.Percentiles[i])] = v
+		}
+	}
+	var tail map[string]uint64
+	if r.Tail != nil {
+		tail = make(map[string]uint64, len(r.Tail))
+		for i, n := range r.Tail {
+			tail[r.Thresholds[i].String()] = n
+		}
+	}
+	var buckets []bucketJSON
+	for _, b := range r.Buckets {
+		buckets = append(buckets, b.json())
+	}
+	return json.Marshal(resultJSON{
+		Label:         r.Label,
+		Tags:          r.Tags,
+		Probe:         r.Probe,
+		Start:         r.Start,
+		End:           r.End(),
+		DurationNs:    int64(r.Duration),
+		Count:         r.Count,
+		Negative:      r.Negative,
+		PercentilesNs: byName,
+		SmoothedNs:    smoothed,
+		TargetNs:      int64(r.Target),
+		Relative:      relative,
+		Tail:          tail,
+		Partial:       r.Partial,
+		Warmup:        r.Warmup,
+		Anomaly:       r.Anomaly,
+		CPU:           r.CPU,
+		Buckets:       buckets,
+		Extras:        r.Extras,
+	})
+}
+
+// Sink receives every probe's results. Probes report from their own
+// goroutines, so sinks must be safe for concurrent use.
+type Sink interface {
+	Report(r Result) error
+}
+
+// Sinks reports every result to each of its sinks.
+type Sinks []Sink
+
+// Report reports the result to every sink, returning the first error.
+func (s Sinks) Report(r Result) error {
+	var err error
+	for _, sink := range s {
+		if sinkErr := sink.Report(r); err == nil {
+			err = sinkErr
+		}
+	}
+	return err
+}
+
+// Formatted is a sink writing each result as formatted by its Formatter,
+// with one write per result.
+type Formatted struct {
+	mu sync.Mutex
+	w  io.Writer
+	f  Formatter
+
+	// buf is reused for every result if f is an AppendFormatter.
+	buf []byte
+}
+
+// NewFormatted returns a sink writing results to w in f's format.
+func NewFormatted(w io.Writer, f Formatter) *Formatted {
+	return &Formatted{w: w, f: f}
+}
+
+// NewText returns a sink writing text lines to w.
+func NewText(w io.Writer) *Formatted {
+	return NewFormatted(w, TextFormatter{})
+}
+
+// NewJSON returns a sink writing JSON lines to w.
+func NewJSON(w io.Writer) *Formatted {
+	return NewFormatted(w, JSONFormatter{})
+}
+
+// NewCSV returns a sink writing CSV to w.
+func NewCSV(w io.Writer) *Formatted {
+	return NewFormatted(w, &CSVFormatter{})
+}
+
+func (s *Formatted) Report(r Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b []byte
+	var err error
+	if af, ok := s.f.(AppendFormatter); ok {
+		b, err = af.AppendFormat(s.buf[:0], r)
+		s.buf = b
+	} else {
+		b, err = s.f.Format(r)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(b)
+	return err
+}
+
+// File is a sink writing to a file, which must be closed once reporting is
+// done.
+type File struct {
+	Sink
+	f *os.File
+}
+
+// NewFile creates the file at path, writing results to it in the format
+// returned by newSink.
+func NewFile(path string, newSink func(io.Writer) Sink) (*File, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Sink: newSink(f), f: f}, nil
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.f.Close()
+}
+
+// Formats are the sinks that can be created by name.
+var Formats = map[string]func(io.Writer) Sink{
+	"text": func(w io.Writer) Sink { return NewText(w) },
+	"json": func(w io.Writer) Sink { return NewJSON(w) },
+	"csv":  func(w io.Writer) Sink { return NewCSV(w) },
+}
+
+// Open returns the sink for a spec of the form format[:path], such as
+// "json:results.jsonl", writing to stdout if there's no path or it's "-".
+// The returned close function must be called once reporting is done.
+func Open(spec string, stdout io.Writer) (Sink, func() error, error) {
+	format, path, _ := strings.Cut(spec, ":")
+	newSink, ok := Formats[format]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown format %q in %q, expected text, json or csv", format, spec)
+	}
+	if path == "" || path == "-" {
+		return newSink(stdout), func() error { return nil }, nil
+	}
+	f, err := NewFile(path, newSink)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
@@ -0,0 +1,62 @@
+package report
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tags identify the instance that measured the results, such as its host
+// and PID, so the results of several instances reporting to the same place
+// don't collide. They're built once at startup, and every sink applies the
+// same tags.
+type Tags map[string]string
+
+// NewTags returns the tags for this process: its hostname and PID, and the
+// instance if it's set, e.g., to a pod name or region.
+func NewTags(instance string) Tags {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	t := Tags{"host": host, "pid": strconv.Itoa(os.Getpid())}
+	if instance != "" {
+		t["instance"] = instance
+	}
+	return t
+}
+
+// Keys returns the tag names, sorted.
+func (t Tags) Keys() []string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// String formats the tags as space-separated name=value pairs, sorted by
+// name.
+func (t Tags) String() string {
+	parts := make([]string, 0, len(t))
+	for _, k := range t.Keys() {
+		parts = append(parts, k+"="+t[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// Tagged is a sink that sets the tags of every result before reporting it
+// to the underlying sink.
+type Tagged struct {
+	Sink Sink
+	Tags Tags
+}
+
+// Report implements Sink, attaching the tags to the result before passing
+// it to the wrapped sink.
+func (t Tagged) Report(r Result) error {
+	r.Tags = t.Tags
+	return t.Sink.Report(r)
+}
@@ -0,0 +1,41 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Writer serializes writes from many goroutines, so that the lines of each
+// write reach the underlying writer whole, and aren't interleaved with
+// another goroutine's when it's a pipe that's parsed.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes p as one write to the underlying writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// Printf formats the line and writes it with a single write.
+func (w *Writer) Printf(format string, args ...interface{}) {
+	w.Write([]byte(fmt.Sprintf(format, args...)))
+}
+
+// Do calls f with writes held off, for output spanning several writes,
+// such as a summary, that mustn't be interleaved with reports. Writes
+// within f must go to the writer passed to it.
+func (w *Writer) Do(f func(w io.Writer)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f(w.w)
+}
@@ -0,0 +1,192 @@
+package stats
+
+import (
+	"errors"
+	"math"
+	"runtime/metrics"
+	"sort"
+	"time"
+)
+
+// ErrBucketMismatch is returned when combining histograms with different
+// bucket boundaries.
+var ErrBucketMismatch = errors.New("histograms have different buckets")
+
+// ErrCounterReset is returned by Diff when a bucket's count went down,
+// which cumulative runtime/metrics histograms never do unless they've been
+// reset.
+var ErrCounterReset = errors.New("histogram counts went down since the previous reading")
+
+// HistSnapshot is a reading of a cumulative runtime/metrics histogram, or
+// the values added between two readings. As in metrics.Float64Histogram,
+// Counts[i] is the number of values between Buckets[i] and Buckets[i+1].
+//
+// The zero HistSnapshot is empty, and can be merged with any snapshot.
+type HistSnapshot struct {
+	Counts  []uint64
+	Buckets []float64
+}
+
+// NewHistSnapshot copies h, since metrics.Read reuses a sample's
+// histogram.
+func NewHistSnapshot(h *metrics.Float64Histogram) HistSnapshot {
+	return HistSnapshot{
+		Counts:  append([]uint64(nil), h.Counts...),
+		Buckets: append([]float64(nil), h.Buckets...),
+	}
+}
+
+// view returns h as a snapshot without copying it.
+func view(h *metrics.Float64Histogram) HistSnapshot {
+	return HistSnapshot{Counts: h.Counts, Buckets: h.Buckets}
+}
+
+// sameBuckets returns whether the snapshots have the same bucket
+// boundaries.
+func (h HistSnapshot) sameBuckets(other HistSnapshot) bool {
+	if len(h.Buckets) != len(other.Buckets) || len(h.Counts) != len(other.Counts) {
+		return false
+	}
+	for i, b := range h.Buckets {
+		if b != other.Buckets[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff returns the values added between the prev reading and h.
+//
+// If the buckets differ, it returns ErrBucketMismatch and an empty
+// snapshot. If any count went down, the histogram was reset since prev, so
+// it returns ErrCounterReset with h itself, as everything in h was added
+// since the reset.
+func (h HistSnapshot) Diff(prev HistSnapshot) (HistSnapshot, error) {
+	if !h.sameBuckets(prev) {
+		return HistSnapshot{}, ErrBucketMismatch
+	}
+	diff := HistSnapshot{Counts: make([]uint64, len(h.Counts)), Buckets: h.Buckets}
+	for i, c := range h.Counts {
+		if c < prev.Counts[i] {
+			return h, ErrCounterReset
+		}
+		diff.Counts[i] = c - prev.Counts[i]
+	}
+	return diff, nil
+}
+
+// Merge returns the values in both h and other. If either is the zero
+// snapshot, it returns the other. If the buckets differ, it returns
+// ErrBucketMismatch and h unchanged.
+func (h HistSnapshot) Merge(other HistSnapshot) (HistSnapshot, error) {
+	switch {
+	case h.Buckets == nil:
+		return other, nil
+	case other.Buckets == nil:
+		return h, nil
+	case !h.sameBuckets(other):
+		return h, ErrBucketMismatch
+	}
+	merged := HistSnapshot{Counts: make([]uint64, len(h.Counts)), Buckets: h.Buckets}
+	for i, c := range h.Counts {
+		merged.Counts[i] = c + other.Counts[i]
+	}
+	return merged, nil
+}
+
+// Count returns the number of values in the snapshot.
+func (h HistSnapshot) Count() uint64 {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	return total
+}
+
+// Percentiles returns each percentile of the values in the snapshot.
+//
+// The percentile is the value of the same nearest-rank sample as
+// SamplePercentiles would pick, reported as the upper bound of the bucket
+// holding it, since that's the most it could be. The last bucket's upper
+// bound is usually +Inf, so its lower bound is used instead. If the
+// snapshot is empty, every percentile is 0.
+func (h HistSnapshot) Percentiles(percentiles []float64) []time.Duration {
+	var total uint64
+	cumulative := make([]uint64, len(h.Counts))
+	for i, c := range h.Counts {
+		total += c
+		cumulative[i] = total
+	}
+
+	pDurations := make([]time.Duration, 0, len(percentiles))
+	for _, p := range percentiles {
+		if total == 0 {
+			pDurations = append(pDurations, 0)
+			continue
+		}
+
+		rank := uint64(math.Ceil(p * float64(total)))
+		if rank < 1 {
+			rank = 1
+		}
+		if rank > total {
+			rank = total
+		}
+		bucket := sort.Search(len(cumulative), func(i int) bool {
+			return cumulative[i] >= rank
+		})
+		pDurations = append(pDurations, bucketBound(h.Buckets, bucket))
+	}
+	return pDurations
+}
+
+// CountAbove returns the number of values in the snapshot over each
+// threshold. A bucket the threshold falls in contributes the fraction of
+// its values above it, assuming they're spread evenly, rounded to the
+// nearest; if the bucket is unbounded, all its values are counted on the
+// side it's unbounded on.
+func (h HistSnapshot) CountAbove(thresholds []time.Duration) []uint64 {
+	counts := make([]uint64, len(thresholds))
+	for i, t := range thresholds {
+		secs := t.Seconds()
+		var above float64
+		for k, c := range h.Counts {
+			lower, upper := h.Buckets[k], h.Buckets[k+1]
+			switch {
+			case c == 0 || upper <= secs:
+			case lower >= secs || math.IsInf(upper, 1):
+				above += float64(c)
+			case !math.IsInf(lower, -1):
+				above += float64(c) * (upper - secs) / (upper - lower)
+			}
+		}
+		counts[i] = uint64(math.Round(above))
+	}
+	return counts
+}
+
+// MaxBound returns a lower bound on the largest value in the snapshot, the
+// lower bound of the highest non-empty bucket, or 0 if it's empty.
+func (h HistSnapshot) MaxBound() time.Duration {
+	for i := len(h.Counts) - 1; i >= 0; i-- {
+		if h.Counts[i] > 0 {
+			if b := h.Buckets[i]; b > 0 && !math.IsInf(b, 0) {
+				return Seconds(b)
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// bucketBound returns the upper bound of the bucket, or its lower bound if
+// the upper one is infinite, or 0 if both are.
+func bucketBound(buckets []float64, bucket int) time.Duration {
+	if upper := buckets[bucket+1]; !math.IsInf(upper, 0) {
+		return Seconds(upper)
+	}
+	if lower := buckets[bucket]; !math.IsInf(lower, 0) {
+		return Seconds(lower)
+	}
+	return 0
+}
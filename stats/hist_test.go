@@ -0,0 +1,377 @@
+package stats
+
+import (
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+)
+
+// testBuckets are bucket bounds in seconds like runtime/metrics', with
+// unbounded first and last buckets.
+var testBuckets = []float64{math.Inf(-1), 0, 0.001, 0.002, 0.004, math.Inf(1)}
+
+func testHist(counts ...uint64) *metrics.Float64Histogram {
+	return &metrics.Float64Histogram{Counts: counts, Buckets: testBuckets}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	ms := time.Millisecond
+	standard := []float64{0, 0.5, 0.75, 1}
+	tests := []struct {
+		name        string
+		cur, last   *metrics.Float64Histogram
+		percentiles []float64
+		want        []time.Duration
+	}{
+		{
+			name:        "one bucket",
+			cur:         testHist(0, 0, 7, 0, 0),
+			last:        testHist(0, 0, 0, 0, 0),
+			percentiles: standard,
+			want:        []time.Duration{2 * ms, 2 * ms, 2 * ms, 2 * ms},
+		},
+		{
+			name:        "first and last buckets",
+			cur:         testHist(1, 0, 0, 0, 1),
+			last:        testHist(0, 0, 0, 0, 0),
+			percentiles: standard,
+			// The first bucket's upper bound is 0, and the last one's
+			// lower bound is used since its upper bound is +Inf.
+			want: []time.Duration{0, 0, 4 * ms, 4 * ms},
+		},
+		{
+			name:        "only the +Inf bucket",
+			cur:         testHist(0, 0, 0, 0, 3),
+			last:        testHist(0, 0, 0, 0, 0),
+			percentiles: standard,
+			want:        []time.Duration{4 * ms, 4 * ms, 4 * ms, 4 * ms},
+		},
+		{
+			name:        "spread",
+			cur:         testHist(0, 1, 2, 1, 0),
+			last:        testHist(0, 0, 0, 0, 0),
+			percentiles: standard,
+			want:        []time.Duration{1 * ms, 2 * ms, 2 * ms, 4 * ms},
+		},
+		{
+			name:        "p=0 and p=1",
+			cur:         testHist(0, 4, 0, 0, 1),
+			last:        testHist(0, 0, 0, 0, 0),
+			percentiles: []float64{0, 1},
+			want:        []time.Duration{1 * ms, 4 * ms},
+		},
+		{
+			name:        "diff",
+			cur:         testHist(0, 5, 2, 0, 0),
+			last:        testHist(0, 5, 0, 0, 0),
+			percentiles: standard,
+			want:        []time.Duration{2 * ms, 2 * ms, 2 * ms, 2 * ms},
+		},
+		{
+			name:        "empty diff",
+			cur:         testHist(0, 5, 2, 0, 0),
+			last:        testHist(0, 5, 2, 0, 0),
+			percentiles: standard,
+			want:        []time.Duration{0, 0, 0, 0},
+		},
+		{
+			name:        "reset",
+			cur:         testHist(0, 0, 1, 0, 0),
+			last:        testHist(0, 5, 2, 0, 0),
+			percentiles: standard,
+			want:        []time.Duration{2 * ms, 2 * ms, 2 * ms, 2 * ms},
+		},
+		{
+			name:        "bucket mismatch",
+			cur:         testHist(0, 0, 1, 0, 0),
+			last:        &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}},
+			percentiles: standard,
+			want:        []time.Duration{0, 0, 0, 0},
+		},
+		{
+			name:        "single bounded bucket",
+			cur:         &metrics.Float64Histogram{Counts: []uint64{3}, Buckets: []float64{0.001, 0.002}},
+			last:        &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0.001, 0.002}},
+			percentiles: standard,
+			want:        []time.Duration{2 * ms, 2 * ms, 2 * ms, 2 * ms},
+		},
+		{
+			name:        "single half-open bucket",
+			cur:         &metrics.Float64Histogram{Counts: []uint64{3}, Buckets: []float64{0.001, math.Inf(1)}},
+			last:        &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0.001, math.Inf(1)}},
+			percentiles: standard,
+			want:        []time.Duration{1 * ms, 1 * ms, 1 * ms, 1 * ms},
+		},
+		{
+			name:        "single unbounded bucket",
+			cur:         &metrics.Float64Histogram{Counts: []uint64{3}, Buckets: []float64{math.Inf(-1), math.Inf(1)}},
+			last:        &metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{math.Inf(-1), math.Inf(1)}},
+			percentiles: standard,
+			want:        []time.Duration{0, 0, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := HistogramPercentiles(tt.cur, tt.last, tt.percentiles)
+			if !equalDurations(got, tt.want) {
+				t.Errorf("HistogramPercentiles(%v, %v, %v) = %v, want %v", tt.cur.Counts, tt.last.Counts, tt.percentiles, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistSnapshotPercentilesEmpty(t *testing.T) {
+	var h HistSnapshot
+	if got := h.Percentiles([]float64{0, 1}); !equalDurations(got, []time.Duration{0, 0}) {
+		t.Errorf("Percentiles of the zero snapshot = %v, want [0 0]", got)
+	}
+}
+
+func TestHistSnapshotMaxBound(t *testing.T) {
+	tests := []struct {
+		counts []uint64
+		want   time.Duration
+	}{
+		{[]uint64{0, 0, 0, 0, 0}, 0},
+		{[]uint64{1, 0, 0, 0, 0}, 0},
+		{[]uint64{0, 1, 1, 0, 0}, time.Millisecond},
+		{[]uint64{0, 1, 0, 0, 1}, 4 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		h := HistSnapshot{Counts: tt.counts, Buckets: testBuckets}
+		if got := h.MaxBound(); got != tt.want {
+			t.Errorf("MaxBound of %v = %v, want %v", tt.counts, got, tt.want)
+		}
+	}
+}
+
+func TestHistSnapshotCountAbove(t *testing.T) {
+	h := HistSnapshot{Counts: []uint64{1, 2, 4, 8, 16}, Buckets: testBuckets}
+	got := h.CountAbove([]time.Duration{0, time.Millisecond, 1500 * time.Microsecond, 4 * time.Millisecond, time.Hour})
+	// Half of the 1-2ms bucket is over 1.5ms, and the +Inf bucket is all
+	// counted, however high the threshold.
+	want := []uint64{30, 28, 26, 16, 16}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("CountAbove = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestHistSnapshotDiff(t *testing.T) {
+	otherBuckets := []float64{math.Inf(-1), 0, 0.001, 0.003, 0.004, math.Inf(1)}
+	tests := []struct {
+		name       string
+		cur, prev  HistSnapshot
+		want       []uint64
+		wantErr    error
+		wantBucket []float64
+	}{
+		{
+			name: "added",
+			cur:  HistSnapshot{Counts: []uint64{1, 5, 3, 0, 2}, Buckets: testBuckets},
+			prev: HistSnapshot{Counts: []uint64{1, 2, 3, 0, 1}, Buckets: testBuckets},
+			want: []uint64{0, 3, 0, 0, 1},
+		},
+		{
+			name: "unchanged",
+			cur:  HistSnapshot{Counts: []uint64{1, 2, 3, 4, 5}, Buckets: testBuckets},
+			prev: HistSnapshot{Counts: []uint64{1, 2, 3, 4, 5}, Buckets: testBuckets},
+			want: []uint64{0, 0, 0, 0, 0},
+		},
+		{
+			// Everything in cur was added since the reset.
+			name:    "counter reset",
+			cur:     HistSnapshot{Counts: []uint64{0, 1, 0, 0, 0}, Buckets: testBuckets},
+			prev:    HistSnapshot{Counts: []uint64{0, 5, 2, 0, 0}, Buckets: testBuckets},
+			want:    []uint64{0, 1, 0, 0, 0},
+			wantErr: ErrCounterReset,
+		},
+		{
+			name:    "one bucket reset",
+			cur:     HistSnapshot{Counts: []uint64{0, 9, 1, 0, 0}, Buckets: testBuckets},
+			prev:    HistSnapshot{Counts: []uint64{0, 5, 2, 0, 0}, Buckets: testBuckets},
+			want:    []uint64{0, 9, 1, 0, 0},
+			wantErr: ErrCounterReset,
+		},
+		{
+			name:    "different bounds",
+			cur:     HistSnapshot{Counts: []uint64{0, 1, 2, 0, 0}, Buckets: otherBuckets},
+			prev:    HistSnapshot{Counts: []uint64{0, 1, 0, 0, 0}, Buckets: testBuckets},
+			wantErr: ErrBucketMismatch,
+		},
+		{
+			name:    "different number of buckets",
+			cur:     HistSnapshot{Counts: []uint64{0, 1, 2, 0}, Buckets: testBuckets[:5]},
+			prev:    HistSnapshot{Counts: []uint64{0, 1, 0, 0, 0}, Buckets: testBuckets},
+			wantErr: ErrBucketMismatch,
+		},
+		{
+			name:    "zero previous snapshot",
+			cur:     HistSnapshot{Counts: []uint64{0, 1, 0, 0, 0}, Buckets: testBuckets},
+			wantErr: ErrBucketMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prevCounts := append([]uint64(nil), tt.prev.Counts...)
+			got, err := tt.cur.Diff(tt.prev)
+			if err != tt.wantErr {
+				t.Fatalf("Diff() error = %v, want %v", err, tt.wantErr)
+			}
+			if !equalCounts(got.Counts, tt.want) {
+				t.Errorf("Diff() counts = %v, want %v", got.Counts, tt.want)
+			}
+			if tt.want != nil && !equalBuckets(got.Buckets, tt.cur.Buckets) {
+				t.Errorf("Diff() buckets = %v, want %v", got.Buckets, tt.cur.Buckets)
+			}
+			if !equalCounts(tt.prev.Counts, prevCounts) {
+				t.Errorf("Diff() modified the previous snapshot to %v", tt.prev.Counts)
+			}
+		})
+	}
+}
+
+func TestHistSnapshotMerge(t *testing.T) {
+	a := HistSnapshot{Counts: []uint64{1, 2, 0, 0, 1}, Buckets: testBuckets}
+	b := HistSnapshot{Counts: []uint64{0, 3, 1, 0, 0}, Buckets: testBuckets}
+	other := HistSnapshot{Counts: []uint64{0, 1, 1}, Buckets: []float64{0, 0.001, 0.01, math.Inf(1)}}
+
+	tests := []struct {
+		name     string
+		h, other HistSnapshot
+		want     []uint64
+		wantErr  error
+	}{
+		{name: "both", h: a, other: b, want: []uint64{1, 5, 1, 0, 1}},
+		{name: "zero into snapshot", h: a, want: a.Counts},
+		{name: "snapshot into zero", other: b, want: b.Counts},
+		{name: "both zero"},
+		{name: "mismatch", h: a, other: other, want: a.Counts, wantErr: ErrBucketMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.h.Merge(tt.other)
+			if err != tt.wantErr {
+				t.Fatalf("Merge() error = %v, want %v", err, tt.wantErr)
+			}
+			if !equalCounts(got.Counts, tt.want) {
+				t.Errorf("Merge() counts = %v, want %v", got.Counts, tt.want)
+			}
+		})
+	}
+
+	if !equalCounts(a.Counts, []uint64{1, 2, 0, 0, 1}) || !equalCounts(b.Counts, []uint64{0, 3, 1, 0, 0}) {
+		t.Errorf("Merge() modified its snapshots to %v and %v", a.Counts, b.Counts)
+	}
+}
+
+func TestNewHistSnapshotCopies(t *testing.T) {
+	h := testHist(0, 1, 2, 0, 0)
+	s := NewHistSnapshot(h)
+	h.Counts[1] = 10
+	if s.Counts[1] != 1 {
+		t.Errorf("NewHistSnapshot shares its counts with the histogram")
+	}
+	if s.Count() != 3 {
+		t.Errorf("Count() = %v, want 3", s.Count())
+	}
+}
+
+func equalCounts(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalBuckets(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestHistSnapshotReadings diffs a sequence of readings as a probe does,
+// discarding the interval and taking the reading as the new baseline when
+// the diff fails.
+func TestHistSnapshotReadings(t *testing.T) {
+	otherBuckets := []float64{math.Inf(-1), 0, 0.0005, 0.001, 0.002, math.Inf(1)}
+	readings := []HistSnapshot{
+		{Counts: []uint64{0, 1, 0, 0, 0}, Buckets: testBuckets},
+		{Counts: []uint64{0, 3, 1, 0, 0}, Buckets: testBuckets},
+		// The histogram was reset, e.g., by a restarted target.
+		{Counts: []uint64{0, 1, 0, 0, 0}, Buckets: testBuckets},
+		{Counts: []uint64{0, 1, 2, 0, 0}, Buckets: testBuckets},
+		// The buckets changed, e.g., with the target's Go version.
+		{Counts: []uint64{0, 1, 2, 0, 0}, Buckets: otherBuckets},
+		{Counts: []uint64{0, 1, 2, 4, 0}, Buckets: otherBuckets},
+	}
+	wantErrs := []error{nil, ErrCounterReset, nil, ErrBucketMismatch, nil}
+	wantCounts := []uint64{3, 0, 2, 0, 4}
+
+	last := readings[0]
+	for i, cur := range readings[1:] {
+		diff, err := cur.Diff(last)
+		if err != wantErrs[i] {
+			t.Errorf("reading %d: Diff() error = %v, want %v", i+1, err, wantErrs[i])
+		}
+		var count uint64
+		if err == nil {
+			count = diff.Count()
+		}
+		if count != wantCounts[i] {
+			t.Errorf("reading %d: reported %v values, want %v", i+1, count, wantCounts[i])
+		}
+		last = cur
+	}
+}
+
+func TestHistogramCount(t *testing.T) {
+	tests := []struct {
+		name      string
+		cur, last *metrics.Float64Histogram
+		want      uint64
+	}{
+		{"diff", testHist(0, 5, 2, 0, 1), testHist(0, 5, 0, 0, 0), 3},
+		{"reset", testHist(0, 1, 0, 0, 0), testHist(0, 5, 2, 0, 0), 1},
+		{"mismatch", testHist(0, 5, 2, 0, 1), &metrics.Float64Histogram{Counts: []uint64{1}, Buckets: []float64{0, 1}}, 0},
+	}
+	for _, tt := range tests {
+		if got := HistogramCount(tt.cur, tt.last); got != tt.want {
+			t.Errorf("%v: HistogramCount() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkHistogramPercentiles(b *testing.B) {
+	// A real histogram, for its number of buckets.
+	sample := []metrics.Sample{{Name: "/sched/latencies:seconds"}}
+	metrics.Read(sample)
+	last := NewHistSnapshot(sample[0].Value.Float64Histogram())
+	cur := NewHistSnapshot(sample[0].Value.Float64Histogram())
+	for i := range cur.Counts {
+		cur.Counts[i] += uint64(i % 7)
+	}
+	curHist := &metrics.Float64Histogram{Counts: cur.Counts, Buckets: cur.Buckets}
+	lastHist := &metrics.Float64Histogram{Counts: last.Counts, Buckets: last.Buckets}
+	percentiles := []float64{0, 0.5, 0.99, 0.999, 1}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		HistogramPercentiles(curHist, lastHist, percentiles)
+	}
+}
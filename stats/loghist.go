@@ -0,0 +1,155 @@
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+// logHistMax is the largest delay a LogHist has buckets for. Larger delays
+// are counted in its last bucket.
+const logHistMax = 24 * time.Hour
+
+// LogHist counts durations in log-spaced buckets, so its memory is the same
+// however many durations are added. Each bucket is 1+resolution times as
+// wide as the one before, so a value is known to within the resolution,
+// e.g., 1%.
+//
+// Bucket 0 counts durations below 1ns, and bucket k counts those from
+// (1+resolution)^(k-1) to (1+resolution)^k nanoseconds. The smallest and
+// largest durations are kept exactly.
+type LogHist struct {
+	logGrowth float64
+	buckets   []float64 // bounds in seconds, shared by snapshots
+	counts    []uint64
+	total     uint64
+	min, max  time.Duration
+}
+
+// NewLogHist returns an empty LogHist with the given resolution, the
+// fraction each bucket is wider than the one before.
+func NewLogHist(resolution float64) *LogHist {
+	growth := 1 + resolution
+	n := int(math.Ceil(math.Log(float64(logHistMax))/math.Log(growth))) + 2
+	h := &LogHist{
+		logGrowth: math.Log(growth),
+		buckets:   make([]float64, n+1),
+		counts:    make([]uint64, n),
+	}
+	h.buckets[0] = math.Inf(-1)
+	for k := 1; k < n; k++ {
+		h.buckets[k] = math.Pow(growth, float64(k-1)) / float64(time.Second)
+	}
+	h.buckets[n] = math.Inf(1)
+	return h
+}
+
+// Add counts d.
+func (h *LogHist) Add(d time.Duration) {
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	if h.total == 0 || d > h.max {
+		h.max = d
+	}
+	h.total++
+
+	k := 0
+	if d >= 1 {
+		k = int(math.Log(float64(d))/h.logGrowth) + 1
+		if k >= len(h.counts) {
+			k = len(h.counts) - 1
+		}
+	}
+	h.counts[k]++
+}
+
+// Count returns the number of durations added.
+func (h *LogHist) Count() uint64 {
+	return h.total
+}
+
+// Merge adds the durations counted in other, which must have the same
+// resolution, to h. If the resolutions differ, it returns
+// ErrBucketMismatch and leaves h unchanged.
+func (h *LogHist) Merge(other *LogHist) error {
+	if h.logGrowth != other.logGrowth || len(h.counts) != len(other.counts) {
+		return ErrBucketMismatch
+	}
+	if other.total == 0 {
+		return nil
+	}
+	if h.total == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if h.total == 0 || other.max > h.max {
+		h.max = other.max
+	}
+	for k, c := range other.counts {
+		h.counts[k] += c
+	}
+	h.total += other.total
+	return nil
+}
+
+// Reset empties the histogram, keeping its buckets.
+func (h *LogHist) Reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.total = 0
+	h.min, h.max = 0, 0
+}
+
+// Percentiles returns each percentile of the durations added, using the
+// same nearest rank as SamplePercentiles. The value is interpolated within
+// the bucket holding that rank, assuming the bucket's durations are spread
+// evenly, and is never outside the smallest and largest durations. If
+// nothing was added, every percentile is 0.
+func (h *LogHist) Percentiles(percentiles []float64) []time.Duration {
+	pDurations := make([]time.Duration, 0, len(percentiles))
+	for _, p := range percentiles {
+		pDurations = append(pDurations, h.percentile(p))
+	}
+	return pDurations
+}
+
+func (h *LogHist) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p * float64(h.total)))
+	if rank <= 1 {
+		return h.min
+	}
+	if rank >= h.total {
+		return h.max
+	}
+
+	var below uint64
+	for k, c := range h.counts {
+		if below+c < rank {
+			below += c
+			continue
+		}
+		lower, upper := float64(h.min), float64(h.max)
+		if k > 0 {
+			lower = math.Max(lower, h.buckets[k]*float64(time.Second))
+		}
+		if k < len(h.counts)-1 {
+			upper = math.Min(upper, h.buckets[k+1]*float64(time.Second))
+		}
+		frac := float64(rank-below) / float64(c)
+		return time.Duration(lower + (upper-lower)*frac)
+	}
+	return h.max
+}
+
+// Snapshot returns a copy of the counts as a HistSnapshot, which can be
+// diffed and merged with snapshots of other LogHists of the same
+// resolution.
+func (h *LogHist) Snapshot() HistSnapshot {
+	return HistSnapshot{
+		Counts:  append([]uint64(nil), h.counts...),
+		Buckets: h.buckets,
+	}
+}
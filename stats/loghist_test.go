@@ -0,0 +1,101 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogHistPercentiles(t *testing.T) {
+	h := NewLogHist(0.01)
+	for _, d := range hundred() {
+		h.Add(d)
+	}
+	if h.Count() != 100 {
+		t.Fatalf("Count() = %v, want 100", h.Count())
+	}
+
+	got := h.Percentiles([]float64{0, 0.5, 0.99, 1})
+	want := []time.Duration{time.Millisecond, 50 * time.Millisecond, 99 * time.Millisecond, 100 * time.Millisecond}
+	for i := range want {
+		// Within a bucket of the exact value.
+		if diff := float64(got[i]-want[i]) / float64(want[i]); diff < -0.01 || diff > 0.01 {
+			t.Errorf("Percentiles() = %v, want %v to within 1%%", got, want)
+			break
+		}
+	}
+}
+
+func TestLogHistMerge(t *testing.T) {
+	a, b := NewLogHist(0.01), NewLogHist(0.01)
+	a.Add(2 * time.Millisecond)
+	b.Add(time.Millisecond)
+	b.Add(3 * time.Millisecond)
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Merge() failed: %v", err)
+	}
+	if a.Count() != 3 {
+		t.Errorf("Count() after Merge = %v, want 3", a.Count())
+	}
+	if got := a.Percentiles([]float64{0, 1}); got[0] != time.Millisecond || got[1] != 3*time.Millisecond {
+		t.Errorf("min and max after Merge = %v, want [1ms 3ms]", got)
+	}
+
+	if err := a.Merge(NewLogHist(0.01)); err != nil || a.Count() != 3 {
+		t.Errorf("Merge() of an empty histogram = %v with %v values, want nil with 3", err, a.Count())
+	}
+
+	empty := NewLogHist(0.01)
+	if err := empty.Merge(a); err != nil || empty.Count() != 3 {
+		t.Errorf("Merge() into an empty histogram = %v with %v values, want nil with 3", err, empty.Count())
+	}
+	if got := empty.Percentiles([]float64{0}); got[0] != time.Millisecond {
+		t.Errorf("min after Merge into an empty histogram = %v, want 1ms", got[0])
+	}
+}
+
+func TestLogHistMergeMismatch(t *testing.T) {
+	a, b := NewLogHist(0.01), NewLogHist(0.05)
+	a.Add(time.Millisecond)
+	b.Add(time.Second)
+
+	if err := a.Merge(b); err != ErrBucketMismatch {
+		t.Fatalf("Merge() of a different resolution = %v, want %v", err, ErrBucketMismatch)
+	}
+	if a.Count() != 1 {
+		t.Errorf("Count() after a failed Merge = %v, want 1", a.Count())
+	}
+	if got := a.Percentiles([]float64{1}); got[0] != time.Millisecond {
+		t.Errorf("max after a failed Merge = %v, want 1ms", got[0])
+	}
+	if _, err := a.Snapshot().Diff(b.Snapshot()); err != ErrBucketMismatch {
+		t.Errorf("Diff() of snapshots of different resolutions = %v, want %v", err, ErrBucketMismatch)
+	}
+}
+
+func TestLogHistSnapshotReset(t *testing.T) {
+	h := NewLogHist(0.01)
+	h.Add(time.Millisecond)
+	h.Add(2 * time.Millisecond)
+	before := h.Snapshot()
+
+	h.Add(5 * time.Millisecond)
+	diff, err := h.Snapshot().Diff(before)
+	if err != nil || diff.Count() != 1 {
+		t.Fatalf("Diff() = %v values, %v, want 1 value", diff.Count(), err)
+	}
+
+	h.Reset()
+	if h.Count() != 0 {
+		t.Errorf("Count() after Reset = %v, want 0", h.Count())
+	}
+	h.Add(time.Millisecond)
+	after := h.Snapshot()
+	diff, err = after.Diff(before)
+	if err != ErrCounterReset {
+		t.Fatalf("Diff() across a Reset = %v, want %v", err, ErrCounterReset)
+	}
+	if diff.Count() != 1 {
+		t.Errorf("Diff() across a Reset has %v values, want the 1 since the reset", diff.Count())
+	}
+}
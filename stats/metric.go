@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/metrics"
+)
+
+// CheckMetric returns an error if the runtime doesn't support the metric
+// with the given kind. metrics.Read leaves an unsupported metric's value
+// as metrics.KindBad, so reading it as another kind panics; probes check
+// their metrics before starting, and are skipped if this fails.
+func CheckMetric(name string, kind metrics.ValueKind) error {
+	for _, d := range metrics.All() {
+		if d.Name != name {
+			continue
+		}
+		if d.Kind != kind {
+			return fmt.Errorf("metric %v is a %v, not a %v", name, kindName(d.Kind), kindName(kind))
+		}
+		return nil
+	}
+	return fmt.Errorf("metric %v isn't supported by %v", name, runtime.Version())
+}
+
+func kindName(kind metrics.ValueKind) string {
+	switch kind {
+	case metrics.KindUint64:
+		return "uint64"
+	case metrics.KindFloat64:
+		return "float64"
+	case metrics.KindFloat64Histogram:
+		return "histogram"
+	}
+	return fmt.Sprintf("kind %d", kind)
+}
+
+// Counters reads cumulative runtime/metrics counters, such as the
+// /cpu/classes metrics, and returns how much each grew between reads.
+// Counters can be uint64 or float64 metrics.
+type Counters struct {
+	samples []metrics.Sample
+	last    []float64
+}
+
+// NewCounters returns the counters for the named metrics, or an error if
+// the runtime doesn't support one of them as a counter. The first Diff is
+// since NewCounters.
+func NewCounters(names ...string) (*Counters, error) {
+	for _, name := range names {
+		if err := CheckMetric(name, metrics.KindFloat64); err != nil {
+			if CheckMetric(name, metrics.KindUint64) != nil {
+				return nil, err
+			}
+		}
+	}
+	c := &Counters{samples: make([]metrics.Sample, len(names))}
+	for i, name := range names {
+		c.samples[i].Name = name
+	}
+	c.last = c.read()
+	return c, nil
+}
+
+func (c *Counters) read() []float64 {
+	metrics.Read(c.samples)
+	values := make([]float64, len(c.samples))
+	for i, s := range c.samples {
+		if s.Value.Kind() == metrics.KindUint64 {
+			values[i] = float64(s.Value.Uint64())
+		} else {
+			values[i] = s.Value.Float64()
+		}
+	}
+	return values
+}
+
+// Diff returns how much each counter grew since the last Diff, in the order
+// they were named.
+func (c *Counters) Diff() []float64 {
+	cur := c.read()
+	diff := make([]float64, len(cur))
+	for i := range cur {
+		diff[i] = cur[i] - c.last[i]
+	}
+	c.last = cur
+	return diff
+}
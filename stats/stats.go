@@ -0,0 +1,126 @@
+// Package stats computes the percentiles of latency samples and of
+// runtime/metrics histograms.
+package stats
+
+import (
+	"math"
+	"runtime/metrics"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SamplePercentiles returns the value at each percentile of samples, where
+// each percentile is between 0 and 1, using the nearest-rank method: the
+// smallest sample that at least that fraction of the samples are at or
+// below. 0 is the smallest sample and 1 the largest. If there are no
+// samples, every percentile is 0. samples isn't modified.
+func SamplePercentiles(samples []time.Duration, percentiles []float64) []time.Duration {
+	var s Sorter
+	return s.Percentiles(samples, percentiles)
+}
+
+// Sorter computes percentiles as SamplePercentiles does, but reuses its
+// buffer for the sorted copy of the samples, so it only allocates the
+// result once the buffer has grown to the most samples it's given. It isn't
+// safe for concurrent use.
+type Sorter struct {
+	sorted durations
+}
+
+// Percentiles returns the value at each percentile of samples, as
+// SamplePercentiles does. samples isn't modified.
+func (s *Sorter) Percentiles(samples []time.Duration, percentiles []float64) []time.Duration {
+	s.sorted = append(s.sorted[:0], samples...)
+	// sort.Slice would allocate for its swapper and closure on every call.
+	sort.Sort(&s.sorted)
+	sorted := s.sorted
+
+	percentileDurations := make([]time.Duration, 0, len(percentiles))
+	for _, p := range percentiles {
+		if len(sorted) == 0 {
+			percentileDurations = append(percentileDurations, 0)
+			continue
+		}
+
+		// Truncating p*(n-1) would bias high percentiles of few samples
+		// down, e.g., the p99 of 2 samples would be the smaller one.
+		idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if idx < 0 {
+			idx = 0
+		} else if idx >= len(sorted) {
+			idx = len(sorted) - 1
+		}
+		percentileDurations = append(percentileDurations, sorted[idx])
+	}
+	return percentileDurations
+}
+
+// durations sorts samples in increasing order.
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// CountAbove returns the number of samples over each threshold.
+func CountAbove(samples []time.Duration, thresholds []time.Duration) []uint64 {
+	counts := make([]uint64, len(thresholds))
+	for _, s := range samples {
+		for i, t := range thresholds {
+			if s > t {
+				counts[i]++
+			}
+		}
+	}
+	return counts
+}
+
+// HistogramPercentiles returns each percentile of the values added to a
+// runtime/metrics histogram between the last and cur readings of it, as
+// HistSnapshot.Percentiles does. If the histogram was reset in between,
+// it's the percentiles of cur, and if the buckets changed, they're all 0.
+func HistogramPercentiles(cur, last *metrics.Float64Histogram, percentiles []float64) []time.Duration {
+	diff, _ := view(cur).Diff(view(last))
+	return diff.Percentiles(percentiles)
+}
+
+// HistogramCount returns the number of values added to a runtime/metrics
+// histogram between the last and cur readings of it, with the same
+// fallbacks as HistogramPercentiles.
+func HistogramCount(cur, last *metrics.Float64Histogram) uint64 {
+	diff, _ := view(cur).Diff(view(last))
+	return diff.Count()
+}
+
+// Seconds converts v seconds, as used by runtime/metrics, to a duration.
+func Seconds(v float64) time.Duration {
+	return time.Duration(v * float64(time.Second))
+}
+
+// Truncate drops the digits of d that are too small to matter at its
+// magnitude, keeping three or four significant digits.
+func Truncate(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Truncate(10 * time.Millisecond)
+	}
+	if d > time.Millisecond {
+		return d.Truncate(10 * time.Microsecond)
+	}
+	if d > time.Microsecond {
+		return d.Truncate(10 * time.Nanosecond)
+	}
+	return d
+}
+
+// PercentileName returns a short name for a percentile, such as "p99", or
+// "min" and "max" for 0 and 1.
+func PercentileName(p float64) string {
+	switch p {
+	case 0:
+		return "min"
+	case 1:
+		return "max"
+	}
+	return "p" + strconv.FormatFloat(p*100, 'g', -1, 64)
+}
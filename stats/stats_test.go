@@ -0,0 +1,214 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want time.Duration
+	}{
+		{0, 0},
+		{-5 * time.Millisecond, -5 * time.Millisecond},
+		{999, 999},
+		{time.Microsecond, time.Microsecond},
+		{1234 * time.Nanosecond, 1230 * time.Nanosecond},
+		{time.Millisecond, time.Millisecond},
+		{1234567 * time.Nanosecond, 1230 * time.Microsecond},
+		{time.Second, time.Second},
+		{1234567890 * time.Nanosecond, 1230 * time.Millisecond},
+		{90 * time.Second, 90 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := Truncate(tt.d); got != tt.want {
+			t.Errorf("Truncate(%v) = %v, want %v", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestPercentileName(t *testing.T) {
+	tests := []struct {
+		p    float64
+		want string
+	}{
+		{0, "min"},
+		{0.5, "p50"},
+		{0.9, "p90"},
+		{0.99, "p99"},
+		{0.999, "p99.9"},
+		{1, "max"},
+	}
+	for _, tt := range tests {
+		if got := PercentileName(tt.p); got != tt.want {
+			t.Errorf("PercentileName(%v) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestSeconds(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{0.0015, 1500 * time.Microsecond},
+		{64e-9, 64},
+	}
+	for _, tt := range tests {
+		if got := Seconds(tt.v); got != tt.want {
+			t.Errorf("Seconds(%v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestSamplePercentiles(t *testing.T) {
+	ms := time.Millisecond
+	standard := []float64{0, 0.5, 0.99, 1}
+	tests := []struct {
+		name        string
+		samples     []time.Duration
+		percentiles []float64
+		want        []time.Duration
+	}{
+		{
+			name:        "empty",
+			percentiles: standard,
+			want:        []time.Duration{0, 0, 0, 0},
+		},
+		{
+			name:        "one sample",
+			samples:     []time.Duration{5 * ms},
+			percentiles: standard,
+			want:        []time.Duration{5 * ms, 5 * ms, 5 * ms, 5 * ms},
+		},
+		{
+			name:        "two samples",
+			samples:     []time.Duration{2 * ms, 1 * ms},
+			percentiles: standard,
+			want:        []time.Duration{1 * ms, 1 * ms, 2 * ms, 2 * ms},
+		},
+		{
+			name:        "ties",
+			samples:     []time.Duration{3 * ms, 1 * ms, 3 * ms, 3 * ms},
+			percentiles: standard,
+			want:        []time.Duration{1 * ms, 3 * ms, 3 * ms, 3 * ms},
+		},
+		{
+			name:        "negative samples",
+			samples:     []time.Duration{-1 * ms, 2 * ms, 0},
+			percentiles: standard,
+			want:        []time.Duration{-1 * ms, 0, 2 * ms, 2 * ms},
+		},
+		{
+			name:        "p=0 and p=1",
+			samples:     []time.Duration{4 * ms, 9 * ms, 1 * ms, 7 * ms},
+			percentiles: []float64{0, 1},
+			want:        []time.Duration{1 * ms, 9 * ms},
+		},
+		{
+			name:        "hundred samples",
+			samples:     hundred(),
+			percentiles: []float64{0.01, 0.5, 0.9, 0.99, 0.999},
+			want:        []time.Duration{1 * ms, 50 * ms, 90 * ms, 99 * ms, 100 * ms},
+		},
+		{
+			name:        "unsorted percentiles",
+			samples:     hundred(),
+			percentiles: []float64{1, 0.5, 0},
+			want:        []time.Duration{100 * ms, 50 * ms, 1 * ms},
+		},
+		{
+			name:    "no percentiles",
+			samples: hundred(),
+			want:    []time.Duration{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := append([]time.Duration(nil), tt.samples...)
+			got := SamplePercentiles(samples, tt.percentiles)
+			if !equalDurations(got, tt.want) {
+				t.Errorf("SamplePercentiles(%v, %v) = %v, want %v", tt.samples, tt.percentiles, got, tt.want)
+			}
+			if !equalDurations(samples, tt.samples) {
+				t.Errorf("SamplePercentiles modified its samples to %v", samples)
+			}
+		})
+	}
+}
+
+func TestSorterReuse(t *testing.T) {
+	var s Sorter
+	first := s.Percentiles(hundred(), []float64{0.5})
+	second := s.Percentiles([]time.Duration{3, 1, 2}, []float64{0.5})
+	if first[0] != 50*time.Millisecond || second[0] != 2 {
+		t.Errorf("Percentiles = %v, then %v, want [50ms], then [2ns]", first, second)
+	}
+}
+
+// hundred returns the samples 100ms down to 1ms.
+func hundred() []time.Duration {
+	samples := make([]time.Duration, 100)
+	for i := range samples {
+		samples[i] = time.Duration(100-i) * time.Millisecond
+	}
+	return samples
+}
+
+func equalDurations(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// benchSamples returns n samples in a shuffled order.
+func benchSamples(n int) []time.Duration {
+	samples := make([]time.Duration, n)
+	for i := range samples {
+		samples[i] = time.Duration(i*7919%n) * time.Microsecond
+	}
+	return samples
+}
+
+func BenchmarkSamplePercentiles(b *testing.B) {
+	percentiles := []float64{0, 0.5, 0.99, 0.999, 1}
+	for _, n := range []int{1000, 100000} {
+		samples := benchSamples(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				SamplePercentiles(samples, percentiles)
+			}
+		})
+		b.Run(fmt.Sprintf("%v/sorter", n), func(b *testing.B) {
+			var s Sorter
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s.Percentiles(samples, percentiles)
+			}
+		})
+	}
+}
+
+// TestSorterAllocs checks that a Sorter only allocates the result once its
+// buffer has grown to the samples.
+func TestSorterAllocs(t *testing.T) {
+	samples := benchSamples(1000)
+	percentiles := []float64{0, 0.5, 0.99, 1}
+	var s Sorter
+	s.Percentiles(samples, percentiles)
+
+	if allocs := testing.AllocsPerRun(10, func() { s.Percentiles(samples, percentiles) }); allocs > 1 {
+		t.Errorf("Percentiles allocated %v times, want at most 1", allocs)
+	}
+}